@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"log"
 
 	"golang.org/x/crypto/bcrypt"
@@ -12,16 +13,16 @@ type Account struct {
 	HashedPassword []byte
 }
 
-func CreateAccount(email, password string) (*Account, error) {
-	var id string
+var errAccountNotFound = errors.New("account not found")
 
+func CreateAccount(email, password string) (*Account, error) {
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
 		return nil, err
 	}
 
-	query := "INSERT INTO account (email, password) VALUES ($1, $2) RETURNING id"
-	if err := db.QueryRow(query, email, string(hashedPassword)).Scan(&id); err != nil {
+	id, err := storage.CreateAccount(email, hashedPassword)
+	if err != nil {
 		return nil, err
 	}
 
@@ -29,14 +30,7 @@ func CreateAccount(email, password string) (*Account, error) {
 }
 
 func GetAccount(email string) (*Account, error) {
-	var id, password string
-
-	query := "SELECT id, password FROM account WHERE email = $1"
-	if err := db.QueryRow(query, email).Scan(&id, &password); err != nil {
-		return nil, err
-	}
-
-	return &Account{id, email, []byte(password)}, nil
+	return storage.Account(email)
 }
 
 func (a *Account) ValidatePassword(password []byte) bool {
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/context"
+)
+
+// The calendar feed shows the periods and occurrences from
+// calendarMonthsBack months ago to calendarMonthsAhead months from now.
+const (
+	calendarMonthsBack  = 3
+	calendarMonthsAhead = 3
+)
+
+var errCalendarNotFound = errors.New("calendar not found")
+
+// calendarEvent is an all-day event of the feed, from Start until the day
+// before End.
+type calendarEvent struct {
+	Uid         string
+	Summary     string
+	Description string
+	Start       time.Time
+	End         time.Time
+}
+
+// calendarPageHandler shows the secret URL of the calendar feed of the
+// account. Posting makes a new URL, the previous one stops working.
+func calendarPageHandler(w http.ResponseWriter, r *http.Request) error {
+	accountId := context.Get(r, "accountId").(string)
+
+	if r.Method == "POST" {
+		if _, err := resetCalendarToken(accountId); err != nil {
+			return err
+		}
+		http.Redirect(w, r, "/calendar", http.StatusFound)
+		return nil
+	} else if r.Method != "GET" {
+		return methodNotAllowed()
+	}
+
+	token, err := storage.CalendarToken(accountId)
+	if err != nil {
+		return err
+	}
+	data := struct {
+		URL string
+	}{}
+	if token != "" {
+		data.URL = requestHost(r) + "/calendar/" + token + ".ics"
+	}
+	return renderResponse(w, r, data, "calendar.html")
+}
+
+// calendarHandler serves the iCalendar feed of the account of the token
+// in the URL, /calendar/{token}.ics, to calendar apps that can't log in.
+func calendarHandler(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		return methodNotAllowed()
+	}
+	token := strings.TrimSuffix(r.URL.Path[len("/calendar/"):], ".ics")
+	if len(token) == 0 {
+		return badRequest(errors.New("token missing"))
+	}
+
+	accountId, err := storage.CalendarAccount(token)
+	if err == errCalendarNotFound {
+		return notFound(err)
+	} else if err != nil {
+		return err
+	}
+	// for the error log, the route clears it
+	context.Set(r, "accountId", accountId)
+
+	now := time.Now()
+	events, err := getCalendarEvents(accountId, now)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	return writeCalendar(w, events, now)
+}
+
+// getCalendarEvents returns the events of the feed:
+//
+//   - the periods of the habits counted per week or month, with the
+//     progress made in them
+//   - the days scheduled habits are due on, except when paused
+//   - the due dates of the goals
+//   - the progress logged on each day, per habit
+func getCalendarEvents(accountId string, now time.Time) ([]calendarEvent, error) {
+	habits, err := getHabits(accountId)
+	if err != nil {
+		return nil, err
+	}
+	pauses, err := storage.HabitPauses(accountId)
+	if err != nil {
+		return nil, err
+	}
+	goals, err := storage.Goals(accountId)
+	if err != nil {
+		return nil, err
+	}
+
+	today := PeriodDay.start(now)
+	from := today.AddDate(0, -calendarMonthsBack, 0)
+	to := today.AddDate(0, calendarMonthsAhead, 0)
+
+	var events []calendarEvent
+	for _, h := range habits {
+		if h.scheduled() {
+			events = append(events, occurrenceEvents(h, pauses[h.Id], from, to)...)
+		} else if h.Period != PeriodDay {
+			events = append(events, periodEvents(h, from, to)...)
+		}
+	}
+
+	for _, g := range goals {
+		if g.Due == nil {
+			continue
+		}
+		summary := fmt.Sprintf("Goal due: %s (%d/%d)", g.Description, g.PointsDone, g.PointsTotal)
+		if g.PointsDone >= g.PointsTotal {
+			summary = "Goal done: " + g.Description
+		}
+		events = append(events, calendarEvent{
+			Uid:     "goal-" + g.Id,
+			Summary: summary,
+			Start:   *g.Due,
+			End:     g.Due.AddDate(0, 0, 1),
+		})
+	}
+
+	progress, err := progressEvents(accountId, habits, from)
+	if err != nil {
+		return nil, err
+	}
+	return append(events, progress...), nil
+}
+
+// periodEvents returns an event per period of the habit overlapping from
+// until to. Periods that started show the progress made in them.
+func periodEvents(h habit, from, to time.Time) []calendarEvent {
+	done := make(map[string]float64)
+	for _, p := range h.history {
+		done[p.Start.Format(dateFormat)] = p.Done
+	}
+
+	var events []calendarEvent
+	for start := h.Period.start(from); start.Before(to); start = h.Period.next(start) {
+		if !h.Period.next(start).After(h.Start) {
+			continue
+		}
+		summary := fmt.Sprintf("%s: %s per %s", h.Description, formatAmount(h.Todo, h.Unit), h.Period)
+		if d, found := done[start.Format(dateFormat)]; found {
+			summary = fmt.Sprintf("%s: %s of %s", h.Description, formatAmount(d, ""), formatAmount(h.Todo, h.Unit))
+		}
+		events = append(events, calendarEvent{
+			Uid:     "period-" + h.Id + "-" + start.Format(dateFormat),
+			Summary: summary,
+			Start:   start,
+			End:     h.Period.next(start),
+		})
+	}
+	return events
+}
+
+// occurrenceEvents returns an event per day the scheduled habit is due on
+// from until to.
+func occurrenceEvents(h habit, pauses []habitPause, from, to time.Time) []calendarEvent {
+	var events []calendarEvent
+	for day := from; day.Before(to); day = day.AddDate(0, 0, 1) {
+		if !h.dueOn(day) || pausedOn(pauses, day) {
+			continue
+		}
+		events = append(events, calendarEvent{
+			Uid:     "due-" + h.Id + "-" + day.Format(dateFormat),
+			Summary: fmt.Sprintf("%s (%s)", h.Description, formatAmount(h.PerOccurrence, h.Unit)),
+			Start:   day,
+			End:     day.AddDate(0, 0, 1),
+		})
+	}
+	return events
+}
+
+func pausedOn(pauses []habitPause, day time.Time) bool {
+	for _, p := range pauses {
+		if p.overlaps(PeriodDay, day) {
+			return true
+		}
+	}
+	return false
+}
+
+// progressEvents returns an event per habit and day with progress logged
+// since from, with the notes of the entries as its description. Progress
+// of the habits not given, the retired ones, is left out.
+func progressEvents(accountId string, habits []habit, from time.Time) ([]calendarEvent, error) {
+	units := make(map[string]string)
+	for _, h := range habits {
+		units[h.Id] = h.Unit
+	}
+
+	type day struct {
+		habitId string
+		date    time.Time
+	}
+	var days []day
+	done := make(map[day]float64)
+	descriptions := make(map[day]string)
+	notes := make(map[day][]string)
+	err := storage.EachProgressEntry(accountId, from, func(e progressEntry) error {
+		if _, found := units[e.HabitId]; !found {
+			return nil
+		}
+		d := day{e.HabitId, PeriodDay.start(e.Created)}
+		if _, found := done[d]; !found {
+			days = append(days, d)
+		}
+		done[d] += e.Delta
+		descriptions[d] = e.Description
+		if e.Note != "" {
+			notes[d] = append(notes[d], e.Note)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var events []calendarEvent
+	for _, d := range days {
+		events = append(events, calendarEvent{
+			Uid:         "progress-" + d.habitId + "-" + d.date.Format(dateFormat),
+			Summary:     fmt.Sprintf("%s: %s", descriptions[d], formatAmount(done[d], units[d.habitId])),
+			Description: strings.Join(notes[d], "\n"),
+			Start:       d.date,
+			End:         d.date.AddDate(0, 0, 1),
+		})
+	}
+	return events, nil
+}
+
+// writeCalendar writes the events as an iCalendar (RFC 5545) file, oldest
+// first.
+func writeCalendar(w io.Writer, events []calendarEvent, now time.Time) error {
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Start.Before(events[j].Start)
+	})
+
+	var b strings.Builder
+	line := func(name, value string) {
+		writeCalendarLine(&b, name+":"+value)
+	}
+	line("BEGIN", "VCALENDAR")
+	line("VERSION", "2.0")
+	line("PRODID", "-//HabitCat//Habits//EN")
+	line("CALSCALE", "GREGORIAN")
+	line("X-WR-CALNAME", "HabitCat")
+	stamp := now.UTC().Format("20060102T150405Z")
+	for _, e := range events {
+		line("BEGIN", "VEVENT")
+		line("UID", e.Uid+"@habitcat")
+		line("DTSTAMP", stamp)
+		line("DTSTART;VALUE=DATE", e.Start.Format("20060102"))
+		line("DTEND;VALUE=DATE", e.End.Format("20060102"))
+		line("SUMMARY", escapeCalendarText(e.Summary))
+		if e.Description != "" {
+			line("DESCRIPTION", escapeCalendarText(e.Description))
+		}
+		line("TRANSP", "TRANSPARENT")
+		line("END", "VEVENT")
+	}
+	line("END", "VCALENDAR")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeCalendarLine folds the line to 75 bytes, continuation lines start
+// with a space, without splitting UTF-8 characters.
+func writeCalendarLine(b *strings.Builder, s string) {
+	limit := 75
+	for len(s) > limit {
+		i := limit
+		for i > 0 && s[i]&0xc0 == 0x80 {
+			i--
+		}
+		b.WriteString(s[:i] + "\r\n ")
+		s = s[i:]
+		limit = 74
+	}
+	b.WriteString(s + "\r\n")
+}
+
+func escapeCalendarText(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(s)
+}
+
+// resetCalendarToken gives the account a new feed token.
+func resetCalendarToken(accountId string) (string, error) {
+	secret := make([]byte, 24)
+	if _, err := io.ReadFull(rand.Reader, secret); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(secret)
+
+	return token, storage.SetCalendarToken(accountId, token)
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/context"
+)
+
+func TestWriteCalendarLine(t *testing.T) {
+	var b strings.Builder
+	writeCalendarLine(&b, "SUMMARY:"+strings.Repeat("é", 50))
+
+	lines := strings.Split(strings.TrimSuffix(b.String(), "\r\n"), "\r\n ")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines, got %q", b.String())
+	}
+	if len(lines[0]) > 75 || strings.Join(lines, "") != "SUMMARY:"+strings.Repeat("é", 50) {
+		t.Errorf("Expected the line folded between characters, got %q", lines)
+	}
+}
+
+func TestEscapeCalendarText(t *testing.T) {
+	got := escapeCalendarText("Run; fast, then\nrest \\o/")
+	if got != `Run\; fast\, then\nrest \\o/` {
+		t.Errorf("Expected escaped text, got %v", got)
+	}
+}
+
+// calendarUids returns the events by UID.
+func calendarUids(events []calendarEvent) map[string]calendarEvent {
+	uids := make(map[string]calendarEvent)
+	for _, e := range events {
+		uids[e.Uid] = e
+	}
+	return uids
+}
+
+func TestGetCalendarEvents(t *testing.T) {
+	s, restore := withMemoryStore()
+	defer restore()
+	now := time.Date(2017, time.March, 15, 12, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	account, _ := CreateAccount(emailForTests, passwordForTests)
+	h := newHabit("Run", 20, PeriodWeek, date(2017, time.March, 6))
+	h.Unit = "km"
+	runId, _ := createHabit(h, account.Id)
+	yoga := newHabit("Yoga", 1, PeriodWeek, date(2017, time.March, 1))
+	yoga.Schedule = ScheduleWeekdays
+	yoga.Weekdays = weekdaySet(0).add(time.Wednesday)
+	yoga.PerOccurrence = 1
+	yogaId, _ := createHabit(yoga, account.Id)
+	storage.CreateHabitPause(*yogaId, account.Id, &habitPause{Start: date(2017, time.March, 22), Finish: date(2017, time.March, 22)})
+	due := date(2017, time.April, 1)
+	createGoal(&goal{Description: "Read", PointsTotal: 12, Due: &due}, account.Id)
+	createGoal(&goal{Description: "Write", PointsTotal: 1}, account.Id)
+	storage.CreateProgressEntry(*runId, &progressEntry{Delta: 5, Note: "Hills"})
+	storage.CreateProgressEntry(*runId, &progressEntry{Delta: 2.5})
+
+	events, err := getCalendarEvents(account.Id, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uids := calendarUids(events)
+
+	period := uids["period-"+*runId+"-2017-03-13"]
+	if period.Summary != "Run: 7.5 of 20 km" || !period.End.Equal(date(2017, time.March, 20)) {
+		t.Errorf("Expected the current week with its progress, got %+v", period)
+	}
+	if e := uids["period-"+*runId+"-2017-03-20"]; e.Summary != "Run: 20 km per week" {
+		t.Errorf("Expected the next week, got %+v", e)
+	}
+	if _, found := uids["period-"+*runId+"-2017-02-27"]; found {
+		t.Errorf("Expected no period before the start of the habit")
+	}
+	if _, found := uids["due-"+*yogaId+"-2017-03-15"]; !found {
+		t.Errorf("Expected Yoga to be due on Wednesday")
+	}
+	if _, found := uids["due-"+*yogaId+"-2017-03-22"]; found {
+		t.Errorf("Expected no occurrence while paused")
+	}
+	if _, found := uids["period-"+*yogaId+"-2017-03-13"]; found {
+		t.Errorf("Expected occurrences instead of periods for scheduled habits")
+	}
+	if e := uids["progress-"+*runId+"-2017-03-15"]; e.Summary != "Run: 7.5 km" || e.Description != "Hills" {
+		t.Errorf("Expected the progress of the day, got %+v", e)
+	}
+
+	goals := 0
+	for _, e := range events {
+		if strings.HasPrefix(e.Uid, "goal-") {
+			goals++
+			if e.Summary != "Goal due: Read (0/12)" || !e.Start.Equal(due) {
+				t.Errorf("Expected the due date of Read, got %+v", e)
+			}
+		}
+	}
+	if goals != 1 {
+		t.Errorf("Expected only the goal with a due date, got %v", goals)
+	}
+}
+
+func TestCalendarProgressFrom(t *testing.T) {
+	defer withTestStore()()
+	account, _ := CreateAccount(emailForTests, passwordForTests)
+	defer truncateDatabase()
+	h := newHabit("Run", 20, PeriodWeek, date(2016, time.January, 4))
+	id, _ := createHabit(h, account.Id)
+	err := storage.CreateProgressEntries(account.Id, []progressEntry{
+		{HabitId: *id, Delta: 1, Created: time.Date(2017, time.February, 28, 23, 0, 0, 0, time.Local)},
+		{HabitId: *id, Delta: 2, Created: time.Date(2017, time.March, 1, 1, 0, 0, 0, time.Local)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := progressEvents(account.Id, []habit{{Id: *id}}, date(2017, time.March, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Summary != "Run: 2" {
+		t.Errorf("Expected the progress from March 1 only, got %+v", events)
+	}
+}
+
+func TestCalendarHandler(t *testing.T) {
+	defer withTestStore()()
+	account, _ := CreateAccount(emailForTests, passwordForTests)
+	defer truncateDatabase()
+	createHabit(newHabit("Run", 20, PeriodWeek, time.Now()), account.Id)
+
+	token, err := resetCalendarToken(account.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, _ := http.NewRequest("GET", "https://localhost/calendar/"+token+".ics", nil)
+	w := httptest.NewRecorder()
+	appHandler(calendarHandler).ServeHTTP(w, req)
+	context.Clear(req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %v", w.Code)
+	}
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/calendar") {
+		t.Errorf("Expected text/calendar, got %v", w.Header().Get("Content-Type"))
+	}
+	if !strings.HasPrefix(w.Body.String(), "BEGIN:VCALENDAR\r\n") || !strings.Contains(w.Body.String(), "SUMMARY:Run") {
+		t.Errorf("Expected a calendar with the habit, got %v", w.Body.String())
+	}
+
+	// a new token replaces the old one
+	resetCalendarToken(account.Id)
+	req, _ = http.NewRequest("GET", "https://localhost/calendar/"+token+".ics", nil)
+	w = httptest.NewRecorder()
+	appHandler(calendarHandler).ServeHTTP(w, req)
+	context.Clear(req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404, got %v", w.Code)
+	}
+}
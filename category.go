@@ -0,0 +1,75 @@
+package main
+
+import "sort"
+
+const maxCategoryLength = 30
+
+// habitCategory is a group of habits on the habits page with the subtotal
+// of its habits.
+type habitCategory struct {
+	Name    string
+	Habits  []habit
+	Done    float64
+	Todo    float64
+	PctDone int
+}
+
+// groupHabits groups the habits by category, sorted by name with the
+// habits without a category last. Within a category the habits keep their
+// order.
+func groupHabits(habits []habit) []habitCategory {
+	var categories []habitCategory
+	index := make(map[string]int)
+	for _, h := range habits {
+		i, ok := index[h.Category]
+		if !ok {
+			i = len(categories)
+			index[h.Category] = i
+			categories = append(categories, habitCategory{Name: h.Category})
+		}
+		categories[i].Habits = append(categories[i].Habits, h)
+	}
+
+	sort.Sort(byCategoryName(categories))
+	for i := range categories {
+		c := &categories[i]
+		c.Done, c.Todo = totalPointsThisWeek(c.Habits)
+		c.PctDone = calcPercentage(c.Done, c.Todo)
+	}
+	return categories
+}
+
+type byCategoryName []habitCategory
+
+func (c byCategoryName) Len() int      { return len(c) }
+func (c byCategoryName) Swap(i, j int) { c[i], c[j] = c[j], c[i] }
+func (c byCategoryName) Less(i, j int) bool {
+	if c[i].Name == "" || c[j].Name == "" {
+		return c[j].Name == "" && c[i].Name != ""
+	}
+	return c[i].Name < c[j].Name
+}
+
+// filterHabitsByTag returns the habits tagged with tag, or all habits if
+// tag is empty.
+func filterHabitsByTag(habits []habit, tag string) []habit {
+	if tag == "" {
+		return habits
+	}
+	var filtered []habit
+	for _, h := range habits {
+		if h.hasTag(tag) {
+			filtered = append(filtered, h)
+		}
+	}
+	return filtered
+}
+
+func (h habit) hasTag(tag string) bool {
+	for _, t := range h.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
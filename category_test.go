@@ -0,0 +1,43 @@
+package main
+
+import "testing"
+
+func TestGroupHabits(t *testing.T) {
+	habits := []habit{
+		habit{Id: "id1", Category: "Work", Todo: 2, Done: 1},
+		habit{Id: "id2", Todo: 1, Done: 1},
+		habit{Id: "id3", Category: "Health", Todo: 3, Done: 3},
+		habit{Id: "id4", Category: "Work", Todo: 2, Done: 2},
+	}
+	categories := groupHabits(habits)
+
+	var names []string
+	for _, c := range categories {
+		names = append(names, c.Name)
+	}
+	if len(names) != 3 || names[0] != "Health" || names[1] != "Work" || names[2] != "" {
+		t.Fatalf("Expected [Health Work ], got %v", names)
+	}
+	work := categories[1]
+	if len(work.Habits) != 2 || work.Habits[0].Id != "id1" || work.Habits[1].Id != "id4" {
+		t.Errorf("Expected id1 and id4 in order, got %v", work.Habits)
+	}
+	if work.Done != 3 || work.Todo != 4 || work.PctDone != 75 {
+		t.Errorf("Expected 3 / 4 (75%%), got %v / %v (%v%%)", work.Done, work.Todo, work.PctDone)
+	}
+}
+
+func TestFilterHabitsByTag(t *testing.T) {
+	habits := []habit{
+		habit{Id: "id1", Tags: []string{"morning", "outdoor"}},
+		habit{Id: "id2"},
+		habit{Id: "id3", Tags: []string{"outdoor"}},
+	}
+	if filtered := filterHabitsByTag(habits, ""); len(filtered) != 3 {
+		t.Errorf("Expected 3, got %v", len(filtered))
+	}
+	filtered := filterHabitsByTag(habits, "outdoor")
+	if len(filtered) != 2 || filtered[0].Id != "id1" || filtered[1].Id != "id3" {
+		t.Errorf("Expected id1 and id3, got %v", filtered)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// notification tells the owner of a habit how it's going.
+type notification struct {
+	To          string  `json:"-"`
+	URL         string  `json:"-"`
+	HabitId     string  `json:"habit_id"`
+	Description string  `json:"description"`
+	Done        float64 `json:"done"`
+	Todo        float64 `json:"todo"`
+	Text        string  `json:"text"`
+}
+
+// channel delivers notifications, e.g. by email.
+type channel interface {
+	deliver(n notification) error
+}
+
+// channels are the ways reminders can be delivered, keyed by the name
+// stored with the reminder.
+var channels = map[string]channel{
+	"email":   emailChannel{},
+	"webhook": webhookChannel{client: newWebhookClient()},
+}
+
+var errPrivateAddress = errors.New("webhooks can only be sent to public addresses")
+
+// publicIP reports whether ip is an address on the internet rather than one
+// of this server or its network.
+func publicIP(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsUnspecified() &&
+		!ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() && !ip.IsMulticast()
+}
+
+// dialPublic refuses connections to addresses that aren't public. It's
+// checked when dialing, after the name of the URL is resolved, so the name
+// can't be pointed at the private network once the URL is validated.
+func dialPublic(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+		return errPrivateAddress
+	}
+	return nil
+}
+
+// newWebhookClient returns a client posting to URLs given by accounts,
+// which only connects to public addresses and not through a proxy.
+func newWebhookClient() *http.Client {
+	dialer := &net.Dialer{Timeout: 10 * time.Second, Control: dialPublic}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: 10 * time.Second, Transport: transport}
+}
+
+// emailChannel mails the notification to the account.
+type emailChannel struct{}
+
+func (emailChannel) deliver(n notification) error {
+	return sender.Send(message{
+		To:      n.To,
+		Subject: "Reminder: " + n.Description,
+		Body:    n.Text + "\n",
+	})
+}
+
+// webhookChannel posts the notification as JSON to the URL of the
+// reminder.
+type webhookChannel struct {
+	client *http.Client
+}
+
+func (c webhookChannel) deliver(n notification) error {
+	b, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	resp, err := c.client.Post(n.URL, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded %v", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/context"
+)
+
+// appHandler is a view handler that reports failures by returning an
+// error instead of writing the error response itself.
+type appHandler func(w http.ResponseWriter, r *http.Request) error
+
+func (fn appHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := fn(w, r); err != nil {
+		handleError(w, r, err)
+	}
+}
+
+// httpError is an error that knows which status code it should be
+// reported with. Message is shown to the user, Err is only logged.
+type httpError struct {
+	Code    int
+	Message string
+	Err     error
+}
+
+func (e *httpError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%d %s: %v", e.Code, e.Message, e.Err)
+	}
+	return fmt.Sprintf("%d %s", e.Code, e.Message)
+}
+
+func badRequest(err error) error {
+	return &httpError{http.StatusBadRequest, "Bad request", err}
+}
+
+func notFound(err error) error {
+	return &httpError{http.StatusNotFound, "Not found", err}
+}
+
+func methodNotAllowed() error {
+	return &httpError{http.StatusMethodNotAllowed, "Method not allowed", nil}
+}
+
+func handleError(w http.ResponseWriter, r *http.Request, err error) {
+	var fields validationErrors
+	e, ok := err.(*httpError)
+	if !ok {
+		if errs, isValidation := err.(validationErrors); isValidation {
+			fields = errs
+			e = &httpError{http.StatusBadRequest, "Invalid input", err}
+		} else {
+			e = &httpError{http.StatusInternalServerError, "Something went wrong...", err}
+		}
+	}
+
+	log.Printf("%s %s account=%v: %v", r.Method, r.URL.Path, context.Get(r, "accountId"), err)
+
+	if wantsJSON(r) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(e.Code)
+		json.NewEncoder(w).Encode(struct {
+			Error  string           `json:"error"`
+			Fields validationErrors `json:"fields,omitempty"`
+		}{
+			e.Message,
+			fields,
+		})
+		return
+	}
+
+	data := struct {
+		Code    int
+		Message string
+	}{
+		e.Code,
+		e.Message,
+	}
+	w.Header().Set("Content-Type", "text/html")
+	w.WriteHeader(e.Code)
+	if err := templates.execute(w, "error.html", data); err != nil {
+		log.Println(err)
+	}
+}
+
+func wantsJSON(r *http.Request) bool {
+	acceptHeader, ok := r.Header["Accept"]
+	return ok && len(acceptHeader) > 0 && acceptHeader[0] == "application/json"
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAppHandlerInternalError(t *testing.T) {
+	req, err := http.NewRequest("GET", "https://localhost/habits", nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	appHandler(func(w http.ResponseWriter, r *http.Request) error {
+		return errors.New("pq: connection refused")
+	}).ServeHTTP(w, req)
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("Expected %v, got %v", http.StatusInternalServerError, w.Code)
+	}
+	expectedContentType := "text/html"
+	if w.Header().Get("Content-Type") != expectedContentType {
+		t.Errorf("Expected %v, got %v", expectedContentType, w.Header().Get("Content-Type"))
+	}
+	if strings.Contains(w.Body.String(), "connection refused") {
+		t.Errorf("Expected internal error to be hidden, got %v", w.Body.String())
+	}
+}
+
+func TestAppHandlerJSONError(t *testing.T) {
+	req, err := http.NewRequest("GET", "https://localhost/habits", nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Add("Accept", "application/json")
+
+	w := httptest.NewRecorder()
+	appHandler(func(w http.ResponseWriter, r *http.Request) error {
+		return notFound(errHabitNotFound)
+	}).ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected %v, got %v", http.StatusNotFound, w.Code)
+	}
+	expectedContentType := "application/json"
+	if w.Header().Get("Content-Type") != expectedContentType {
+		t.Errorf("Expected %v, got %v", expectedContentType, w.Header().Get("Content-Type"))
+	}
+	var body struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Error != "Not found" {
+		t.Errorf("Expected \"Not found\", got %v", body.Error)
+	}
+}
+
+func TestIndexHandlerNotFound(t *testing.T) {
+	req, err := http.NewRequest("GET", "https://localhost/nope", nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	appHandler(indexHandler).ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected %v, got %v", http.StatusNotFound, w.Code)
+	}
+}
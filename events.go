@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// The events webhooks can subscribe to.
+const (
+	eventProgressLogged  = "progress.logged"
+	eventPeriodCompleted = "period.completed"
+	eventGoalCompleted   = "goal.completed"
+	eventStreakBroken    = "streak.broken"
+)
+
+var webhookEvents = []string{eventProgressLogged, eventPeriodCompleted, eventGoalCompleted, eventStreakBroken}
+
+// event is the JSON payload posted to webhooks.
+type event struct {
+	Event   string      `json:"event"`
+	Created time.Time   `json:"created"`
+	Data    interface{} `json:"data"`
+}
+
+type habitPayload struct {
+	Id          string  `json:"id"`
+	Description string  `json:"description"`
+	Period      Period  `json:"period"`
+	Done        float64 `json:"done"`
+	Todo        float64 `json:"todo"`
+	Unit        string  `json:"unit"`
+	Streak      int     `json:"streak"`
+}
+
+func (h habit) payload() habitPayload {
+	return habitPayload{h.Id, h.Description, h.Period, h.Done, h.Todo, h.Unit, h.Streak}
+}
+
+// emit queues the event for the webhooks of the account subscribed to it.
+// Events with a key are queued only once per webhook, e.g. the completion
+// of a habit's period. Failing to queue is only logged, webhooks must not
+// get in the way of tracking progress.
+func emit(accountId, name, key string, data interface{}) {
+	payload, err := json.Marshal(event{name, time.Now().UTC(), data})
+	if err == nil {
+		err = storage.QueueWebhookEvent(accountId, name, key, string(payload))
+	}
+	if err != nil {
+		log.Printf("emitting %v account=%v: %v", name, accountId, err)
+	}
+}
+
+// emitProgress queues the events following progress logged on a habit,
+// before and after are the habit before and after the progress.
+func emitProgress(accountId string, before, after *habit, entry *progressEntry) {
+	emit(accountId, eventProgressLogged, "", struct {
+		Habit  habitPayload `json:"habit"`
+		Amount float64      `json:"amount"`
+		Note   string       `json:"note"`
+		Tags   []string     `json:"tags"`
+	}{after.payload(), entry.Delta, entry.Note, entry.Tags})
+
+	if after.Kind != KindLimit && before.Done < before.Todo && after.Done >= after.Todo && len(after.history) > 0 {
+		start := after.history[len(after.history)-1].Start
+		emit(accountId, eventPeriodCompleted, "period:"+after.Id+":"+start.Format(dateFormat), struct {
+			Habit       habitPayload `json:"habit"`
+			PeriodStart string       `json:"period_start"`
+		}{after.payload(), start.Format(dateFormat)})
+	}
+}
+
+func emitGoalCompleted(accountId string, g goal) {
+	emit(accountId, eventGoalCompleted, "goal:"+g.Id, struct {
+		Id          string `json:"id"`
+		Description string `json:"description"`
+		PointsTotal int    `json:"points_total"`
+	}{g.Id, g.Description, g.PointsTotal})
+}
+
+// brokenStreak finds the streak broken by the latest periods of the
+// history: the just finished period or, for limits, an exceeded current
+// one. It returns the start of the failed period and the length of the
+// streak before it.
+func brokenStreak(history []periodProgress) (time.Time, int, bool) {
+	last := len(history) - 1
+	for i := last; i >= 0 && i >= last-1; i-- {
+		p := history[i]
+		if p.skipped() {
+			continue
+		}
+		if _, failure := p.outcome(i != last); !failure {
+			if i == last {
+				continue
+			}
+			return time.Time{}, 0, false
+		}
+
+		streak := 0
+		for j := i - 1; j >= 0; j-- {
+			if history[j].skipped() {
+				continue
+			}
+			if success, _ := history[j].outcome(true); !success {
+				break
+			}
+			streak++
+		}
+		return p.Start, streak, streak > 0
+	}
+	return time.Time{}, 0, false
+}
+
+// emitBrokenStreaks queues streak.broken events for the accounts with
+// webhooks subscribed to them. Each broken streak is queued once thanks to
+// its key.
+func emitBrokenStreaks(now time.Time) error {
+	accounts, err := storage.WebhookAccounts(eventStreakBroken)
+	if err != nil {
+		return err
+	}
+
+	for _, accountId := range accounts {
+		habits, err := getHabits(accountId)
+		if err != nil {
+			log.Printf("streaks of account=%v: %v", accountId, err)
+			continue
+		}
+		for _, h := range habits {
+			start, streak, ok := brokenStreak(h.history)
+			if !ok {
+				continue
+			}
+			emit(accountId, eventStreakBroken, "streak:"+h.Id+":"+start.Format(dateFormat), struct {
+				Habit       habitPayload `json:"habit"`
+				PeriodStart string       `json:"period_start"`
+				Streak      int          `json:"streak"`
+			}{h.payload(), start.Format(dateFormat), streak})
+		}
+	}
+	return nil
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/context"
+)
+
+// exportVersion is the version of the JSON schema of exports, bumped when a
+// field changes meaning or goes away. Adding fields doesn't bump it.
+const exportVersion = 1
+
+// The archive served by /export is a zip of habitcat.json and a CSV file
+// per table. habitcat.json is an exportData:
+//
+//	{
+//	  "version": 1,
+//	  "exported": "2017-03-05T18:04:05Z",
+//	  "habits": [{
+//	    "id": "…", "description": "Run", "kind": "target", "category": "Health",
+//	    "tags": ["outdoor"], "unit": "km", "todo": 20, "period": "week",
+//	    "start": "2017-01-02", "schedule": "weekdays", "weekdays": [1, 3, 5],
+//	    "every_days": 0, "retired": null,
+//	    "pauses": [{"id": "…", "start": "2017-02-01", "finish": "2017-02-14", "reason": "Flu"}]
+//	  }],
+//	  "goals": [{"id": "…", "description": "Read 12 books", "points_done": 3,
+//	             "points_total": 12, "modified": "2017-03-01T09:12:00Z", "due": "2017-12-31"}],
+//	  "settings": {"summary": "week"},
+//	  "progress": [{"id": "…", "habit_id": "…", "amount": 5.5, "note": "Hills",
+//	                "tags": ["morning"], "created": "2017-03-04T07:30:00Z"}]
+//	}
+//
+// Dates are YYYY-MM-DD, times RFC 3339 in UTC. due is null for goals
+// without a due date, retired is null for habits that aren't retired and
+// when they were otherwise, retired habits and their progress are exported
+// too. Weekdays count from Sunday, 0, to Saturday, 6. todo is per
+// occurrence for scheduled habits. Progress comes last and oldest first so
+// it can be read as it is written. settings is always there, though import
+// accepts files without it.
+//
+// The CSV files have a header row and the same columns, with tags and
+// weekdays separated by commas: habits.csv, pauses.csv, progress.csv,
+// goals.csv and settings.csv.
+type exportData struct {
+	Version  int             `json:"version"`
+	Exported time.Time       `json:"exported"`
+	Habits   []exportHabit   `json:"habits"`
+	Goals    []exportGoal    `json:"goals"`
+	Settings *exportSettings `json:"settings"`
+	Progress []exportEntry   `json:"progress"`
+}
+
+type exportHabit struct {
+	Id          string        `json:"id"`
+	Description string        `json:"description"`
+	Kind        Kind          `json:"kind"`
+	Category    string        `json:"category"`
+	Tags        []string      `json:"tags"`
+	Unit        string        `json:"unit"`
+	Todo        float64       `json:"todo"`
+	Period      Period        `json:"period"`
+	Start       string        `json:"start"`
+	Schedule    Schedule      `json:"schedule"`
+	Weekdays    []int         `json:"weekdays"`
+	EveryDays   int           `json:"every_days"`
+	Retired     *time.Time    `json:"retired"`
+	Pauses      []exportPause `json:"pauses"`
+}
+
+type exportPause struct {
+	Id     string `json:"id"`
+	Start  string `json:"start"`
+	Finish string `json:"finish"`
+	Reason string `json:"reason"`
+}
+
+type exportGoal struct {
+	Id          string    `json:"id"`
+	Description string    `json:"description"`
+	PointsDone  int       `json:"points_done"`
+	PointsTotal int       `json:"points_total"`
+	Modified    time.Time `json:"modified"`
+	Due         *string   `json:"due"`
+}
+
+type exportSettings struct {
+	Summary Period `json:"summary"`
+}
+
+type exportEntry struct {
+	Id      string    `json:"id"`
+	HabitId string    `json:"habit_id"`
+	Amount  float64   `json:"amount"`
+	Note    string    `json:"note"`
+	Tags    []string  `json:"tags"`
+	Created time.Time `json:"created"`
+}
+
+// exportHandler sends the account's data as a zip archive. Progress is
+// read from the store while the archive is written, so the response can't
+// turn into an error page once it started, a failure cuts it short instead.
+func exportHandler(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "GET" {
+		return methodNotAllowed()
+	}
+	accountId := context.Get(r, "accountId").(string)
+
+	data, err := getExportData(accountId, time.Now())
+	if err != nil {
+		return err
+	}
+
+	filename := "habitcat-" + data.Exported.Format(dateFormat) + ".zip"
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	if err := writeExport(w, data, accountId); err != nil {
+		log.Printf("%s %s account=%v: export cut short: %v", r.Method, r.URL.Path, accountId, err)
+	}
+	return nil
+}
+
+// getExportData returns everything exported but the progress, which is
+// streamed by writeExport.
+func getExportData(accountId string, now time.Time) (*exportData, error) {
+	habits, err := storage.AllHabits(accountId)
+	if err != nil {
+		return nil, err
+	}
+	pauses, err := storage.HabitPauses(accountId)
+	if err != nil {
+		return nil, err
+	}
+	goals, err := storage.Goals(accountId)
+	if err != nil {
+		return nil, err
+	}
+
+	data := &exportData{
+		Version:  exportVersion,
+		Exported: now.UTC(),
+		Habits:   []exportHabit{},
+		Goals:    []exportGoal{},
+	}
+	for _, h := range habits {
+		data.Habits = append(data.Habits, newExportHabit(h, pauses[h.Id]))
+	}
+	for _, g := range goals {
+		e := exportGoal{g.Id, g.Description, g.PointsDone, g.PointsTotal, g.Modified.UTC(), nil}
+		if g.Due != nil {
+			due := g.Due.Format(dateFormat)
+			e.Due = &due
+		}
+		data.Goals = append(data.Goals, e)
+	}
+	s, err := storage.Settings(accountId)
+	if err != nil {
+		return nil, err
+	}
+	data.Settings = &exportSettings{s.Summary}
+	return data, nil
+}
+
+func newExportHabit(h habit, pauses []habitPause) exportHabit {
+	e := exportHabit{
+		Id:          h.Id,
+		Description: h.Description,
+		Kind:        h.Kind,
+		Category:    h.Category,
+		Tags:        nonNil(h.Tags),
+		Unit:        h.Unit,
+		Todo:        h.Todo,
+		Period:      h.Period,
+		Start:       h.Start.Format(dateFormat),
+		Schedule:    h.Schedule,
+		Weekdays:    []int{},
+		EveryDays:   h.EveryDays,
+		Pauses:      []exportPause{},
+	}
+	if h.scheduled() {
+		e.Todo = h.PerOccurrence
+	}
+	if h.Retired != nil {
+		retired := h.Retired.UTC()
+		e.Retired = &retired
+	}
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if h.Weekdays.has(d) {
+			e.Weekdays = append(e.Weekdays, int(d))
+		}
+	}
+	for _, p := range pauses {
+		e.Pauses = append(e.Pauses, exportPause{p.Id, p.Start.Format(dateFormat), p.Finish.Format(dateFormat), p.Reason})
+	}
+	return e
+}
+
+func newExportEntry(e progressEntry) exportEntry {
+	return exportEntry{e.Id, e.HabitId, e.Delta, e.Note, nonNil(e.Tags), e.Created.UTC()}
+}
+
+// nonNil keeps empty lists from being exported as null.
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
+
+// writeExport writes the archive. The progress of the account is read once
+// for habitcat.json and progress.csv, so both have the same entries even if
+// progress is logged meanwhile.
+func writeExport(w io.Writer, data *exportData, accountId string) error {
+	z := zip.NewWriter(w)
+
+	// a zip is written a file at a time, progress.csv is kept until
+	// habitcat.json is done
+	var progress bytes.Buffer
+	c := csv.NewWriter(&progress)
+	c.Write([]string{"id", "habit_id", "amount", "note", "tags", "created"})
+	if err := writeExportJSON(z, data, accountId, c); err != nil {
+		return err
+	}
+	c.Flush()
+	if err := c.Error(); err != nil {
+		return err
+	}
+
+	var habits, pauses [][]string
+	for _, h := range data.Habits {
+		retired := ""
+		if h.Retired != nil {
+			retired = h.Retired.Format(time.RFC3339)
+		}
+		habits = append(habits, []string{h.Id, h.Description, string(h.Kind), h.Category,
+			strings.Join(h.Tags, ","), h.Unit, formatNumber(h.Todo), string(h.Period), h.Start,
+			string(h.Schedule), joinInts(h.Weekdays), strconv.Itoa(h.EveryDays), retired})
+		for _, p := range h.Pauses {
+			pauses = append(pauses, []string{p.Id, h.Id, p.Start, p.Finish, p.Reason})
+		}
+	}
+	err := writeExportCSV(z, "habits.csv", []string{"id", "description", "kind", "category", "tags",
+		"unit", "todo", "period", "start", "schedule", "weekdays", "every_days", "retired"}, habits)
+	if err != nil {
+		return err
+	}
+	err = writeExportCSV(z, "pauses.csv", []string{"id", "habit_id", "start", "finish", "reason"}, pauses)
+	if err != nil {
+		return err
+	}
+
+	f, err := z.Create("progress.csv")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(progress.Bytes()); err != nil {
+		return err
+	}
+
+	var goals [][]string
+	for _, g := range data.Goals {
+		due := ""
+		if g.Due != nil {
+			due = *g.Due
+		}
+		goals = append(goals, []string{g.Id, g.Description, strconv.Itoa(g.PointsDone),
+			strconv.Itoa(g.PointsTotal), g.Modified.Format(time.RFC3339), due})
+	}
+	err = writeExportCSV(z, "goals.csv", []string{"id", "description", "points_done", "points_total", "modified", "due"}, goals)
+	if err != nil {
+		return err
+	}
+	err = writeExportCSV(z, "settings.csv", []string{"summary"}, [][]string{{string(data.Settings.Summary)}})
+	if err != nil {
+		return err
+	}
+	return z.Close()
+}
+
+// writeExportJSON writes habitcat.json. Everything but the progress is
+// encoded at once, the progress entry by entry, and every entry is written
+// to progress as well.
+func writeExportJSON(z *zip.Writer, data *exportData, accountId string, progress *csv.Writer) error {
+	f, err := z.Create("habitcat.json")
+	if err != nil {
+		return err
+	}
+
+	// encode data without the progress and reopen its list, relying on
+	// progress being the last field
+	head, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	head = head[:len(head)-len(`null}`)]
+	if _, err := f.Write(append(head, '[')); err != nil {
+		return err
+	}
+
+	sep := ""
+	err = storage.EachProgressEntry(accountId, time.Time{}, func(e progressEntry) error {
+		x := newExportEntry(e)
+		err := progress.Write([]string{x.Id, x.HabitId, formatNumber(x.Amount), x.Note,
+			strings.Join(x.Tags, ","), x.Created.Format(time.RFC3339)})
+		if err != nil {
+			return err
+		}
+		b, err := json.Marshal(x)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(f, sep); err != nil {
+			return err
+		}
+		sep = ","
+		_, err = f.Write(b)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(f, "]}\n")
+	return err
+}
+
+func writeExportCSV(z *zip.Writer, name string, header []string, records [][]string) error {
+	f, err := z.Create(name)
+	if err != nil {
+		return err
+	}
+	c := csv.NewWriter(f)
+	c.Write(header)
+	c.WriteAll(records)
+	return c.Error()
+}
+
+func formatNumber(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func joinInts(ints []int) string {
+	s := make([]string, len(ints))
+	for i, n := range ints {
+		s[i] = strconv.Itoa(n)
+	}
+	return strings.Join(s, ",")
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/context"
+)
+
+// getExport requests the export of the account and returns the files of
+// the archive by name.
+func getExport(t *testing.T, accountId string) map[string][]byte {
+	req, _ := http.NewRequest("GET", "https://localhost/export", nil)
+	context.Set(req, "accountId", accountId)
+	defer context.Clear(req)
+
+	w := httptest.NewRecorder()
+	appHandler(exportHandler).ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %v", w.Code)
+	}
+	if w.Header().Get("Content-Type") != "application/zip" {
+		t.Errorf("Expected \"application/zip\", got %v", w.Header().Get("Content-Type"))
+	}
+
+	z, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string][]byte)
+	for _, f := range z.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name], _ = io.ReadAll(r)
+		r.Close()
+	}
+	return files
+}
+
+func testExport(t *testing.T) {
+	account, _ := CreateAccount(emailForTests, passwordForTests)
+	other, _ := CreateAccount("other@habitcat.net", passwordForTests)
+	h := newHabit("Run", 20, PeriodWeek, time.Now())
+	h.Unit = "km"
+	h.Tags = []string{"outdoor"}
+	id, _ := createHabit(h, account.Id)
+	otherId, _ := createHabit(newHabit("Other", 1, PeriodDay, time.Now()), other.Id)
+	storage.CreateProgressEntry(*id, &progressEntry{Delta: 5.5, Note: "Hills, then \"flat\"", Tags: []string{"morning", "long run"}})
+	storage.CreateProgressEntry(*id, &progressEntry{Delta: 3})
+	storage.CreateProgressEntry(*otherId, &progressEntry{Delta: 1})
+	createGoal(&goal{Description: "Read", PointsTotal: 12}, account.Id)
+
+	files := getExport(t, account.Id)
+
+	var data exportData
+	if err := json.Unmarshal(files["habitcat.json"], &data); err != nil {
+		t.Fatal(err)
+	}
+	if data.Version != exportVersion {
+		t.Errorf("Expected version %v, got %v", exportVersion, data.Version)
+	}
+	if len(data.Habits) != 1 || data.Habits[0].Unit != "km" || data.Habits[0].Tags[0] != "outdoor" {
+		t.Errorf("Expected the habit of the account, got %+v", data.Habits)
+	}
+	if len(data.Goals) != 1 || data.Goals[0].PointsTotal != 12 {
+		t.Errorf("Expected the goal of the account, got %+v", data.Goals)
+	}
+	if len(data.Progress) != 2 {
+		t.Fatalf("Expected 2 entries, got %+v", data.Progress)
+	}
+	// entries logged within the same millisecond can come in any order
+	e := data.Progress[0]
+	if e.Amount != 5.5 {
+		e = data.Progress[1]
+	}
+	if e.Amount != 5.5 || e.HabitId != *id || len(e.Tags) != 2 {
+		t.Errorf("Expected the entry with tags, got %+v", data.Progress)
+	}
+
+	records, err := csv.NewReader(bytes.NewReader(files["progress.csv"])).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("Expected a header and 2 rows, got %v", records)
+	}
+	r := records[1]
+	if r[2] != "5.5" {
+		r = records[2]
+	}
+	if r[3] != "Hills, then \"flat\"" || r[4] != "morning,long run" {
+		t.Errorf("Expected the entry with a note, got %v", records)
+	}
+	for _, name := range []string{"habits.csv", "pauses.csv", "goals.csv", "settings.csv"} {
+		if _, found := files[name]; !found {
+			t.Errorf("Expected %v in the archive", name)
+		}
+	}
+}
+
+func TestExportHandlerMemory(t *testing.T) {
+	_, restore := withMemoryStore()
+	defer restore()
+	testExport(t)
+}
+
+func TestExportHandler(t *testing.T) {
+	requireDatabase(t)
+	defer truncateDatabase()
+	testExport(t)
+}
+
+func testExportRetired(t *testing.T) {
+	account, _ := CreateAccount(emailForTests, passwordForTests)
+	retired := time.Date(2017, 3, 1, 9, 30, 0, 0, time.UTC)
+	h := newHabit("Swim", 2, PeriodWeek, time.Now().AddDate(0, -1, 0))
+	h.Retired = &retired
+	id, err := createHabit(h, account.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = storage.Import(account.Id, &importPlan{Entries: []importEntry{{HabitId: *id, Delta: 1, Created: time.Now()}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := getExport(t, account.Id)
+
+	var data exportData
+	if err := json.Unmarshal(files["habitcat.json"], &data); err != nil {
+		t.Fatal(err)
+	}
+	if len(data.Habits) != 1 || data.Habits[0].Retired == nil || !data.Habits[0].Retired.Equal(retired) {
+		t.Errorf("Expected the retired habit, got %+v", data.Habits)
+	}
+	if len(data.Progress) != 1 || data.Progress[0].HabitId != *id {
+		t.Errorf("Expected the progress of the retired habit, got %+v", data.Progress)
+	}
+	records, err := csv.NewReader(bytes.NewReader(files["habits.csv"])).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[1][12] != "2017-03-01T09:30:00Z" {
+		t.Errorf("Expected the retired habit with when it was retired, got %v", records)
+	}
+}
+
+func TestExportHandlerRetiredMemory(t *testing.T) {
+	_, restore := withMemoryStore()
+	defer restore()
+	testExportRetired(t)
+}
+
+func TestExportHandlerRetired(t *testing.T) {
+	requireDatabase(t)
+	defer truncateDatabase()
+	testExportRetired(t)
+}
+
+func TestExportHandlerEmpty(t *testing.T) {
+	_, restore := withMemoryStore()
+	defer restore()
+	account, _ := CreateAccount(emailForTests, passwordForTests)
+
+	files := getExport(t, account.Id)
+
+	var data map[string]interface{}
+	if err := json.Unmarshal(files["habitcat.json"], &data); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"habits", "goals", "progress"} {
+		if list, ok := data[key].([]interface{}); !ok || len(list) != 0 {
+			t.Errorf("Expected an empty list of %v, got %v", key, data[key])
+		}
+	}
+	if _, ok := data["settings"].(map[string]interface{}); !ok {
+		t.Errorf("Expected the settings, got %v", data["settings"])
+	}
+}
@@ -1,12 +1,10 @@
 package main
 
 import (
+	"errors"
 	"fmt"
-	"log"
 	"net/http"
-	"sort"
-	"strconv"
-	"text/template"
+	"net/url"
 	"time"
 
 	"github.com/gorilla/context"
@@ -19,18 +17,19 @@ type goal struct {
 	PointsTotal int
 	PctDone     int
 	Modified    time.Time
+	// Due is the day the goal should be done by, nil if it has none.
+	Due *time.Time
 }
 
-type byModified []goal
+var errGoalNotFound = errors.New("goal not found")
 
-func (m byModified) Len() int           { return len(m) }
-func (m byModified) Swap(i, j int)      { m[i], m[j] = m[j], m[i] }
-func (m byModified) Less(i, j int) bool { return (m[i].Modified).Before(m[j].Modified) }
-
-func goalHandler(w http.ResponseWriter, r *http.Request) {
+func goalHandler(w http.ResponseWriter, r *http.Request) error {
 	var goalsInProgress, goalsDone []goal
 	accountId := context.Get(r, "accountId")
-	goals := getGoals(accountId.(string))
+	goals, err := storage.Goals(accountId.(string))
+	if err != nil {
+		return err
+	}
 	for _, g := range goals {
 		if g.PctDone >= 100 {
 			goalsDone = append(goalsDone, g)
@@ -45,151 +44,95 @@ func goalHandler(w http.ResponseWriter, r *http.Request) {
 		goalsInProgress,
 		goalsDone,
 	}
-	sort.Sort(sort.Reverse(byModified(context.InProgress)))
-	sort.Sort(sort.Reverse(byModified(context.Done)))
-
-	render(w, context)
-}
-
-func render(w http.ResponseWriter, goals interface{}) {
-	w.Header().Set("Content-Type", "text/html")
 
-	t, err := template.ParseFiles("templates/goals.html")
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	err = t.Execute(w, goals)
-	if err != nil {
-		log.Fatal(err)
-	}
+	return renderResponse(w, r, context, "goals.html")
 }
 
-func getGoals(accountId string) []goal {
-	var goals []goal
-
-	query := `SELECT id,
-                         description,
-                         ROUND(100.0 * points_done / points_total),
-                         points_done,
-                         points_total,
-                         modified
-                  FROM goal
-                  WHERE account_id = $1`
-
-	rows, err := db.Query(query, accountId)
-	if err != nil {
-		log.Fatal(err)
-	}
-	for rows.Next() {
-		var id, description string
-		var pctDone, pointsDone, pointsTotal int
-		var modified time.Time
-
-		if err := rows.Scan(&id, &description, &pctDone, &pointsDone, &pointsTotal, &modified); err != nil {
-			log.Fatal(err)
-		}
-		goals = append(goals, goal{
-			Id:          id,
-			Description: description,
-			PctDone:     pctDone,
-			PointsDone:  pointsDone,
-			PointsTotal: pointsTotal,
-			Modified:    modified,
-		})
-	}
-	if err := rows.Err(); err != nil {
-		log.Fatal(err)
-	}
-
-	return goals
-}
-
-func goalUpdateHandler(w http.ResponseWriter, r *http.Request) {
+func goalUpdateHandler(w http.ResponseWriter, r *http.Request) error {
 	if r.Method != "POST" {
-		http.Error(w, "", http.StatusMethodNotAllowed)
-		return
+		return methodNotAllowed()
 	}
 	uuid := r.URL.Path[len("/goals/"):]
 	if len(uuid) == 0 {
-		http.Error(w, "", http.StatusBadRequest)
-		return
+		return badRequest(errors.New("goal id missing"))
 	}
 
 	accountId := context.Get(r, "accountId")
-	newPct := updateGoalPoints(uuid, accountId.(string))
+	newPct, err := updateGoalPoints(uuid, accountId.(string))
+	if err == errGoalNotFound {
+		return notFound(err)
+	} else if err != nil {
+		return err
+	}
 
 	w.Header().Set("Content-Type", "text/plain")
-	fmt.Fprintf(w, fmt.Sprint(newPct))
+	fmt.Fprint(w, newPct)
+	return nil
 }
 
-func updateGoalPoints(uuid, accountId string) int {
-	_, err := db.Exec("UPDATE goal SET points_done = points_done + 1 WHERE id = $1 AND account_id = $2", uuid, accountId)
+func updateGoalPoints(uuid, accountId string) (int, error) {
+	g, err := storage.AddGoalPoint(uuid, accountId)
 	if err != nil {
-		log.Fatal(err)
+		return 0, err
 	}
-
-	var pctDone int
-	row := db.QueryRow("SELECT ROUND(100.0 * points_done / points_total) FROM goal WHERE id = $1 AND account_id = $2", uuid, accountId)
-	if err := row.Scan(&pctDone); err != nil {
-		log.Fatal(err)
+	// a point is added at a time, the goal is completed by the one that
+	// reached the total
+	if before := g.PointsDone - 1; before < g.PointsTotal && g.PointsDone >= g.PointsTotal {
+		emitGoalCompleted(accountId, *g)
 	}
-	return pctDone
+	return g.PctDone, nil
 }
 
-func goalNewHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "text/html")
-
-	t, err := template.ParseFiles("templates/goals_new.html")
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	err = t.Execute(w, nil)
-	if err != nil {
-		log.Fatal(err)
+func goalNewHandler(w http.ResponseWriter, r *http.Request) error {
+	defaults := url.Values{
+		"todo": {"1"},
 	}
+	return renderTemplate(w, "goals_new.html", formData{Values: defaults})
 }
 
-func goalCreateHandler(w http.ResponseWriter, r *http.Request) {
+func goalCreateHandler(w http.ResponseWriter, r *http.Request) error {
 	if r.Method != "POST" {
-		http.Error(w, "", http.StatusMethodNotAllowed)
-		return
+		return methodNotAllowed()
 	}
 
 	err := r.ParseForm()
 	if err != nil {
-		log.Fatal(err)
+		return badRequest(err)
 	}
 
-	// TODO validation
-	description := r.FormValue("description")
-	todo, err := strconv.Atoi(r.FormValue("todo"))
-	if err != nil {
-		log.Fatal(err)
+	newGoal, errs := validateGoalForm(r.PostForm)
+	if errs != nil {
+		return renderInvalidForm(w, r, "goals_new.html", errs)
 	}
 
-	newGoal := goal{
-		Description: description,
-		PointsTotal: todo,
-	}
 	accountId := context.Get(r, "accountId")
-	_, err = createGoal(&newGoal, accountId.(string))
+	_, err = createGoal(newGoal, accountId.(string))
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
 	http.Redirect(w, r, "/goals", http.StatusFound)
+	return nil
 }
 
 func createGoal(g *goal, accountId string) (*string, error) {
-	var id string
-
-	query := "INSERT INTO goal (description, points_total, account_id) VALUES ($1, $2, $3) RETURNING id"
-	err := db.QueryRow(query, g.Description, g.PointsTotal, accountId).Scan(&id)
+	id, err := storage.CreateGoal(g, accountId)
 	if err != nil {
 		return nil, err
 	}
-
 	return &id, nil
 }
+
+// getGoal returns the goal of the account with the given ID.
+func getGoal(uuid, accountId string) (*goal, error) {
+	goals, err := storage.Goals(accountId)
+	if err != nil {
+		return nil, err
+	}
+	for _, g := range goals {
+		if g.Id == uuid {
+			return &g, nil
+		}
+	}
+	return nil, errGoalNotFound
+}
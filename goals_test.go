@@ -1,15 +1,27 @@
 package main
 
-import "testing"
+import (
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/context"
+)
 
 func TestGetGoalsSuccess(t *testing.T) {
+	defer withTestStore()()
 	description := "doc"
 	points := 15
 	account, _ := CreateAccount("test@habitcat.net", "secret")
 	createGoal(&goal{Description: description, PointsTotal: points}, account.Id)
 	defer truncateDatabase()
 
-	goals := getGoals(account.Id)
+	goals, err := storage.Goals(account.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
 	if len(goals) != 1 {
 		t.Errorf("Expected 1, got %v found", len(goals))
 	}
@@ -28,3 +40,46 @@ func TestGetGoalsSuccess(t *testing.T) {
 		t.Errorf("Expected 0%%, got %d%%", goal.PctDone)
 	}
 }
+
+func TestGoalUpdateHandlerNotFound(t *testing.T) {
+	defer withTestStore()()
+	account, _ := CreateAccount(emailForTests, passwordForTests)
+	defer truncateDatabase()
+
+	url := "https://localhost/goals/" + uuidForTests
+	req, err := http.NewRequest("POST", url, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	context.Set(req, "accountId", account.Id)
+	defer context.Clear(req)
+
+	w := httptest.NewRecorder()
+	appHandler(goalUpdateHandler).ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected %v, got %v", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestCreateGoalHandlerBadTodo(t *testing.T) {
+	defer withTestStore()()
+	account, _ := CreateAccount(emailForTests, passwordForTests)
+	defer truncateDatabase()
+
+	url := "https://localhost/goals/create"
+	req, err := http.NewRequest("POST", url, strings.NewReader("description=d&todo=abc"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	context.Set(req, "accountId", account.Id)
+	defer context.Clear(req)
+
+	w := httptest.NewRecorder()
+	appHandler(goalCreateHandler).ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected %v, got %v", http.StatusBadRequest, w.Code)
+	}
+}
@@ -1,14 +1,14 @@
 package main
 
 import (
-	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"math"
 	"net/http"
+	"net/url"
 	"strconv"
-	"text/template"
+	"strings"
 	"time"
 
 	"github.com/gorilla/context"
@@ -17,202 +17,261 @@ import (
 type Period string
 
 const (
+	PeriodDay   Period = "day"
 	PeriodWeek  Period = "week"
 	PeriodMonth Period = "month"
 )
 
+// next returns the start of the period following the one starting at t.
+func (p Period) next(t time.Time) time.Time {
+	switch p {
+	case PeriodDay:
+		return t.AddDate(0, 0, 1)
+	case PeriodMonth:
+		return t.AddDate(0, 1, 0)
+	}
+	return t.AddDate(0, 0, 7)
+}
+
+// previous returns the start of the period before the one starting at t.
+func (p Period) previous(t time.Time) time.Time {
+	switch p {
+	case PeriodDay:
+		return t.AddDate(0, 0, -1)
+	case PeriodMonth:
+		return t.AddDate(0, -1, 0)
+	}
+	return t.AddDate(0, 0, -7)
+}
+
+// start returns the start of the period containing t, as a date in UTC
+// like the period starts of the history. Weeks start on Monday.
+func (p Period) start(t time.Time) time.Time {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	switch p {
+	case PeriodWeek:
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	case PeriodMonth:
+		return day.AddDate(0, 0, 1-day.Day())
+	}
+	return day
+}
+
+type Kind string
+
+const (
+	// KindTarget habits are done by reaching at least Todo.
+	KindTarget Kind = "target"
+	// KindLimit habits are done by staying at or below Todo, going over
+	// fails the period.
+	KindLimit Kind = "limit"
+)
+
 type habit struct {
 	Id          string
 	Description string
-	Todo        int
-	Done        int
+	Kind        Kind
+	Category    string
+	Tags        []string
+	Unit        string
+	Todo        float64
+	Done        float64
 	PctDone     int
+	Over        bool
+	Score       float64
+	TodoText    string
+	DoneText    string
 	Period      Period
 	Start       time.Time
+	Retired     *time.Time
+	Upcoming    bool
+	Paused      bool
+	Pause       *habitPause
+	Streak      int
+
+	Schedule      Schedule
+	Weekdays      weekdaySet
+	EveryDays     int
+	PerOccurrence float64
+	DueToday      bool
+	Missed        []time.Time
+
+	history []periodProgress
 }
 
-func habitHandler(w http.ResponseWriter, r *http.Request) {
+var errHabitNotFound = errors.New("habit not found")
+
+// habitHandler lists the habits grouped by category. The tag parameter
+// limits the list to the habits with that tag.
+func habitHandler(w http.ResponseWriter, r *http.Request) error {
 	accountId := context.Get(r, "accountId")
-	habits := getHabits(accountId.(string))
+	habits, err := getHabits(accountId.(string))
+	if err != nil {
+		return err
+	}
+	tag := normalizeTag(r.URL.Query().Get("tag"))
+	habits = filterHabitsByTag(habits, tag)
 	done, todo := totalPointsThisWeek(habits)
 	data := struct {
 		Habits            []habit
-		ThisWeekDone      int
-		ThisWeekTodo      int
+		Categories        []habitCategory
+		Tag               string
+		ThisWeekDone      float64
+		ThisWeekTodo      float64
 		CurrentWeekNumber int
 		ThisWeekPctDone   int
 	}{
 		habits,
+		groupHabits(habits),
+		tag,
 		done,
 		todo,
 		currentWeekNumber(time.Now()),
 		calcPercentage(done, todo),
 	}
 
-	renderResponse(w, r, data, "templates/habits.html")
+	return renderResponse(w, r, data, "habits.html")
 }
 
-func renderResponse(w http.ResponseWriter, r *http.Request, data interface{}, templatePath string) {
-	acceptHeader, ok := r.Header["Accept"]
-	if ok && len(acceptHeader) > 0 && acceptHeader[0] == "application/json" {
-		b, err := json.Marshal(data)
-		if err != nil {
-			log.Fatal(err)
-		}
+func renderResponse(w http.ResponseWriter, r *http.Request, data interface{}, templateName string) error {
+	if wantsJSON(r) {
+		return renderJSON(w, data)
+	}
+	return renderTemplate(w, templateName, data)
+}
 
-		w.Header().Set("Content-Type", "application/json")
+func renderJSON(w http.ResponseWriter, data interface{}) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
 
-		fmt.Fprint(w, string(b))
-	} else {
-		t, err := template.ParseFiles(templatePath)
-		if err != nil {
-			log.Fatal(err)
-		}
+	w.Header().Set("Content-Type", "application/json")
 
-		w.Header().Set("Content-Type", "text/html")
+	fmt.Fprint(w, string(b))
+	return nil
+}
 
-		err = t.Execute(w, data)
-		if err != nil {
-			log.Fatal(err)
-		}
+func getHabits(accountId string) ([]habit, error) {
+	habits, err := storage.Habits(accountId)
+	if err != nil {
+		return nil, err
 	}
-}
 
-func getHabits(accountId string) []habit {
-	query := `SELECT id,
-                    description,
-                    points,
-                    (SELECT coalesce(sum(delta), 0)
-                     FROM habit_progress p
-                     WHERE h.id = p.habit_id
-                       AND p.created >= date_trunc(h.period::text, now())
-                       AND p.created < date_trunc(h.period::text, now()) + ('1 ' || h.period)::interval),
-                    period,
-                    start
-                  FROM habit h
-                  WHERE h.account_id = $1 AND retired IS NULL`
-
-	rows, err := db.Query(query, accountId)
+	histories, err := storage.HabitHistories(accountId)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
+	}
+	if err := completeHabits(accountId, habits, histories); err != nil {
+		return nil, err
 	}
 
-	var habits []habit
-	var id, description, period string
-	var done, todo int
-	var start time.Time
+	return habits, nil
+}
 
-	for rows.Next() {
-		if err := rows.Scan(&id, &description, &todo, &done, &period, &start); err != nil {
-			log.Fatal(err)
-		}
-		habits = append(habits, habit{
-			Id:          id,
-			Description: description,
-			Todo:        todo,
-			Done:        done,
-			PctDone:     calcPercentage(done, todo),
-			Period:      Period(period),
-			Start:       start,
-		})
+func getHabit(uuid, accountId string) (*habit, error) {
+	h, err := storage.Habit(uuid, accountId)
+	if err != nil {
+		return nil, err
 	}
-	if err := rows.Err(); err != nil {
-		log.Fatal(err)
+
+	history, err := storage.HabitHistory(h.Id, accountId)
+	if err != nil {
+		return nil, err
+	}
+	habits := []habit{*h}
+	histories := map[string][]periodProgress{h.Id: history}
+	if err := completeHabits(accountId, habits, histories); err != nil {
+		return nil, err
 	}
 
-	return habits
+	return &habits[0], nil
 }
 
-func getHabit(uuid, accountId string) (*habit, error) {
-	query := `SELECT id,
-                    description,
-                    points,
-                    (SELECT coalesce(sum(delta), 0)
-                     FROM habit_progress p
-                     WHERE h.id = p.habit_id
-                       AND p.created >= date_trunc(h.period::text, now())
-                       AND p.created < date_trunc(h.period::text, now()) + ('1 ' || h.period)::interval),
-                    period,
-                    start
-                  FROM habit h
-                  WHERE h.id = $1 AND h.account_id = $2`
-
-	var id, description, period string
-	var done, todo int
-	var start time.Time
-
-	row := db.QueryRow(query, uuid, accountId)
-	if err := row.Scan(&id, &description, &todo, &done, &period, &start); err != nil {
-		if err == sql.ErrNoRows {
-			return nil, errors.New("habit not found")
-		} else {
-			log.Fatal(err)
-		}
+// completeHabits fills in the fields of the habits that are derived from
+// their history, pauses and schedule.
+func completeHabits(accountId string, habits []habit, histories map[string][]periodProgress) error {
+	pauses, err := storage.HabitPauses(accountId)
+	if err != nil {
+		return err
+	}
+	daily, err := storage.DailyProgress(accountId)
+	if err != nil {
+		return err
 	}
 
-	return &habit{
-		Id:          id,
-		Description: description,
-		Todo:        todo,
-		Done:        done,
-		PctDone:     calcPercentage(done, todo),
-		Period:      Period(period),
-		Start:       start,
-	}, nil
+	today := time.Now()
+	for i := range habits {
+		id := habits[i].Id
+		habits[i].applyHistory(histories[id], pauses[id], daily[id], today)
+	}
+	return nil
 }
 
-func habitUpdateHandler(w http.ResponseWriter, r *http.Request) {
+func habitUpdateHandler(w http.ResponseWriter, r *http.Request) error {
 	if r.Method != "POST" {
-		http.Error(w, "", http.StatusMethodNotAllowed)
-		return
+		return methodNotAllowed()
 	}
 	uuid := r.URL.Path[len("/habits/"):]
 	if len(uuid) == 0 {
-		http.Error(w, "", http.StatusBadRequest)
-		return
+		return badRequest(errors.New("habit id missing"))
 	}
 
-	w.Header().Set("Content-Type", "application/json")
+	entry, err := readProgressEntry(r)
+	if err != nil {
+		return err
+	}
 
 	accountId := context.Get(r, "accountId")
-	h, err := updateHabitProgress(uuid, accountId.(string))
-	if err != nil {
-		http.Error(w, "", http.StatusNotFound)
-		return
-	} else {
-		b, err := json.Marshal(h)
-		if err != nil {
-			log.Fatal(err)
-		}
-		fmt.Fprint(w, string(b))
+	h, err := updateHabitProgress(uuid, accountId.(string), entry)
+	if err == errHabitNotFound {
+		return notFound(err)
+	} else if err != nil {
+		return err
 	}
+	return renderJSON(w, h)
 }
 
-func updateHabitProgress(uuid, accountId string) (*habit, error) {
+func updateHabitProgress(uuid, accountId string, entry *progressEntry) (*habit, error) {
 	h, err := getHabit(uuid, accountId)
 	if err != nil {
-		return nil, errors.New("habit not found")
+		return nil, err
 	}
 
-	delta := 1
-	_, err = db.Exec("INSERT INTO habit_progress (habit_id, delta) VALUES ($1, $2)", h.Id, delta)
-	if err != nil {
-		log.Fatal(err)
+	if err := storage.CreateProgressEntry(h.Id, entry); err != nil {
+		return nil, err
 	}
 
-	// TODO improve this
-	h.Done = h.Done + delta
-	h.PctDone = calcPercentage(h.Done, h.Todo)
-	return h, nil
+	updated, err := getHabit(h.Id, accountId)
+	if err != nil {
+		return nil, err
+	}
+	emitProgress(accountId, h, updated, entry)
+	return updated, nil
 }
 
-func totalPointsThisWeek(habits []habit) (int, int) {
-	var todo, done int
+func totalPointsThisWeek(habits []habit) (float64, float64) {
+	var todo, done float64
 	for _, h := range habits {
-		done += h.Done
+		if h.Upcoming || h.Paused {
+			continue
+		}
+		done += h.score()
 		todo += h.Todo
 	}
-	return done, todo
+	return roundAmount(done), roundAmount(todo)
+}
+
+// score is what the habit adds to the done total of the week. Limits count
+// as fully done as long as they are not exceeded and not at all otherwise.
+func (h habit) score() float64 {
+	if h.Kind != KindLimit {
+		return h.Done
+	}
+	if h.Done > h.Todo {
+		return 0
+	}
+	return h.Todo
 }
 
 func currentWeekNumber(t time.Time) int {
@@ -220,64 +279,70 @@ func currentWeekNumber(t time.Time) int {
 	return week
 }
 
-func habitNewHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "text/html")
-
-	t, err := template.ParseFiles("templates/habits_new.html")
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	err = t.Execute(w, nil)
-	if err != nil {
-		log.Fatal(err)
+func habitNewHandler(w http.ResponseWriter, r *http.Request) error {
+	defaults := url.Values{
+		"period":     {string(PeriodWeek)},
+		"schedule":   {string(SchedulePeriod)},
+		"todo":       {"1"},
+		"every_days": {"2"},
+		"start":      {time.Now().Format(dateFormat)},
 	}
+	return renderTemplate(w, "habits_new.html", formData{Values: defaults})
 }
 
-func habitCreateHandler(w http.ResponseWriter, r *http.Request) {
+func habitCreateHandler(w http.ResponseWriter, r *http.Request) error {
 	if r.Method != "POST" {
-		http.Error(w, "", http.StatusMethodNotAllowed)
-		return
+		return methodNotAllowed()
 	}
 
 	err := r.ParseForm()
 	if err != nil {
-		log.Fatal(err)
+		return badRequest(err)
 	}
 
-	// TODO validation
-	todo, err := strconv.Atoi(r.FormValue("todo"))
-	if err != nil {
-		log.Fatal(err)
+	newHabit, errs := validateHabitForm(r.PostForm, time.Now())
+	if errs != nil {
+		return renderInvalidForm(w, r, "habits_new.html", errs)
 	}
-	period := Period(r.FormValue("period"))
-	description := r.FormValue("description")
 
-	newHabit := habit{
-		Description: description,
-		Period:      period,
-		Todo:        todo,
-	}
 	accountId := context.Get(r, "accountId")
-	createHabit(&newHabit, accountId.(string))
+	if _, err := createHabit(newHabit, accountId.(string)); err != nil {
+		return err
+	}
 
 	http.Redirect(w, r, "/habits", http.StatusFound)
+	return nil
 }
 
 func createHabit(h *habit, accountId string) (*string, error) {
-	var id string
-
-	query := "INSERT INTO habit (description, points, period, start, account_id) VALUES ($1, $2, $3, $4, $5) RETURNING id"
-	err := db.QueryRow(query, h.Description, h.Todo, string(h.Period), h.Start, accountId).Scan(&id)
+	id, err := storage.CreateHabit(h, accountId)
 	if err != nil {
 		return nil, err
 	}
-
 	return &id, nil
 }
 
-func calcPercentage(a, b int) int {
-	value := int(float64(a) / float64(b) * 100)
+// roundAmount gets rid of the float error of summed up amounts.
+func roundAmount(v float64) float64 {
+	return math.Floor(v*100+0.5) / 100
+}
+
+// formatAmount formats the amount with at most two decimals, followed by
+// the unit if there is one, e.g. "12.5 km".
+func formatAmount(v float64, unit string) string {
+	s := strconv.FormatFloat(v, 'f', 2, 64)
+	s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	if unit == "" {
+		return s
+	}
+	return s + " " + unit
+}
+
+func calcPercentage(a, b float64) int {
+	if b <= 0 {
+		return 0
+	}
+	value := int(a / b * 100)
 	if value > 100 {
 		value = 100
 	}
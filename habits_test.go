@@ -1,13 +1,13 @@
 package main
 
 import (
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
 	"net/http/httptest"
 	"os"
-	"os/exec"
 	"strings"
 	"testing"
 	"time"
@@ -19,32 +19,108 @@ const uuidForTests = "00000000-0000-0000-0000-000000000000"
 const emailForTests = "test@habitcat.net"
 const passwordForTests = "secret"
 
+// testDB is the test database, Postgres or SQLite depending on
+// DATABASE_URL, nil when neither is available.
+var testDB *sql.DB
+var testDialect dialect
+
 func TestMain(m *testing.M) {
-	var err error
-	db, err = createDBConnection("habitcat_test")
+	conn, d, err := connect("habitcat_test")
+	if err == nil {
+		defer conn.Close()
+
+		migrations, err := loadMigrations(migrationFiles, d.migrations)
+		if err != nil {
+			log.Fatalln("loading migrations failed:", err)
+		}
+		if _, err := migrateDatabase(conn, d, migrations); err != nil {
+			log.Fatalln("migrating db failed:", err)
+		}
+		testDB, testDialect = conn, d
+	} else {
+		log.Println("skipping the tests that need a database:", err)
+		storage = newMemoryStore()
+	}
+
+	templates, err = loadTemplates("templates", false)
 	if err != nil {
-		log.Fatalln("opening db connection failed:", err)
+		log.Fatalln("parsing templates failed:", err)
 	}
-	defer db.Close()
 
 	os.Exit(m.Run())
 }
 
+// requireDatabase skips tests that need the habitcat_test database when it
+// isn't available.
+func requireDatabase(t *testing.T) {
+	if testDB == nil {
+		t.Skip("no test database")
+	}
+}
+
+// requirePostgres skips tests of what only Postgres does, like advisory
+// locks and checking UUIDs, when testing on SQLite.
+func requirePostgres(t *testing.T) {
+	if testDB == nil || testDialect != postgres {
+		t.Skip("no Postgres test database")
+	}
+}
+
+// withMemoryStore makes the handlers use a new memoryStore until the
+// returned function is called, so nothing reaches the test database.
+func withMemoryStore() (*memoryStore, func()) {
+	s := newMemoryStore()
+	previous := storage
+	storage = s
+	return s, func() { storage = previous }
+}
+
+// withTestStore makes the handlers use the test database when there is one
+// and a new memoryStore until the returned function is called otherwise.
+func withTestStore() func() {
+	if testDB != nil {
+		return func() {}
+	}
+	_, restore := withMemoryStore()
+	return restore
+}
+
 func truncateDatabase() {
-	db.Exec("TRUNCATE habit CASCADE")
-	db.Exec("TRUNCATE goal CASCADE")
-	db.Exec("TRUNCATE account CASCADE")
+	if testDB == nil {
+		return
+	}
+	if testDialect == sqlite {
+		for _, table := range []string{"reminder_delivery", "habit_reminder", "habit_token_request", "habit_token",
+			"webhook_delivery", "webhook", "goal_progress", "goal", "habit_progress", "habit_pause", "habit",
+			"idempotency_key", "account"} {
+			testDB.Exec("DELETE FROM " + table)
+		}
+		return
+	}
+	testDB.Exec("TRUNCATE habit CASCADE")
+	testDB.Exec("TRUNCATE goal CASCADE")
+	testDB.Exec("TRUNCATE account CASCADE")
 }
 
 func createHabitProgress(id string, delta int, created *time.Time) {
-	if created == nil {
-		db.Exec("INSERT INTO habit_progress (habit_id, delta) VALUES ($1, $2)", id, delta)
+	if s, ok := storage.(*memoryStore); ok && testDB == nil {
+		now := s.now
+		if created != nil {
+			s.now = func() time.Time { return *created }
+		}
+		s.CreateProgressEntry(id, &progressEntry{Delta: float64(delta)})
+		s.now = now
+	} else if created == nil {
+		testDB.Exec("INSERT INTO habit_progress (habit_id, delta) VALUES ($1, $2)", id, delta)
+	} else if testDialect == sqlite {
+		testDB.Exec("INSERT INTO habit_progress (habit_id, delta, created) VALUES ($1, $2, $3)",
+			id, delta, sqliteTime(*created))
 	} else {
-		db.Exec("INSERT INTO habit_progress (habit_id, delta, created) VALUES ($1, $2, $3)", id, delta, *created)
+		testDB.Exec("INSERT INTO habit_progress (habit_id, delta, created) VALUES ($1, $2, $3)", id, delta, *created)
 	}
 }
 
-func newHabit(description string, points int, period Period, start time.Time) *habit {
+func newHabit(description string, points float64, period Period, start time.Time) *habit {
 	return &habit{
 		Description: description,
 		Todo:        points,
@@ -54,18 +130,22 @@ func newHabit(description string, points int, period Period, start time.Time) *h
 }
 
 func TestGetHabitsNoProgress(t *testing.T) {
+	defer withTestStore()()
 	account, _ := CreateAccount(emailForTests, passwordForTests)
 	createHabit(newHabit("Test", 1, PeriodWeek, time.Now()), account.Id)
 	defer truncateDatabase()
 
-	habits := getHabits(account.Id)
+	habits, err := getHabits(account.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
 	if len(habits) != 1 {
 		t.Errorf("Expected 1 habit %d found", len(habits))
 	}
 
 	habit := habits[0]
 	if habit.Done != 0 {
-		t.Errorf("Expected 0 points done %d found", habit.Done)
+		t.Errorf("Expected 0 points done %v found", habit.Done)
 	}
 	if habit.PctDone != 0 {
 		t.Errorf("Expected 0%% done %d%% found", habit.PctDone)
@@ -73,19 +153,23 @@ func TestGetHabitsNoProgress(t *testing.T) {
 }
 
 func TestGetHabitsWithProgress(t *testing.T) {
+	defer withTestStore()()
 	account, _ := CreateAccount(emailForTests, passwordForTests)
 	id, _ := createHabit(newHabit("Test", 1, PeriodWeek, time.Now()), account.Id)
 	createHabitProgress(*id, 1, nil)
 	defer truncateDatabase()
 
-	habits := getHabits(account.Id)
+	habits, err := getHabits(account.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
 	if len(habits) != 1 {
 		t.Errorf("Expected 1 habit %d found", len(habits))
 	}
 
 	habit := habits[0]
 	if habit.Done != 1 {
-		t.Errorf("Expected 1 point done %d found", habit.Done)
+		t.Errorf("Expected 1 point done %v found", habit.Done)
 	}
 	if habit.PctDone != 100 {
 		t.Errorf("Expected 100%% done %d%% found", habit.PctDone)
@@ -93,6 +177,7 @@ func TestGetHabitsWithProgress(t *testing.T) {
 }
 
 func TestGetHabitsWithProgressForCurrentPeriodOnly(t *testing.T) {
+	defer withTestStore()()
 	account, _ := CreateAccount(emailForTests, passwordForTests)
 	defer truncateDatabase()
 
@@ -102,21 +187,70 @@ func TestGetHabitsWithProgressForCurrentPeriodOnly(t *testing.T) {
 	createHabitProgress(*id, 1, &dt)
 	createHabitProgress(*id, 1, &now)
 
-	habits := getHabits(account.Id)
+	habits, err := getHabits(account.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
 	if len(habits) != 1 {
 		t.Errorf("Expected 1 habit %d found", len(habits))
 	}
 
 	habit := habits[0]
 	if habit.Done != 1 {
-		t.Errorf("Expected 1 point done %d found", habit.Done)
+		t.Errorf("Expected 1 point done %v found", habit.Done)
 	}
 	if habit.PctDone != 50 {
 		t.Errorf("Expected 50%% done %d%% found", habit.PctDone)
 	}
 }
 
+func TestGetHabitsIgnoresProgressBeforeStart(t *testing.T) {
+	defer withTestStore()()
+	account, _ := CreateAccount(emailForTests, passwordForTests)
+	defer truncateDatabase()
+
+	now := time.Now()
+	yesterday := now.AddDate(0, 0, -1)
+	id, _ := createHabit(newHabit("Test", 2, PeriodMonth, now), account.Id)
+	createHabitProgress(*id, 1, &yesterday)
+	createHabitProgress(*id, 1, &now)
+
+	habits, err := getHabits(account.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(habits) != 1 {
+		t.Fatalf("Expected 1 habit %d found", len(habits))
+	}
+	if habits[0].Done != 1 {
+		t.Errorf("Expected 1 point done %v found", habits[0].Done)
+	}
+}
+
+func TestGetHabitsUpcoming(t *testing.T) {
+	defer withTestStore()()
+	account, _ := CreateAccount(emailForTests, passwordForTests)
+	defer truncateDatabase()
+
+	createHabit(newHabit("Test", 2, PeriodWeek, time.Now().AddDate(0, 0, 10)), account.Id)
+
+	habits, err := getHabits(account.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(habits) != 1 {
+		t.Fatalf("Expected 1 habit %d found", len(habits))
+	}
+	if !habits[0].Upcoming {
+		t.Errorf("Expected habit to be upcoming")
+	}
+	if habits[0].Streak != 0 {
+		t.Errorf("Expected streak 0, got %v", habits[0].Streak)
+	}
+}
+
 func TestGetHabitExists(t *testing.T) {
+	defer withTestStore()()
 	account, _ := CreateAccount(emailForTests, passwordForTests)
 	id, _ := createHabit(newHabit("Test", 1, PeriodWeek, time.Now()), account.Id)
 	defer truncateDatabase()
@@ -131,6 +265,7 @@ func TestGetHabitExists(t *testing.T) {
 }
 
 func TestGetHabitNotFound(t *testing.T) {
+	defer withTestStore()()
 	account, _ := CreateAccount(emailForTests, passwordForTests)
 	defer truncateDatabase()
 	habit, err := getHabit("00000000-0000-0000-0000-000000000000", account.Id)
@@ -143,31 +278,26 @@ func TestGetHabitNotFound(t *testing.T) {
 }
 
 func TestGetHabitError(t *testing.T) {
-	// based on https://talks.golang.org/2014/testing.slide#23
-	// since this is run in a separate process, lines touched by
-	// this test will not appear in code coverage report
-
+	requirePostgres(t)
 	account, _ := CreateAccount(emailForTests, passwordForTests)
 	defer truncateDatabase()
-	if os.Getenv("BE_CRASHER") == "1" {
-		getHabit("bad", account.Id)
-		return
+
+	habit, err := getHabit("bad", account.Id)
+	if habit != nil {
+		t.Errorf("Expected nil, found %v", habit)
 	}
-	cmd := exec.Command(os.Args[0], "-test.run=TestGetHabitError")
-	cmd.Env = append(os.Environ(), "BE_CRASHER=1")
-	err := cmd.Run()
-	if e, ok := err.(*exec.ExitError); ok && !e.Success() {
-		return
+	if err == nil || err == errHabitNotFound {
+		t.Errorf("Expected database error, found %v", err)
 	}
-	t.Fatalf("process ran with err %v, want exit status 1", err)
 }
 
 func TestUpdateHabitProgressSuccess(t *testing.T) {
+	defer withTestStore()()
 	account, _ := CreateAccount(emailForTests, passwordForTests)
 	id, _ := createHabit(newHabit("Habit", 2, PeriodWeek, time.Now()), account.Id)
 	defer truncateDatabase()
 
-	h, err := updateHabitProgress(*id, account.Id)
+	h, err := updateHabitProgress(*id, account.Id, &progressEntry{Delta: 1})
 	if err != nil {
 		t.Errorf("Expected err to be nil, found %v", err)
 	}
@@ -180,11 +310,12 @@ func TestUpdateHabitProgressSuccess(t *testing.T) {
 }
 
 func TestUpdateHabitProgressNotFound(t *testing.T) {
+	defer withTestStore()()
 	account, _ := CreateAccount(emailForTests, passwordForTests)
 	createHabit(newHabit("Habit", 2, PeriodWeek, time.Now()), account.Id)
 	defer truncateDatabase()
 
-	newPct, err := updateHabitProgress(uuidForTests, account.Id)
+	newPct, err := updateHabitProgress(uuidForTests, account.Id, &progressEntry{Delta: 1})
 	if newPct != nil {
 		t.Errorf("Expected newPct to be nil, found %v", newPct)
 	}
@@ -194,12 +325,13 @@ func TestUpdateHabitProgressNotFound(t *testing.T) {
 }
 
 func TestHabitUpdateHandlerSuccess(t *testing.T) {
+	defer withTestStore()()
 	account, _ := CreateAccount(emailForTests, passwordForTests)
 	id, _ := createHabit(newHabit("Habit", 2, PeriodWeek, time.Now()), account.Id)
 	defer truncateDatabase()
 
 	url := "https://localhost/habits/" + *id
-	req, err := http.NewRequest("POST", url, nil)
+	req, err := http.NewRequest("POST", url, strings.NewReader(""))
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -207,7 +339,7 @@ func TestHabitUpdateHandlerSuccess(t *testing.T) {
 	defer context.Clear(req)
 
 	w := httptest.NewRecorder()
-	habitUpdateHandler(w, req)
+	appHandler(habitUpdateHandler).ServeHTTP(w, req)
 
 	if w.Code != http.StatusOK {
 		t.Errorf("Expected 200, got %v", w.Code)
@@ -225,12 +357,67 @@ func TestHabitUpdateHandlerSuccess(t *testing.T) {
 	}
 }
 
+func TestHabitUpdateHandlerAmount(t *testing.T) {
+	defer withTestStore()()
+	account, _ := CreateAccount(emailForTests, passwordForTests)
+	h := newHabit("Run", 20, PeriodWeek, time.Now())
+	h.Unit = "km"
+	id, _ := createHabit(h, account.Id)
+	defer truncateDatabase()
+
+	url := "https://localhost/habits/" + *id
+	req, err := http.NewRequest("POST", url, strings.NewReader("amount=7.5"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	context.Set(req, "accountId", account.Id)
+	defer context.Clear(req)
+
+	w := httptest.NewRecorder()
+	appHandler(habitUpdateHandler).ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected 200, got %v", w.Code)
+	}
+	var got habit
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Done != 7.5 {
+		t.Errorf("Expected 7.5, got %v", got.Done)
+	}
+	if got.DoneText != "7.5 km" || got.TodoText != "20 km" {
+		t.Errorf("Expected 7.5 km / 20 km, got %v / %v", got.DoneText, got.TodoText)
+	}
+	if got.PctDone != 37 {
+		t.Errorf("Expected 37, got %v", got.PctDone)
+	}
+}
+
+func TestHabitUpdateHandlerBadAmount(t *testing.T) {
+	url := "https://localhost/habits/" + uuidForTests
+	req, err := http.NewRequest("POST", url, strings.NewReader("amount=lots"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	w := httptest.NewRecorder()
+	appHandler(habitUpdateHandler).ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected %v, got %v", http.StatusBadRequest, w.Code)
+	}
+}
+
 func TestHabitUpdateHandlerNotFound(t *testing.T) {
+	defer withTestStore()()
 	account, _ := CreateAccount(emailForTests, passwordForTests)
 	defer truncateDatabase()
 
 	url := "https://localhost/habits/" + uuidForTests
-	req, err := http.NewRequest("POST", url, nil)
+	req, err := http.NewRequest("POST", url, strings.NewReader(""))
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -238,7 +425,7 @@ func TestHabitUpdateHandlerNotFound(t *testing.T) {
 	defer context.Clear(req)
 
 	w := httptest.NewRecorder()
-	habitUpdateHandler(w, req)
+	appHandler(habitUpdateHandler).ServeHTTP(w, req)
 
 	if w.Code != http.StatusNotFound {
 		t.Errorf("Expected 404, got %v", w.Code)
@@ -253,7 +440,7 @@ func TestHabitUpdateHandlerWrongUUID(t *testing.T) {
 	}
 
 	w := httptest.NewRecorder()
-	habitUpdateHandler(w, req)
+	appHandler(habitUpdateHandler).ServeHTTP(w, req)
 
 	if w.Code != http.StatusBadRequest {
 		t.Errorf("Expected %v, got %v", http.StatusNotFound, w.Code)
@@ -268,7 +455,7 @@ func TestHabitUpdateHandlerWrongMethod(t *testing.T) {
 	}
 
 	w := httptest.NewRecorder()
-	habitUpdateHandler(w, req)
+	appHandler(habitUpdateHandler).ServeHTTP(w, req)
 
 	if w.Code != http.StatusMethodNotAllowed {
 		t.Errorf("Expected %v, got %v", http.StatusMethodNotAllowed, w.Code)
@@ -277,7 +464,8 @@ func TestHabitUpdateHandlerWrongMethod(t *testing.T) {
 
 func TestTotalPointsThisWeek(t *testing.T) {
 	habits := []habit{
-		habit{"id1", "description", 2, 1, 50, PeriodWeek, time.Now()},
+		habit{Id: "id1", Description: "description", Todo: 2, Done: 1, PctDone: 50, Period: PeriodWeek, Start: time.Now()},
+		habit{Id: "id2", Description: "upcoming", Todo: 3, Period: PeriodWeek, Start: time.Now().AddDate(0, 0, 7), Upcoming: true},
 	}
 	done, todo := totalPointsThisWeek(habits)
 	if done != 1 {
@@ -288,6 +476,20 @@ func TestTotalPointsThisWeek(t *testing.T) {
 	}
 }
 
+func TestTotalPointsThisWeekLimits(t *testing.T) {
+	habits := []habit{
+		habit{Id: "id1", Description: "under", Kind: KindLimit, Todo: 3, Done: 1, Period: PeriodWeek, Start: time.Now()},
+		habit{Id: "id2", Description: "over", Kind: KindLimit, Todo: 2, Done: 4, Period: PeriodWeek, Start: time.Now()},
+	}
+	done, todo := totalPointsThisWeek(habits)
+	if done != 3 {
+		t.Errorf("Expected 3, got %v", done)
+	}
+	if todo != 5 {
+		t.Errorf("Expected 5, got %v", todo)
+	}
+}
+
 func TestCurrentWeekNumber(t *testing.T) {
 	dt := time.Date(2016, time.January, 11, 23, 0, 0, 0, time.UTC)
 	week := currentWeekNumber(dt)
@@ -297,11 +499,12 @@ func TestCurrentWeekNumber(t *testing.T) {
 }
 
 func TestCreateHabitSuccessful(t *testing.T) {
+	defer withTestStore()()
 	account, _ := CreateAccount(emailForTests, passwordForTests)
 	defer truncateDatabase()
 
 	description := "description"
-	todo := 33
+	todo := 33.0
 	period := PeriodMonth
 	start := time.Date(2016, time.January, 11, 0, 0, 0, 0, time.UTC)
 	id, _ := createHabit(newHabit(description, todo, period, start), account.Id)
@@ -322,14 +525,43 @@ func TestCreateHabitSuccessful(t *testing.T) {
 	if habit.Start.Format("2006-01-11") != start.Format("2006-01-11") {
 		t.Errorf("Expected %v, got %v", start.Format("2006-01-11"), habit.Start.Format("2006-01-11"))
 	}
+	if habit.Kind != KindTarget {
+		t.Errorf("Expected %v, got %v", KindTarget, habit.Kind)
+	}
+}
+
+func TestCreateHabitLimit(t *testing.T) {
+	defer withTestStore()()
+	account, _ := CreateAccount(emailForTests, passwordForTests)
+	defer truncateDatabase()
+
+	h := newHabit("coffee", 2, PeriodDay, time.Now())
+	h.Kind = KindLimit
+	id, _ := createHabit(h, account.Id)
+	updateHabitProgress(*id, account.Id, &progressEntry{Delta: 3})
+
+	habit, err := getHabit(*id, account.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if habit.Kind != KindLimit {
+		t.Errorf("Expected %v, got %v", KindLimit, habit.Kind)
+	}
+	if !habit.Over {
+		t.Errorf("Expected limit to be exceeded")
+	}
+	if habit.Score != 0 {
+		t.Errorf("Expected 0, got %v", habit.Score)
+	}
 }
 
 func TestCreateHabitFailure(t *testing.T) {
+	defer withTestStore()()
 	account, _ := CreateAccount(emailForTests, passwordForTests)
 	defer truncateDatabase()
 
 	description := "description"
-	todo := 33
+	todo := 33.0
 	period := Period("badperiod")
 	start := time.Date(2016, time.January, 11, 0, 0, 0, 0, time.UTC)
 	id, err := createHabit(newHabit(description, todo, period, start), account.Id)
@@ -349,7 +581,7 @@ func TestNewHabitHandlerSuccess(t *testing.T) {
 	}
 
 	w := httptest.NewRecorder()
-	habitNewHandler(w, req)
+	appHandler(habitNewHandler).ServeHTTP(w, req)
 
 	if w.Code != http.StatusOK {
 		t.Errorf("Expected 200, got %v", w.Code)
@@ -361,14 +593,15 @@ func TestNewHabitHandlerSuccess(t *testing.T) {
 }
 
 func TestCreateHabitHandlerSuccess(t *testing.T) {
+	defer withTestStore()()
 	account, _ := CreateAccount(emailForTests, passwordForTests)
 	defer truncateDatabase()
 
 	url := "https://localhost/habits/create"
 	description := "d"
 	period := PeriodWeek
-	todo := 33
-	body := fmt.Sprintf("description=%s&period=%s&todo=%d", description, string(period), todo)
+	todo := 33.5
+	body := fmt.Sprintf("description=%s&period=%s&todo=%v", description, string(period), todo)
 	req, err := http.NewRequest("POST", url, strings.NewReader(body))
 	if err != nil {
 		log.Fatal(err)
@@ -379,14 +612,17 @@ func TestCreateHabitHandlerSuccess(t *testing.T) {
 	defer context.Clear(req)
 
 	w := httptest.NewRecorder()
-	habitCreateHandler(w, req)
+	appHandler(habitCreateHandler).ServeHTTP(w, req)
 
 	if w.Code != http.StatusFound {
 		t.Errorf("Expected %v, got %v", http.StatusFound, w.Code)
 	}
 
 	// make sure habit was created
-	habits := getHabits(account.Id)
+	habits, err := getHabits(account.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
 	if len(habits) != 1 {
 		t.Errorf("Expected 1 habit %d found", len(habits))
 	}
@@ -402,6 +638,124 @@ func TestCreateHabitHandlerSuccess(t *testing.T) {
 	}
 }
 
+func TestCreateHabitHandlerStartDate(t *testing.T) {
+	defer withTestStore()()
+	account, _ := CreateAccount(emailForTests, passwordForTests)
+	defer truncateDatabase()
+
+	url := "https://localhost/habits/create"
+	body := "description=d&period=week&todo=1&start=2016-01-11"
+	req, err := http.NewRequest("POST", url, strings.NewReader(body))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	context.Set(req, "accountId", account.Id)
+	defer context.Clear(req)
+
+	w := httptest.NewRecorder()
+	appHandler(habitCreateHandler).ServeHTTP(w, req)
+
+	if w.Code != http.StatusFound {
+		t.Errorf("Expected %v, got %v", http.StatusFound, w.Code)
+	}
+	habits, err := getHabits(account.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(habits) != 1 {
+		t.Fatalf("Expected 1 habit %d found", len(habits))
+	}
+	if habits[0].Start.Format(dateFormat) != "2016-01-11" {
+		t.Errorf("Expected 2016-01-11, got %v", habits[0].Start.Format(dateFormat))
+	}
+}
+
+func TestCreateHabitHandlerBadTodo(t *testing.T) {
+	defer withTestStore()()
+	account, _ := CreateAccount(emailForTests, passwordForTests)
+	defer truncateDatabase()
+
+	url := "https://localhost/habits/create"
+	req, err := http.NewRequest("POST", url, strings.NewReader("description=d&period=week&todo=abc"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	context.Set(req, "accountId", account.Id)
+	defer context.Clear(req)
+
+	w := httptest.NewRecorder()
+	appHandler(habitCreateHandler).ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected %v, got %v", http.StatusBadRequest, w.Code)
+	}
+
+	// make sure nothing was created
+	habits, err := getHabits(account.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(habits) != 0 {
+		t.Errorf("Expected 0 habits %d found", len(habits))
+	}
+}
+
+func TestCreateHabitHandlerInvalidForm(t *testing.T) {
+	url := "https://localhost/habits/create"
+	body := "description=%3Cb%3Ekeep+me%3C%2Fb%3E&period=week&todo=0"
+	req, err := http.NewRequest("POST", url, strings.NewReader(body))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	w := httptest.NewRecorder()
+	appHandler(habitCreateHandler).ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected %v, got %v", http.StatusBadRequest, w.Code)
+	}
+	if !strings.Contains(w.Body.String(), `value="&lt;b&gt;keep me&lt;/b&gt;"`) {
+		t.Errorf("Expected description to be preserved, got %v", w.Body.String())
+	}
+	if !strings.Contains(w.Body.String(), "Must be greater than zero") {
+		t.Errorf("Expected todo error, got %v", w.Body.String())
+	}
+}
+
+func TestCreateHabitHandlerInvalidJSON(t *testing.T) {
+	url := "https://localhost/habits/create"
+	req, err := http.NewRequest("POST", url, strings.NewReader("description=&period=fortnight&todo=1"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Add("Accept", "application/json")
+
+	w := httptest.NewRecorder()
+	appHandler(habitCreateHandler).ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected %v, got %v", http.StatusBadRequest, w.Code)
+	}
+	var resp struct {
+		Fields map[string]string
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Fields["description"] == "" || resp.Fields["period"] == "" {
+		t.Errorf("Expected description and period errors, got %v", resp.Fields)
+	}
+	if _, ok := resp.Fields["todo"]; ok {
+		t.Errorf("Expected no todo error, got %v", resp.Fields["todo"])
+	}
+}
+
 func TestCreateHabitHandlerWrongMethod(t *testing.T) {
 	url := "https://localhost/habits/create"
 	req, err := http.NewRequest("GET", url, nil)
@@ -411,7 +765,7 @@ func TestCreateHabitHandlerWrongMethod(t *testing.T) {
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
 	w := httptest.NewRecorder()
-	habitCreateHandler(w, req)
+	appHandler(habitCreateHandler).ServeHTTP(w, req)
 
 	if w.Code != http.StatusMethodNotAllowed {
 		t.Errorf("Expected %v, got %v", http.StatusMethodNotAllowed, w.Code)
@@ -419,6 +773,7 @@ func TestCreateHabitHandlerWrongMethod(t *testing.T) {
 }
 
 func TestHabitHandlerSuccess(t *testing.T) {
+	defer withTestStore()()
 	account, _ := CreateAccount(emailForTests, passwordForTests)
 	defer truncateDatabase()
 
@@ -454,6 +809,32 @@ func TestCalcPercentage(t *testing.T) {
 	if expected != actual {
 		t.Errorf("Expected %v, got %v", expected, actual)
 	}
+
+	expected = 0
+	actual = calcPercentage(1, 0)
+	if expected != actual {
+		t.Errorf("Expected %v, got %v", expected, actual)
+	}
+}
+
+func TestFormatAmount(t *testing.T) {
+	tests := []struct {
+		amount   float64
+		unit     string
+		expected string
+	}{
+		{3, "", "3"},
+		{20, "km", "20 km"},
+		{2.5, "hours", "2.5 hours"},
+		{0.1 + 0.2, "l", "0.3 l"},
+		{100, "", "100"},
+		{0, "", "0"},
+	}
+	for _, test := range tests {
+		if actual := formatAmount(test.amount, test.unit); actual != test.expected {
+			t.Errorf("Expected %v, got %v", test.expected, actual)
+		}
+	}
 }
 
 func TestRenderResponseJSON(t *testing.T) {
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/context"
+)
+
+// periodProgress is the progress of a habit during one of its periods.
+type periodProgress struct {
+	Start   time.Time
+	Done    float64
+	Todo    float64
+	PctDone int
+	Paused  bool
+	Limit   bool
+}
+
+func habitHistoryHandler(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "GET" {
+		return methodNotAllowed()
+	}
+	uuid := r.URL.Path[len("/habits/history/"):]
+	if len(uuid) == 0 {
+		return badRequest(errors.New("habit id missing"))
+	}
+
+	accountId := context.Get(r, "accountId")
+	h, err := getHabit(uuid, accountId.(string))
+	if err == errHabitNotFound {
+		return notFound(err)
+	} else if err != nil {
+		return err
+	}
+	pauses, err := storage.HabitPauses(accountId.(string))
+	if err != nil {
+		return err
+	}
+	entries, err := storage.ProgressEntries(h.Id, accountId.(string))
+	if err != nil {
+		return err
+	}
+	reminders, err := storage.Reminders(h.Id, accountId.(string))
+	if err != nil {
+		return err
+	}
+	deliveries, err := storage.ReminderDeliveries(h.Id, accountId.(string))
+	if err != nil {
+		return err
+	}
+	tokens, err := storage.HabitTokens(h.Id, accountId.(string))
+	if err != nil {
+		return err
+	}
+	history := h.history
+	completed, total := completionStats(history)
+
+	// most recent period first
+	periods := make([]periodProgress, len(history))
+	for i, p := range history {
+		periods[len(history)-1-i] = p
+	}
+
+	data := struct {
+		Habit      *habit
+		Periods    []periodProgress
+		Pauses     []habitPause
+		Entries    []progressEntry
+		Reminders  []reminder
+		Deliveries []reminderDelivery
+		Tokens     []habitToken
+		Host       string
+		Completed  int
+		Total      int
+	}{
+		h,
+		periods,
+		pauses[h.Id],
+		entries,
+		reminders,
+		deliveries,
+		tokens,
+		requestHost(r),
+		completed,
+		total,
+	}
+
+	return renderResponse(w, r, data, "habit_history.html")
+}
+
+// applyHistory sets the fields of the habit that are derived from its
+// history, pauses and, for scheduled habits, its daily progress.
+func (h *habit) applyHistory(history []periodProgress, pauses []habitPause, daily map[string]float64, today time.Time) {
+	if h.scheduled() {
+		h.applySchedule(history, daily, today)
+	}
+	if h.Kind == KindLimit {
+		for i := range history {
+			history[i].Limit = true
+		}
+	}
+	h.Pause = applyPauses(history, pauses, h.Period)
+	h.Paused = h.Pause != nil
+	h.Streak = currentStreak(history)
+	h.Over = h.Kind == KindLimit && h.Done > h.Todo
+	h.Score = h.score()
+	h.history = history
+
+	// scheduled habits count occurrences, which don't have a unit
+	unit := h.Unit
+	if h.scheduled() {
+		unit = ""
+	}
+	h.DoneText = formatAmount(h.Done, unit)
+	h.TodoText = formatAmount(h.Todo, unit)
+}
+
+// currentStreak counts the completed periods in a row at the end of the
+// history. The last period is still in progress, so it only counts once
+// it's decided. Paused periods and periods without scheduled occurrences
+// are skipped.
+func currentStreak(history []periodProgress) int {
+	return streakAt(history, false)
+}
+
+// streakAt counts the completed periods in a row at the end of the history,
+// where the last period is finished or not.
+func streakAt(history []periodProgress, lastFinished bool) int {
+	streak := 0
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].skipped() {
+			continue
+		}
+		success, failure := history[i].outcome(lastFinished || i != len(history)-1)
+		if success {
+			streak++
+		} else if failure {
+			break
+		}
+	}
+	return streak
+}
+
+// completionStats returns how many of the finished periods of the history
+// were completed.
+func completionStats(history []periodProgress) (completed, total int) {
+	for i, p := range history {
+		if p.skipped() || i == len(history)-1 {
+			continue
+		}
+		total++
+		if success, _ := p.outcome(true); success {
+			completed++
+		}
+	}
+	return completed, total
+}
+
+// outcome tells whether the period was completed or failed. A period that
+// isn't finished yet can be neither: a target that isn't reached yet might
+// still be, and a limit that isn't exceeded yet might still be.
+func (p periodProgress) outcome(finished bool) (success, failure bool) {
+	if p.Limit {
+		if p.Done > p.Todo {
+			return false, true
+		}
+		return finished, false
+	}
+	if p.Done >= p.Todo {
+		return true, false
+	}
+	return false, finished
+}
+
+// Over reports whether a limit was exceeded during the period.
+func (p periodProgress) Over() bool {
+	return p.Limit && p.Done > p.Todo
+}
+
+// skipped reports whether the period doesn't count towards streaks and
+// completion, either because it's paused or nothing was due.
+func (p periodProgress) skipped() bool {
+	return p.Paused || p.Todo == 0
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/context"
+)
+
+func TestCurrentStreak(t *testing.T) {
+	tests := []struct {
+		done     []float64
+		expected int
+	}{
+		{[]float64{}, 0},
+		{[]float64{0}, 0},
+		{[]float64{2}, 1},
+		{[]float64{2, 2, 0}, 2},
+		{[]float64{2, 0, 2, 3}, 2},
+		{[]float64{2, 1, 0}, 0},
+	}
+	for _, test := range tests {
+		var history []periodProgress
+		for _, done := range test.done {
+			history = append(history, periodProgress{Done: done, Todo: 2})
+		}
+		actual := currentStreak(history)
+		if actual != test.expected {
+			t.Errorf("Expected %v for %v, got %v", test.expected, test.done, actual)
+		}
+	}
+}
+
+func TestCurrentStreakLimit(t *testing.T) {
+	tests := []struct {
+		done     []float64
+		expected int
+	}{
+		{[]float64{}, 0},
+		{[]float64{0}, 0},
+		{[]float64{3}, 0},
+		{[]float64{2, 2, 0}, 2},
+		{[]float64{0, 3, 1, 2}, 1},
+		{[]float64{1, 1, 3}, 0},
+	}
+	for _, test := range tests {
+		var history []periodProgress
+		for _, done := range test.done {
+			history = append(history, periodProgress{Done: done, Todo: 2, Limit: true})
+		}
+		actual := currentStreak(history)
+		if actual != test.expected {
+			t.Errorf("Expected %v for %v, got %v", test.expected, test.done, actual)
+		}
+	}
+}
+
+func TestCompletionStatsLimit(t *testing.T) {
+	history := []periodProgress{
+		{Done: 1, Todo: 2, Limit: true},
+		{Done: 3, Todo: 2, Limit: true},
+		{Done: 2, Todo: 2, Limit: true},
+		{Done: 5, Todo: 2, Limit: true},
+	}
+	completed, total := completionStats(history)
+	if completed != 2 {
+		t.Errorf("Expected 2, got %v", completed)
+	}
+	if total != 3 {
+		t.Errorf("Expected 3, got %v", total)
+	}
+}
+
+func TestGetHabitHistoryStartsWithStartDate(t *testing.T) {
+	defer withTestStore()()
+	account, _ := CreateAccount(emailForTests, passwordForTests)
+	defer truncateDatabase()
+
+	now := time.Now()
+	start := now.AddDate(0, 0, -14)
+	beforeStart := start.AddDate(0, 0, -7)
+	lastWeek := now.AddDate(0, 0, -7)
+	id, _ := createHabit(newHabit("Test", 1, PeriodWeek, start), account.Id)
+	createHabitProgress(*id, 1, &beforeStart)
+	createHabitProgress(*id, 1, &lastWeek)
+	createHabitProgress(*id, 1, &now)
+
+	history, err := storage.HabitHistory(*id, account.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 3 {
+		t.Fatalf("Expected 3 periods, got %v", len(history))
+	}
+	if history[0].Done != 0 {
+		t.Errorf("Expected 0 done in first period, got %v", history[0].Done)
+	}
+	if history[2].Done != 1 {
+		t.Errorf("Expected 1 done in current period, got %v", history[2].Done)
+	}
+	if streak := currentStreak(history); streak != 2 {
+		t.Errorf("Expected streak 2, got %v", streak)
+	}
+}
+
+func TestHabitHistoryHandlerSuccess(t *testing.T) {
+	defer withTestStore()()
+	account, _ := CreateAccount(emailForTests, passwordForTests)
+	id, _ := createHabit(newHabit("Habit", 2, PeriodWeek, time.Now()), account.Id)
+	defer truncateDatabase()
+
+	req, err := http.NewRequest("GET", "https://localhost/habits/history/"+*id, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	context.Set(req, "accountId", account.Id)
+	defer context.Clear(req)
+
+	w := httptest.NewRecorder()
+	appHandler(habitHistoryHandler).ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected %v, got %v", http.StatusOK, w.Code)
+	}
+}
+
+func TestHabitHistoryHandlerNotFound(t *testing.T) {
+	defer withTestStore()()
+	account, _ := CreateAccount(emailForTests, passwordForTests)
+	defer truncateDatabase()
+
+	req, err := http.NewRequest("GET", "https://localhost/habits/history/"+uuidForTests, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	context.Set(req, "accountId", account.Id)
+	defer context.Clear(req)
+
+	w := httptest.NewRecorder()
+	appHandler(habitHistoryHandler).ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected %v, got %v", http.StatusNotFound, w.Code)
+	}
+}
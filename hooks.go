@@ -0,0 +1,160 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/context"
+)
+
+// habitToken is a secret that allows logging progress on a habit without
+// logging in, by posting to /hooks/{token}.
+type habitToken struct {
+	Id      string
+	Token   string
+	Created time.Time
+	Revoked *time.Time
+}
+
+var (
+	errTokenNotFound     = errors.New("token not found")
+	errRequestInProgress = errors.New("request in progress")
+)
+
+// maxRequestIdLength keeps request IDs to the size of UUIDs and the like.
+const maxRequestIdLength = 100
+
+// hookHandler logs progress on the habit of the token in the URL. It takes
+// the same form or JSON body as habitUpdateHandler. A request ID in the
+// X-Request-Id header or the request_id parameter makes retries safe:
+// progress is logged once per ID and token.
+func hookHandler(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "POST" {
+		return methodNotAllowed()
+	}
+	token := r.URL.Path[len("/hooks/"):]
+	if len(token) == 0 {
+		return badRequest(errors.New("token missing"))
+	}
+
+	tokenId, habitId, accountId, err := storage.HabitByToken(token)
+	if err == errTokenNotFound {
+		return notFound(err)
+	} else if err != nil {
+		return err
+	}
+	// for the error log, the route clears it
+	context.Set(r, "accountId", accountId)
+
+	requestId := r.Header.Get("X-Request-Id")
+	if requestId == "" {
+		requestId = r.URL.Query().Get("request_id")
+	}
+	if len(requestId) > maxRequestIdLength {
+		return validationErrors{"request_id": "Can't be longer than 100 characters"}
+	}
+
+	entry, err := readProgressEntry(r)
+	if err != nil {
+		return err
+	}
+
+	if requestId == "" {
+		h, err := updateHabitProgress(habitId, accountId, entry)
+		if err != nil {
+			return err
+		}
+		return renderJSON(w, h)
+	}
+
+	before, err := getHabit(habitId, accountId)
+	if err != nil {
+		return err
+	}
+	entry.HabitId = habitId
+	logged, err := storage.CreateHookProgressEntry(tokenId, requestId, entry)
+	if err == errRequestInProgress {
+		return &httpError{http.StatusConflict, "Request with this ID in progress", nil}
+	} else if err != nil {
+		return err
+	}
+	after, err := getHabit(habitId, accountId)
+	if err != nil {
+		return err
+	}
+	if logged {
+		emitProgress(accountId, before, after, entry)
+	}
+	return renderJSON(w, after)
+}
+
+// habitTokenHandler creates a new token for the habit.
+func habitTokenHandler(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "POST" {
+		return methodNotAllowed()
+	}
+	uuid := r.URL.Path[len("/habits/tokens/"):]
+	if len(uuid) == 0 {
+		return badRequest(errors.New("habit id missing"))
+	}
+
+	accountId := context.Get(r, "accountId")
+	if _, err := createHabitToken(uuid, accountId.(string)); err == errHabitNotFound {
+		return notFound(err)
+	} else if err != nil {
+		return err
+	}
+
+	http.Redirect(w, r, "/habits/history/"+uuid, http.StatusFound)
+	return nil
+}
+
+// habitRevokeHandler revokes a token, requests using it are rejected from
+// then on.
+func habitRevokeHandler(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "POST" {
+		return methodNotAllowed()
+	}
+	uuid := r.URL.Path[len("/habits/revoke/"):]
+	if len(uuid) == 0 {
+		return badRequest(errors.New("token id missing"))
+	}
+
+	accountId := context.Get(r, "accountId")
+	habitId, err := storage.RevokeHabitToken(uuid, accountId.(string))
+	if err == errTokenNotFound {
+		return notFound(err)
+	} else if err != nil {
+		return err
+	}
+
+	http.Redirect(w, r, "/habits/history/"+habitId, http.StatusFound)
+	return nil
+}
+
+// createHabitToken gives the habit a new random token.
+func createHabitToken(habitId, accountId string) (*habitToken, error) {
+	secret := make([]byte, 24)
+	if _, err := io.ReadFull(rand.Reader, secret); err != nil {
+		return nil, err
+	}
+	t := &habitToken{Token: hex.EncodeToString(secret)}
+	if err := storage.CreateHabitToken(habitId, accountId, t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// requestHost returns the scheme and host the request was made to, for
+// showing complete logging URLs.
+func requestHost(r *http.Request) string {
+	scheme := "https"
+	if r.TLS == nil && r.Header.Get("X-Forwarded-Proto") != "https" {
+		scheme = "http"
+	}
+	return scheme + "://" + r.Host
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"crypto/tls"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequestHost(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://habitcat.example/habits", nil)
+	if host := requestHost(req); host != "http://habitcat.example" {
+		t.Errorf("Expected http://habitcat.example, got %v", host)
+	}
+	req.Header.Set("X-Forwarded-Proto", "https")
+	if host := requestHost(req); host != "https://habitcat.example" {
+		t.Errorf("Expected https://habitcat.example, got %v", host)
+	}
+	req, _ = http.NewRequest("GET", "https://habitcat.example/habits", nil)
+	req.TLS = &tls.ConnectionState{}
+	if host := requestHost(req); host != "https://habitcat.example" {
+		t.Errorf("Expected https://habitcat.example, got %v", host)
+	}
+}
+
+func TestHookHandlerMethodNotAllowed(t *testing.T) {
+	req, err := http.NewRequest("GET", "https://localhost/hooks/token", nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	appHandler(hookHandler).ServeHTTP(w, req)
+
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected %v, got %v", http.StatusMethodNotAllowed, w.Code)
+	}
+}
+
+func postHook(token, body, requestId string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("POST", "https://localhost/hooks/"+token, strings.NewReader(body))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if requestId != "" {
+		req.Header.Set("X-Request-Id", requestId)
+	}
+
+	w := httptest.NewRecorder()
+	appHandler(hookHandler).ServeHTTP(w, req)
+	return w
+}
+
+func TestHookHandlerIdempotent(t *testing.T) {
+	defer withTestStore()()
+	account, _ := CreateAccount(emailForTests, passwordForTests)
+	defer truncateDatabase()
+	id, _ := createHabit(newHabit("Run", 5, PeriodWeek, time.Now()), account.Id)
+	token, err := createHabitToken(*id, account.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if w := postHook(token.Token, "amount=2", "run-1"); w.Code != http.StatusOK {
+			t.Errorf("Expected %v, got %v", http.StatusOK, w.Code)
+		}
+	}
+	if w := postHook(token.Token, "amount=1", ""); w.Code != http.StatusOK {
+		t.Errorf("Expected %v, got %v", http.StatusOK, w.Code)
+	}
+
+	h, _ := getHabit(*id, account.Id)
+	if h.Done != 3 {
+		t.Errorf("Expected 3, got %v", h.Done)
+	}
+}
+
+func TestHookHandlerInProgress(t *testing.T) {
+	requirePostgres(t)
+	account, _ := CreateAccount(emailForTests, passwordForTests)
+	defer truncateDatabase()
+	id, _ := createHabit(newHabit("Run", 5, PeriodWeek, time.Now()), account.Id)
+	token, _ := createHabitToken(*id, account.Id)
+	tokenId, _, _, _ := storage.HabitByToken(token.Token)
+
+	// another request with the ID is being logged
+	tx, err := testDB.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.Exec("SELECT pg_advisory_xact_lock(hashtext($1), hashtext($2))", tokenId, "run-1")
+	if w := postHook(token.Token, "amount=2", "run-1"); w.Code != http.StatusConflict {
+		t.Errorf("Expected %v, got %v", http.StatusConflict, w.Code)
+	}
+	tx.Rollback()
+
+	if w := postHook(token.Token, "amount=2", "run-1"); w.Code != http.StatusOK {
+		t.Errorf("Expected %v once the other request is done, got %v", http.StatusOK, w.Code)
+	}
+	if h, _ := getHabit(*id, account.Id); h.Done != 2 {
+		t.Errorf("Expected 2, got %v", h.Done)
+	}
+}
+
+func TestHookHandlerRevoked(t *testing.T) {
+	defer withTestStore()()
+	account, _ := CreateAccount(emailForTests, passwordForTests)
+	defer truncateDatabase()
+	id, _ := createHabit(newHabit("Run", 5, PeriodWeek, time.Now()), account.Id)
+	token, _ := createHabitToken(*id, account.Id)
+
+	habitId, err := storage.RevokeHabitToken(token.Id, account.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if habitId != *id {
+		t.Errorf("Expected %v, got %v", *id, habitId)
+	}
+
+	if w := postHook(token.Token, "amount=1", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected %v, got %v", http.StatusNotFound, w.Code)
+	}
+	if w := postHook("unknown", "amount=1", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected %v, got %v", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestHookHandlerInvalid(t *testing.T) {
+	defer withTestStore()()
+	account, _ := CreateAccount(emailForTests, passwordForTests)
+	defer truncateDatabase()
+	id, _ := createHabit(newHabit("Run", 5, PeriodWeek, time.Now()), account.Id)
+	token, _ := createHabitToken(*id, account.Id)
+
+	if w := postHook(token.Token, "amount=lots", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected %v, got %v", http.StatusBadRequest, w.Code)
+	}
+	if w := postHook(token.Token, "amount=1", strings.Repeat("a", maxRequestIdLength+1)); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected %v, got %v", http.StatusBadRequest, w.Code)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/context"
+)
+
+// idempotencyRetention is how long keys are kept, a request retried later
+// than that is handled again.
+const idempotencyRetention = 24 * time.Hour
+
+// idempotentResponse is what is kept of the first request with a key.
+type idempotentResponse struct {
+	// Request is the method and path the key was first used with.
+	Request string
+	// Code is 0 while the request is being handled.
+	Code        int
+	ContentType string
+	Body        []byte
+}
+
+// idempotent makes retrying a request safe, e.g. after a double tap or a
+// timeout: requests with an Idempotency-Key header are handled once per key
+// and account, retries get the response of the first one. Failed requests
+// aren't kept, they can be retried with the same key.
+func idempotent(next appHandler) appHandler {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			return next(w, r)
+		}
+		if len(key) > maxRequestIdLength {
+			return validationErrors{"idempotency_key": "Can't be longer than 100 characters"}
+		}
+
+		accountId := context.Get(r, "accountId").(string)
+		request := r.Method + " " + r.URL.Path
+		saved, err := storage.ClaimIdempotencyKey(accountId, key, request, idempotencyRetention)
+		if err != nil {
+			return err
+		}
+		if saved != nil {
+			return replayResponse(w, saved, request)
+		}
+
+		rec := &responseRecorder{header: make(http.Header), code: http.StatusOK}
+		if err := next(rec, r); err != nil {
+			if err := storage.ReleaseIdempotencyKey(accountId, key); err != nil {
+				log.Printf("releasing idempotency key account=%v: %v", accountId, err)
+			}
+			return err
+		}
+
+		resp := &idempotentResponse{request, rec.code, rec.header.Get("Content-Type"), rec.body.Bytes()}
+		if err := storage.SaveIdempotentResponse(accountId, key, resp); err != nil {
+			// the request is done, a retry gets a conflict rather than
+			// doing it again
+			log.Printf("saving idempotent response account=%v: %v", accountId, err)
+		}
+
+		for name, values := range rec.header {
+			w.Header()[name] = values
+		}
+		w.WriteHeader(rec.code)
+		w.Write(rec.body.Bytes())
+		return nil
+	}
+}
+
+// replayResponse writes the response kept for the key of the request.
+func replayResponse(w http.ResponseWriter, saved *idempotentResponse, request string) error {
+	if saved.Request != request {
+		return &httpError{http.StatusUnprocessableEntity, "Idempotency key used for another request", nil}
+	}
+	if saved.Code == 0 {
+		return &httpError{http.StatusConflict, "Request with this idempotency key in progress", nil}
+	}
+
+	w.Header().Set("Content-Type", saved.ContentType)
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(saved.Code)
+	w.Write(saved.Body)
+	return nil
+}
+
+// responseRecorder keeps the response of a handler, so it can be saved
+// before it's sent.
+type responseRecorder struct {
+	header http.Header
+	code   int
+	body   bytes.Buffer
+}
+
+func (rec *responseRecorder) Header() http.Header {
+	return rec.header
+}
+
+func (rec *responseRecorder) WriteHeader(code int) {
+	rec.code = code
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	return rec.body.Write(b)
+}
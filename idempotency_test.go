@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/context"
+)
+
+func postWithKey(accountId, path, key, body string, handler appHandler) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("POST", "https://localhost"+path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Idempotency-Key", key)
+	context.Set(req, "accountId", accountId)
+	defer context.Clear(req)
+
+	w := httptest.NewRecorder()
+	idempotent(handler).ServeHTTP(w, req)
+	return w
+}
+
+func testIdempotencyKeys(t *testing.T) {
+	account, _ := CreateAccount(emailForTests, passwordForTests)
+	other, _ := CreateAccount("other@habitcat.net", passwordForTests)
+	id, _ := createHabit(newHabit("Run", 10, PeriodWeek, time.Now()), account.Id)
+	otherId, _ := createHabit(newHabit("Run", 10, PeriodWeek, time.Now()), other.Id)
+	goalId, _ := createGoal(&goal{Description: "Read", PointsTotal: 4}, account.Id)
+	path := "/habits/" + *id
+
+	first := postWithKey(account.Id, path, "k1", "amount=2", habitUpdateHandler)
+	retry := postWithKey(account.Id, path, "k1", "amount=2", habitUpdateHandler)
+	if first.Code != http.StatusOK || retry.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %v and %v", first.Code, retry.Code)
+	}
+	if retry.Body.String() != first.Body.String() || retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("Expected the first response replayed, got %s", retry.Body)
+	}
+	if retry.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("Expected \"application/json\", got %v", retry.Header().Get("Content-Type"))
+	}
+	entries, _ := storage.ProgressEntries(*id, account.Id)
+	if len(entries) != 1 {
+		t.Errorf("Expected 1 entry, got %v", len(entries))
+	}
+
+	// keys are per account, and failed requests can be retried
+	postWithKey(other.Id, "/habits/"+*otherId, "k1", "amount=2", habitUpdateHandler)
+	if entries, _ := storage.ProgressEntries(*otherId, other.Id); len(entries) != 1 {
+		t.Errorf("Expected 1 entry of the other account, got %v", len(entries))
+	}
+	if w := postWithKey(account.Id, path, "k2", "amount=many", habitUpdateHandler); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400, got %v", w.Code)
+	}
+	if w := postWithKey(account.Id, path, "k2", "amount=1", habitUpdateHandler); w.Code != http.StatusOK {
+		t.Errorf("Expected 200, got %v", w.Code)
+	}
+	if entries, _ := storage.ProgressEntries(*id, account.Id); len(entries) != 2 {
+		t.Errorf("Expected 2 entries, got %v", len(entries))
+	}
+
+	if w := postWithKey(account.Id, "/goals/"+*goalId, "k1", "", goalUpdateHandler); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected 422 for a key of another request, got %v", w.Code)
+	}
+	postWithKey(account.Id, "/goals/"+*goalId, "k3", "", goalUpdateHandler)
+	if w := postWithKey(account.Id, "/goals/"+*goalId, "k3", "", goalUpdateHandler); w.Body.String() != "25" {
+		t.Errorf("Expected 25 replayed, got %s", w.Body)
+	}
+	goals, _ := storage.Goals(account.Id)
+	if goals[0].PointsDone != 1 {
+		t.Errorf("Expected 1 point done, got %v", goals[0].PointsDone)
+	}
+}
+
+func TestIdempotencyKeysMemory(t *testing.T) {
+	_, restore := withMemoryStore()
+	defer restore()
+	testIdempotencyKeys(t)
+}
+
+func TestIdempotencyKeys(t *testing.T) {
+	requireDatabase(t)
+	defer truncateDatabase()
+	testIdempotencyKeys(t)
+}
+
+func TestIdempotencyKeyRetention(t *testing.T) {
+	s, restore := withMemoryStore()
+	defer restore()
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	account, _ := CreateAccount(emailForTests, passwordForTests)
+	id, _ := createHabit(newHabit("Run", 10, PeriodWeek, now), account.Id)
+
+	postWithKey(account.Id, "/habits/"+*id, "k1", "", habitUpdateHandler)
+	now = now.Add(idempotencyRetention + time.Minute)
+	w := postWithKey(account.Id, "/habits/"+*id, "k1", "", habitUpdateHandler)
+
+	if w.Header().Get("Idempotent-Replayed") != "" {
+		t.Error("Expected the expired key to be handled again")
+	}
+	if entries, _ := storage.ProgressEntries(*id, account.Id); len(entries) != 2 {
+		t.Errorf("Expected 2 entries, got %v", len(entries))
+	}
+}
+
+func TestIdempotencyKeyInProgress(t *testing.T) {
+	_, restore := withMemoryStore()
+	defer restore()
+	account, _ := CreateAccount(emailForTests, passwordForTests)
+	id, _ := createHabit(newHabit("Run", 10, PeriodWeek, time.Now()), account.Id)
+	path := "/habits/" + *id
+	storage.ClaimIdempotencyKey(account.Id, "k1", "POST "+path, idempotencyRetention)
+
+	if w := postWithKey(account.Id, path, "k1", "", habitUpdateHandler); w.Code != http.StatusConflict {
+		t.Errorf("Expected 409, got %v", w.Code)
+	}
+}
@@ -0,0 +1,504 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/context"
+)
+
+// maxImportSize caps the size of an uploaded import file.
+const maxImportSize = 10 << 20
+
+// The formats of import files.
+const (
+	// importHabitCat is habitcat.json from an /export archive.
+	importHabitCat = "habitcat"
+	// importCSV is a CSV file with a header row and a line per progress
+	// entry, with the columns to read the date, habit and amount from
+	// picked when importing.
+	importCSV = "csv"
+)
+
+// importFields are the fields of the import form the preview page submits
+// again along with the file.
+var importFields = []string{"format", "date_column", "habit_column", "amount_column", "note_column", "period"}
+
+// importDateFormats are the date formats understood in CSV files.
+var importDateFormats = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02 15:04", dateFormat}
+
+// importPlan is what an import creates in an account, worked out before
+// anything is written so it can be previewed.
+type importPlan struct {
+	// Habits are the habits that don't exist in the account yet.
+	Habits []habit
+	Goals  []goal
+	// Pauses are the pauses the habits don't have yet.
+	Pauses []importPause
+	// Entries are the progress entries to log, Duplicates counts the ones
+	// left out because they were logged already.
+	Entries    []importEntry
+	Duplicates int
+	// Settings are the settings of the file when they differ from the
+	// account's, nil otherwise. They are saved after the store's Import.
+	Settings *settings
+}
+
+// importEntry is progress to log on an existing habit, HabitId, or when
+// HabitId is empty on the habit NewHabit of the plan's Habits.
+type importEntry struct {
+	HabitId  string
+	NewHabit int
+	Delta    float64
+	Note     string
+	Tags     []string
+	Created  time.Time
+}
+
+// importPause is a pause of an existing habit, HabitId, or when HabitId
+// is empty of the habit NewHabit of the plan's Habits.
+type importPause struct {
+	HabitId  string
+	NewHabit int
+	habitPause
+}
+
+// importPreview is the summary of an importPlan shown before importing.
+type importPreview struct {
+	Habits     []string        `json:"habits"`
+	Goals      []string        `json:"goals"`
+	Pauses     int             `json:"pauses"`
+	Entries    int             `json:"entries"`
+	Duplicates int             `json:"duplicates"`
+	Settings   *exportSettings `json:"settings,omitempty"`
+}
+
+// importFile is what is read from an import file. Pauses and Entries refer
+// to Habits by index, and the habits have an Id if the file has one.
+// Settings is nil when the file has none.
+type importFile struct {
+	Habits   []habit
+	Goals    []goal
+	Pauses   []importFilePause
+	Entries  []importFileEntry
+	Settings *settings
+}
+
+type importFilePause struct {
+	Habit int
+	habitPause
+}
+
+type importFileEntry struct {
+	Habit int
+	progressEntry
+}
+
+// importHandler shows the import form, previews the import of the
+// submitted file and imports it once confirmed. The preview page submits
+// the contents of the file again, in data, so nothing is kept between the
+// two requests.
+func importHandler(w http.ResponseWriter, r *http.Request) error {
+	if r.Method == "GET" {
+		values := url.Values{"format": {importHabitCat}, "period": {string(PeriodWeek)}}
+		return renderTemplate(w, "import.html", formData{Values: values})
+	} else if r.Method != "POST" {
+		return methodNotAllowed()
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+	data, err := readImportData(r)
+	if err != nil {
+		return badRequest(err)
+	}
+
+	accountId := context.Get(r, "accountId").(string)
+	f, errs := parseImportFile(data, r.PostForm)
+	if errs != nil {
+		return renderInvalidForm(w, r, "import.html", errs)
+	}
+	plan, err := planImport(accountId, f)
+	if err != nil {
+		return err
+	}
+
+	if r.PostForm.Get("confirm") == "" {
+		preview := plan.preview()
+		if wantsJSON(r) {
+			return renderJSON(w, preview)
+		}
+		return renderTemplate(w, "import_preview.html", struct {
+			formData
+			Fields  []string
+			Preview importPreview
+			Data    string
+		}{formData{Values: r.PostForm}, importFields, preview, string(data)})
+	}
+
+	if err := storage.Import(accountId, plan); err != nil {
+		return err
+	}
+	if plan.Settings != nil {
+		if err := updateSettings(accountId, plan.Settings, time.Now()); err != nil {
+			return err
+		}
+	}
+	if wantsJSON(r) {
+		return renderJSON(w, plan.preview())
+	}
+	http.Redirect(w, r, "/habits", http.StatusFound)
+	return nil
+}
+
+// readImportData returns the uploaded file, or the data field the preview
+// page sends it back in.
+func readImportData(r *http.Request) ([]byte, error) {
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := r.ParseMultipartForm(maxImportSize); err != nil {
+			return nil, err
+		}
+		if f, _, err := r.FormFile("file"); err == nil {
+			defer f.Close()
+			return io.ReadAll(f)
+		}
+	} else if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+	return []byte(r.PostForm.Get("data")), nil
+}
+
+// parseImportFile reads the file in the format of the form. Errors point
+// at the first invalid habit or line.
+func parseImportFile(data []byte, form url.Values) (*importFile, validationErrors) {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, validationErrors{"file": "Pick a file to import"}
+	}
+	switch form.Get("format") {
+	case importHabitCat:
+		return parseHabitCatImport(data, time.Now())
+	case importCSV:
+		return parseCSVImport(data, form, time.Now())
+	}
+	return nil, validationErrors{"format": "Unknown format"}
+}
+
+func parseHabitCatImport(data []byte, now time.Time) (*importFile, validationErrors) {
+	var export exportData
+	if err := json.Unmarshal(data, &export); err != nil {
+		return nil, validationErrors{"file": "Not a HabitCat export: " + err.Error()}
+	}
+	if export.Version < 1 || export.Version > exportVersion {
+		return nil, validationErrors{"file": fmt.Sprintf("Unknown export version %d", export.Version)}
+	}
+
+	f := &importFile{}
+	habits := make(map[string]int)
+	for _, e := range export.Habits {
+		form := url.Values{
+			"description": {e.Description},
+			"kind":        {string(e.Kind)},
+			"category":    {e.Category},
+			"tags":        {strings.Join(e.Tags, ",")},
+			"unit":        {e.Unit},
+			"todo":        {formatNumber(e.Todo)},
+			"period":      {string(e.Period)},
+			"start":       {e.Start},
+			"schedule":    {string(e.Schedule)},
+			"every_days":  {strconv.Itoa(e.EveryDays)},
+		}
+		for _, d := range e.Weekdays {
+			form.Add("weekdays", strconv.Itoa(d))
+		}
+		h, errs := validateHabitForm(form, now)
+		if errs != nil {
+			return nil, importErrors(fmt.Sprintf("Habit %q", e.Description), errs)
+		}
+		h.Id = e.Id
+		h.Retired = e.Retired
+		habits[e.Id] = len(f.Habits)
+		f.Habits = append(f.Habits, *h)
+
+		for _, p := range e.Pauses {
+			pause, errs := validatePauseForm(url.Values{"start": {p.Start}, "finish": {p.Finish}, "reason": {p.Reason}})
+			if errs != nil {
+				return nil, importErrors(fmt.Sprintf("Habit %q: pause %s", e.Description, p.Start), errs)
+			}
+			f.Pauses = append(f.Pauses, importFilePause{len(f.Habits) - 1, *pause})
+		}
+	}
+
+	for _, e := range export.Goals {
+		form := url.Values{"description": {e.Description}, "todo": {strconv.Itoa(e.PointsTotal)}}
+		if e.Due != nil {
+			form.Set("due", *e.Due)
+		}
+		g, errs := validateGoalForm(form)
+		if errs == nil && (e.PointsDone < 0 || e.PointsDone > e.PointsTotal) {
+			errs = validationErrors{"points_done": "Must be from 0 to the points to do"}
+		}
+		if errs != nil {
+			return nil, importErrors(fmt.Sprintf("Goal %q", e.Description), errs)
+		}
+		g.PointsDone = e.PointsDone
+		f.Goals = append(f.Goals, *g)
+	}
+
+	for i, e := range export.Progress {
+		index, found := habits[e.HabitId]
+		if !found {
+			return nil, validationErrors{"file": fmt.Sprintf("Progress %d: no habit with id %s", i+1, e.HabitId)}
+		}
+		form := url.Values{"amount": {formatNumber(e.Amount)}, "note": {e.Note}, "tags": {strings.Join(e.Tags, ",")}}
+		entry, errs := validateProgressForm(form)
+		if errs != nil {
+			return nil, importErrors(fmt.Sprintf("Progress %d", i+1), errs)
+		}
+		entry.Created = e.Created
+		f.Entries = append(f.Entries, importFileEntry{index, *entry})
+	}
+
+	if export.Settings != nil {
+		s, errs := validateSettingsForm(url.Values{"summary": {string(export.Settings.Summary)}})
+		if errs != nil {
+			return nil, importErrors("Settings", errs)
+		}
+		f.Settings = s
+	}
+	return f, nil
+}
+
+// parseCSVImport reads a progress entry per line. Habits are told apart by
+// their description, the ones that don't exist yet are created with the
+// period of the form and a target of 1 starting on their first entry.
+func parseCSVImport(data []byte, form url.Values, now time.Time) (*importFile, validationErrors) {
+	period := Period(form.Get("period"))
+	if !period.valid() {
+		return nil, validationErrors{"period": "Period must be a day, a week or a month"}
+	}
+
+	r := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if err != nil {
+		return nil, validationErrors{"file": "Not a CSV file: " + err.Error()}
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	// the note column is optional, the others must be there
+	column := func(field, fallback string, required bool) (int, string) {
+		name := strings.ToLower(strings.TrimSpace(form.Get(field)))
+		if name == "" {
+			name = fallback
+		}
+		i, found := columns[name]
+		if !found && (required || form.Get(field) != "") {
+			return -1, fmt.Sprintf("The file has no column %q", name)
+		}
+		if !found {
+			return -1, ""
+		}
+		return i, ""
+	}
+	errs := validationErrors{}
+	dateColumn, msg := column("date_column", "date", true)
+	if msg != "" {
+		errs["date_column"] = msg
+	}
+	habitColumn, msg := column("habit_column", "habit", true)
+	if msg != "" {
+		errs["habit_column"] = msg
+	}
+	amountColumn, msg := column("amount_column", "amount", true)
+	if msg != "" {
+		errs["amount_column"] = msg
+	}
+	noteColumn, msg := column("note_column", "note", false)
+	if msg != "" {
+		errs["note_column"] = msg
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	f := &importFile{}
+	habits := make(map[string]int)
+	for line := 2; ; line++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, validationErrors{"file": err.Error()}
+		}
+		field := func(i int) string {
+			if i < 0 || i >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[i])
+		}
+
+		description := field(habitColumn)
+		if description == "" {
+			return nil, validationErrors{"file": fmt.Sprintf("Line %d: the habit is empty", line)}
+		}
+		created, ok := parseImportDate(field(dateColumn))
+		if !ok {
+			return nil, validationErrors{"file": fmt.Sprintf("Line %d: %q isn't a date like 2017-03-04", line, field(dateColumn))}
+		}
+		entry, errs := validateProgressForm(url.Values{"amount": {field(amountColumn)}, "note": {field(noteColumn)}})
+		if errs != nil {
+			return nil, importErrors(fmt.Sprintf("Line %d", line), errs)
+		}
+		entry.Created = created
+
+		key := strings.ToLower(description)
+		index, found := habits[key]
+		if !found {
+			index = len(f.Habits)
+			habits[key] = index
+			f.Habits = append(f.Habits, habit{Description: description, Todo: 1, Period: period, Start: PeriodDay.start(created)})
+		}
+		if created.Before(f.Habits[index].Start) {
+			f.Habits[index].Start = PeriodDay.start(created)
+		}
+		f.Entries = append(f.Entries, importFileEntry{index, *entry})
+	}
+
+	for _, h := range f.Habits {
+		if h.Start.Before(earliestStart) || h.Start.After(now.AddDate(1, 0, 0)) {
+			return nil, validationErrors{"file": fmt.Sprintf("Habit %q: the dates must be from the year 2000 up to a year from now", h.Description)}
+		}
+	}
+	return f, nil
+}
+
+func parseImportDate(s string) (time.Time, bool) {
+	for _, layout := range importDateFormats {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// importErrors turns the validation errors of a habit or entry of the file
+// into an error of the file field.
+func importErrors(what string, errs validationErrors) validationErrors {
+	return validationErrors{"file": what + ": " + errs.join()}
+}
+
+// planImport matches the habits of the file with the ones of the account,
+// retired ones included, by ID and then by description, an active habit
+// only with an active one and a retired one with a retired one. It leaves
+// out the progress entries that were logged already: same habit, amount
+// and time to the millisecond. Entries repeated within the file are all
+// logged, they can be progress made twice. Goals are matched by
+// description and pauses by their days.
+func planImport(accountId string, f *importFile) (*importPlan, error) {
+	existing, err := storage.AllHabits(accountId)
+	if err != nil {
+		return nil, err
+	}
+	goals, err := storage.Goals(accountId)
+	if err != nil {
+		return nil, err
+	}
+	pauses, err := storage.HabitPauses(accountId)
+	if err != nil {
+		return nil, err
+	}
+	current, err := storage.Settings(accountId)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &importPlan{}
+	habitIds := make([]string, len(f.Habits))
+	newHabits := make([]int, len(f.Habits))
+	for i, h := range f.Habits {
+		for _, e := range existing {
+			sameDescription := strings.EqualFold(e.Description, h.Description) && (e.Retired == nil) == (h.Retired == nil)
+			if e.Id == h.Id || sameDescription {
+				habitIds[i] = e.Id
+				break
+			}
+		}
+		if habitIds[i] == "" {
+			newHabits[i] = len(plan.Habits)
+			plan.Habits = append(plan.Habits, h)
+		}
+	}
+
+	for _, g := range f.Goals {
+		found := false
+		for _, e := range goals {
+			found = found || strings.EqualFold(e.Description, g.Description)
+		}
+		if !found {
+			plan.Goals = append(plan.Goals, g)
+		}
+	}
+
+	for _, p := range f.Pauses {
+		found := false
+		for _, e := range pauses[habitIds[p.Habit]] {
+			found = found || e.Start.Equal(p.Start) && e.Finish.Equal(p.Finish)
+		}
+		if !found {
+			plan.Pauses = append(plan.Pauses, importPause{habitIds[p.Habit], newHabits[p.Habit], p.habitPause})
+		}
+	}
+
+	// only the stored entries count, new habits have none
+	logged := make(map[string]bool)
+	err = storage.EachProgressEntry(accountId, time.Time{}, func(e progressEntry) error {
+		logged[importKey(e.HabitId, e.Delta, e.Created)] = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range f.Entries {
+		entry := importEntry{habitIds[e.Habit], newHabits[e.Habit], e.Delta, e.Note, e.Tags, e.Created}
+		if entry.HabitId != "" && logged[importKey(entry.HabitId, entry.Delta, entry.Created)] {
+			plan.Duplicates++
+			continue
+		}
+		plan.Entries = append(plan.Entries, entry)
+	}
+
+	if f.Settings != nil && *f.Settings != *current {
+		plan.Settings = f.Settings
+	}
+	return plan, nil
+}
+
+// importKey tells progress entries apart when deduplicating. Times are
+// compared to the millisecond, the precision SQLite keeps.
+func importKey(habitId string, delta float64, created time.Time) string {
+	return habitId + " " + formatNumber(delta) + " " + created.UTC().Format("2006-01-02T15:04:05.000")
+}
+
+func (p *importPlan) preview() importPreview {
+	preview := importPreview{Habits: []string{}, Goals: []string{}, Pauses: len(p.Pauses), Entries: len(p.Entries), Duplicates: p.Duplicates}
+	if p.Settings != nil {
+		preview.Settings = &exportSettings{p.Settings.Summary}
+	}
+	for _, h := range p.Habits {
+		preview.Habits = append(preview.Habits, h.Description)
+	}
+	for _, g := range p.Goals {
+		preview.Goals = append(preview.Goals, g.Description)
+	}
+	return preview
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/context"
+)
+
+const csvForTests = `Day,Activity,Minutes,Comment
+2017-03-01,Yoga,30,
+2017-03-02,Running,20,"Hills, then flat"
+2017-02-27,Yoga,15,
+`
+
+var csvFormForTests = url.Values{
+	"format":        {importCSV},
+	"date_column":   {"day"},
+	"habit_column":  {"Activity"},
+	"amount_column": {"minutes"},
+	"note_column":   {"comment"},
+	"period":        {"week"},
+}
+
+func postImport(accountId string, form url.Values, data string, confirm bool) *httptest.ResponseRecorder {
+	values := url.Values{"data": {data}}
+	for field, v := range form {
+		values[field] = v
+	}
+	if confirm {
+		values.Set("confirm", "1")
+	}
+	req, _ := http.NewRequest("POST", "https://localhost/import", strings.NewReader(values.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	context.Set(req, "accountId", accountId)
+	defer context.Clear(req)
+
+	w := httptest.NewRecorder()
+	appHandler(importHandler).ServeHTTP(w, req)
+	return w
+}
+
+func readPreview(t *testing.T, w *httptest.ResponseRecorder) importPreview {
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %v: %s", w.Code, w.Body)
+	}
+	var preview importPreview
+	if err := json.Unmarshal(w.Body.Bytes(), &preview); err != nil {
+		t.Fatal(err)
+	}
+	return preview
+}
+
+func TestParseCSVImport(t *testing.T) {
+	f, errs := parseCSVImport([]byte(csvForTests), csvFormForTests, time.Now())
+	if errs != nil {
+		t.Fatal(errs)
+	}
+	if len(f.Habits) != 2 || len(f.Entries) != 3 {
+		t.Fatalf("Expected 2 habits and 3 entries, got %+v", f)
+	}
+	// habits start on the day of their earliest entry
+	if !f.Habits[0].Start.Equal(date(2017, time.February, 27)) {
+		t.Errorf("Expected Yoga to start on 2017-02-27, got %v", f.Habits[0].Start)
+	}
+	if e := f.Entries[1]; e.Habit != 1 || e.Delta != 20 || e.Note != "Hills, then flat" {
+		t.Errorf("Expected the Running entry, got %+v", e)
+	}
+}
+
+func TestParseCSVImportErrors(t *testing.T) {
+	tests := []struct {
+		csv   string
+		field string
+		value string
+	}{
+		{"date,habit\n2017-03-01,Yoga\n", "amount_column", ""},
+		{"date,habit,amount\n2017-03-01,Yoga,1\n", "note_column", "comment"},
+		{"date,habit,amount\nyesterday,Yoga,1\n", "file", ""},
+		{"date,habit,amount\n2017-03-01,,1\n", "file", ""},
+		{"date,habit,amount\n2017-03-01,Yoga,many\n", "file", ""},
+		{"date,habit,amount\n1999-03-01,Yoga,1\n", "file", ""},
+	}
+	for _, test := range tests {
+		form := url.Values{"period": {"week"}}
+		if test.value != "" {
+			form.Set(test.field, test.value)
+		}
+		_, errs := parseCSVImport([]byte(test.csv), form, time.Now())
+		if errs[test.field] == "" {
+			t.Errorf("Expected an error for %v in %q, got %v", test.field, test.csv, errs)
+		}
+	}
+}
+
+func TestParseHabitCatImportErrors(t *testing.T) {
+	tests := []string{
+		`not json`,
+		`{"version": 2}`,
+		`{"version": 1, "habits": [{"id": "1", "description": "Run", "todo": 1, "period": "year"}]}`,
+		`{"version": 1, "progress": [{"habit_id": "1", "amount": 1}]}`,
+		`{"version": 1, "goals": [{"description": "Read", "points_total": 0}]}`,
+		`{"version": 1, "habits": [{"id": "1", "description": "Run", "todo": 1, "period": "week", "start": "2017-01-02",
+		  "pauses": [{"start": "2017-02-14", "finish": "2017-02-01"}]}]}`,
+		`{"version": 1, "settings": {"summary": "day"}}`,
+	}
+	for _, data := range tests {
+		if _, errs := parseHabitCatImport([]byte(data), time.Now()); errs["file"] == "" {
+			t.Errorf("Expected an error for %v, got %v", data, errs)
+		}
+	}
+}
+
+func testImportCSV(t *testing.T) {
+	account, _ := CreateAccount(emailForTests, passwordForTests)
+	id, _ := createHabit(newHabit("yoga", 60, PeriodWeek, date(2017, time.January, 2)), account.Id)
+
+	preview := readPreview(t, postImport(account.Id, csvFormForTests, csvForTests, false))
+	if len(preview.Habits) != 1 || preview.Habits[0] != "Running" || preview.Entries != 3 {
+		t.Errorf("Expected Running to be created and 3 entries, got %+v", preview)
+	}
+	if habits, _ := getHabits(account.Id); len(habits) != 1 {
+		t.Errorf("Expected nothing imported by the preview, got %v habits", len(habits))
+	}
+
+	readPreview(t, postImport(account.Id, csvFormForTests, csvForTests, true))
+
+	habits, _ := getHabits(account.Id)
+	if len(habits) != 2 {
+		t.Fatalf("Expected 2 habits, got %v", len(habits))
+	}
+	entries, _ := storage.ProgressEntries(*id, account.Id)
+	if len(entries) != 2 {
+		t.Errorf("Expected 2 entries of the existing habit, got %v", len(entries))
+	}
+
+	// importing the same file again only finds duplicates
+	preview = readPreview(t, postImport(account.Id, csvFormForTests, csvForTests, false))
+	if len(preview.Habits) != 0 || preview.Entries != 0 || preview.Duplicates != 3 {
+		t.Errorf("Expected 3 duplicates, got %+v", preview)
+	}
+}
+
+func TestImportCSVMemory(t *testing.T) {
+	_, restore := withMemoryStore()
+	defer restore()
+	testImportCSV(t)
+}
+
+func TestImportCSV(t *testing.T) {
+	requireDatabase(t)
+	defer truncateDatabase()
+	testImportCSV(t)
+}
+
+func testImportCSVRepeatedLines(t *testing.T) {
+	account, _ := CreateAccount(emailForTests, passwordForTests)
+	id, _ := createHabit(newHabit("Yoga", 60, PeriodWeek, date(2017, time.January, 2)), account.Id)
+	data := "Day,Activity,Minutes,Comment\n2017-03-01,Yoga,30,\n2017-03-01,Yoga,30,\n2017-03-02,Running,20,\n2017-03-02,Running,20,\n"
+
+	// the same progress twice in the file is progress made twice
+	preview := readPreview(t, postImport(account.Id, csvFormForTests, data, true))
+	if preview.Entries != 4 || preview.Duplicates != 0 {
+		t.Errorf("Expected 4 entries, got %+v", preview)
+	}
+	if entries, _ := storage.ProgressEntries(*id, account.Id); len(entries) != 2 {
+		t.Errorf("Expected 2 entries of the existing habit, got %v", len(entries))
+	}
+
+	preview = readPreview(t, postImport(account.Id, csvFormForTests, data, false))
+	if preview.Entries != 0 || preview.Duplicates != 4 {
+		t.Errorf("Expected 4 duplicates, got %+v", preview)
+	}
+}
+
+func TestImportCSVRepeatedLinesMemory(t *testing.T) {
+	_, restore := withMemoryStore()
+	defer restore()
+	testImportCSVRepeatedLines(t)
+}
+
+func TestImportCSVRepeatedLines(t *testing.T) {
+	requireDatabase(t)
+	defer truncateDatabase()
+	testImportCSVRepeatedLines(t)
+}
+
+// testImportExport restores the export of an account in another one.
+func testImportExport(t *testing.T) {
+	account, _ := CreateAccount(emailForTests, passwordForTests)
+	other, _ := CreateAccount("other@habitcat.net", passwordForTests)
+	h := newHabit("Run", 3, PeriodWeek, date(2017, time.January, 2))
+	h.Schedule = ScheduleWeekdays
+	h.Weekdays = weekdaySet(0).add(time.Monday).add(time.Thursday)
+	h.PerOccurrence = 3
+	id, _ := createHabit(h, account.Id)
+	storage.CreateProgressEntry(*id, &progressEntry{Delta: 2.5, Note: "Park", Tags: []string{"morning", `say "hi"`}})
+	storage.CreateProgressEntry(*id, &progressEntry{Delta: 1})
+	due := date(2017, time.December, 31)
+	goalId, _ := createGoal(&goal{Description: "Read", PointsTotal: 4, Due: &due}, account.Id)
+	updateGoalPoints(*goalId, account.Id)
+	pause := &habitPause{Start: date(2017, time.February, 1), Finish: date(2017, time.February, 14), Reason: "Flu"}
+	storage.CreateHabitPause(*id, account.Id, pause)
+	updateSettings(account.Id, &settings{Summary: PeriodWeek}, time.Now())
+
+	data := getExport(t, account.Id)["habitcat.json"]
+	form := url.Values{"format": {importHabitCat}}
+
+	readPreview(t, postImport(other.Id, form, string(data), true))
+
+	habits, _ := getHabits(other.Id)
+	if len(habits) != 1 || habits[0].Schedule != ScheduleWeekdays || habits[0].PerOccurrence != 3 || !habits[0].Weekdays.has(time.Thursday) {
+		t.Fatalf("Expected the habit of the export, got %+v", habits)
+	}
+	entries, _ := storage.ProgressEntries(habits[0].Id, other.Id)
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %+v", entries)
+	}
+	for _, e := range entries {
+		if e.Delta == 2.5 && (e.Note != "Park" || len(e.Tags) != 2 || e.Tags[1] != `say "hi"`) {
+			t.Errorf("Expected the note and tags of the entry, got %+v", e)
+		}
+	}
+	goals, _ := storage.Goals(other.Id)
+	if len(goals) != 1 || goals[0].PointsDone != 1 || goals[0].Due == nil || !goals[0].Due.Equal(due) {
+		t.Errorf("Expected the goal with a point done and its due date, got %+v", goals)
+	}
+	pauses, _ := storage.HabitPauses(other.Id)
+	if p := pauses[habits[0].Id]; len(p) != 1 || !p[0].Start.Equal(pause.Start) || !p[0].Finish.Equal(pause.Finish) || p[0].Reason != "Flu" {
+		t.Errorf("Expected the pause of the habit, got %+v", pauses)
+	}
+	if s, _ := storage.Settings(other.Id); s.Summary != PeriodWeek {
+		t.Errorf("Expected weekly summaries, got %+v", s)
+	}
+
+	// restoring the export in the account it came from adds nothing
+	preview := readPreview(t, postImport(account.Id, form, string(data), false))
+	if len(preview.Habits) != 0 || len(preview.Goals) != 0 || preview.Pauses != 0 || preview.Settings != nil ||
+		preview.Entries != 0 || preview.Duplicates != 2 {
+		t.Errorf("Expected only duplicates, got %+v", preview)
+	}
+}
+
+func TestImportExportMemory(t *testing.T) {
+	_, restore := withMemoryStore()
+	defer restore()
+	testImportExport(t)
+}
+
+func TestImportExport(t *testing.T) {
+	requireDatabase(t)
+	defer truncateDatabase()
+	testImportExport(t)
+}
+
+func TestImportHandlerPreviewPage(t *testing.T) {
+	_, restore := withMemoryStore()
+	defer restore()
+	account, _ := CreateAccount(emailForTests, passwordForTests)
+
+	var body bytes.Buffer
+	m := multipart.NewWriter(&body)
+	for field, v := range csvFormForTests {
+		m.WriteField(field, v[0])
+	}
+	f, _ := m.CreateFormFile("file", "export.csv")
+	f.Write([]byte(csvForTests))
+	m.Close()
+
+	req, _ := http.NewRequest("POST", "https://localhost/import", &body)
+	req.Header.Set("Content-Type", m.FormDataContentType())
+	context.Set(req, "accountId", account.Id)
+	defer context.Clear(req)
+
+	w := httptest.NewRecorder()
+	appHandler(importHandler).ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %v", w.Code)
+	}
+	page := w.Body.String()
+	for _, s := range []string{"3 progress entries", "<li>Running</li>", `name="confirm"`, `name="habit_column" value="Activity"`} {
+		if !strings.Contains(page, s) {
+			t.Errorf("Expected %q in the page", s)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// job is work the server does in the background, e.g. sending emails.
+type job struct {
+	name     string
+	interval time.Duration
+	run      func(now time.Time) error
+}
+
+// startJobs runs every job in a goroutine of its own, right away and then
+// every interval. Errors are logged and the job is tried again at its next
+// run, so jobs have to keep track of what they already did.
+func startJobs(jobs ...job) {
+	for _, j := range jobs {
+		go j.loop()
+	}
+}
+
+func (j job) loop() {
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := j.run(time.Now()); err != nil {
+			log.Printf("job %s: %v", j.name, err)
+		}
+		<-ticker.C
+	}
+}
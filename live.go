@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/context"
+	"github.com/lib/pq"
+)
+
+// liveChannel is notified by the triggers of habit_progress and goal with
+// the account and the habit or goal that changed.
+const liveChannel = "account_change"
+
+// The kinds of changes pushed to the pages open in the browser. After
+// liveResync changes may have been missed, the page has to be reloaded.
+const (
+	liveHabit  = "habit"
+	liveGoal   = "goal"
+	liveResync = "resync"
+)
+
+// liveKeepAlive is how often an idle stream gets a comment, so proxies
+// don't close it.
+var liveKeepAlive = 30 * time.Second
+
+type liveChange struct {
+	AccountId string `json:"account_id"`
+	Kind      string `json:"kind"`
+	Id        string `json:"id"`
+}
+
+// liveHub hands the changes to the streams of their account.
+type liveHub struct {
+	mu      sync.Mutex
+	streams map[string]map[chan liveChange]bool
+}
+
+var live = newLiveHub()
+
+func newLiveHub() *liveHub {
+	return &liveHub{streams: make(map[string]map[chan liveChange]bool)}
+}
+
+// subscribe returns a channel getting the changes of the account until it's
+// unsubscribed.
+func (h *liveHub) subscribe(accountId string) chan liveChange {
+	c := make(chan liveChange, 16)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.streams[accountId] == nil {
+		h.streams[accountId] = make(map[chan liveChange]bool)
+	}
+	h.streams[accountId][c] = true
+	return c
+}
+
+func (h *liveHub) unsubscribe(accountId string, c chan liveChange) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.streams[accountId], c)
+	if len(h.streams[accountId]) == 0 {
+		delete(h.streams, accountId)
+	}
+}
+
+// publish hands the change to the streams of its account. A stream that
+// isn't keeping up gets a resync instead of holding up the others.
+func (h *liveHub) publish(change liveChange) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for c := range h.streams[change.AccountId] {
+		sendChange(c, change)
+	}
+}
+
+// resync tells every stream that changes may have been missed.
+func (h *liveHub) resync() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for accountId, streams := range h.streams {
+		for c := range streams {
+			sendChange(c, liveChange{AccountId: accountId, Kind: liveResync})
+		}
+	}
+}
+
+func sendChange(c chan liveChange, change liveChange) {
+	select {
+	case c <- change:
+	default:
+		// the buffer is full, make room for the resync
+		select {
+		case <-c:
+		default:
+		}
+		select {
+		case c <- liveChange{AccountId: change.AccountId, Kind: liveResync}:
+		default:
+		}
+	}
+}
+
+// listenForChanges publishes the notifications of liveChannel to live.
+// The listener reconnects by itself, the streams are told to resync then as
+// notifications sent meanwhile are lost.
+func listenForChanges(dsn string) {
+	reportProblem := func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("listening for changes: %v", err)
+		}
+	}
+	l := pq.NewListener(dsn, 10*time.Second, time.Minute, reportProblem)
+	if err := l.Listen(liveChannel); err != nil {
+		log.Printf("listening for changes: %v", err)
+	}
+
+	for {
+		select {
+		case n := <-l.Notify:
+			if n == nil {
+				live.resync()
+				continue
+			}
+			var change liveChange
+			if err := json.Unmarshal([]byte(n.Extra), &change); err != nil {
+				log.Printf("reading change %q: %v", n.Extra, err)
+				continue
+			}
+			live.publish(change)
+		case <-time.After(90 * time.Second):
+			go l.Ping()
+		}
+	}
+}
+
+// eventsHandler streams the changes to the account's habits and goals as
+// server-sent events. A habit event carries the habit as /habits/{id}
+// returns it, a goal event the goal.
+func eventsHandler(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "GET" {
+		return methodNotAllowed()
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return errors.New("streaming not supported")
+	}
+
+	accountId := context.Get(r, "accountId").(string)
+	changes := live.subscribe(accountId)
+	defer live.unsubscribe(accountId, changes)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 5000\n\n")
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(liveKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			return nil
+		case <-keepAlive.C:
+			_, err = fmt.Fprint(w, ": keep-alive\n\n")
+		case change := <-changes:
+			err = writeLiveChange(w, change)
+		}
+		if err != nil {
+			// the stream is gone, nothing can be rendered anymore
+			log.Printf("streaming events account=%v: %v", accountId, err)
+			return nil
+		}
+		flusher.Flush()
+	}
+}
+
+// writeLiveChange writes the event of the change. Habits and goals deleted
+// in the meantime are skipped.
+func writeLiveChange(w http.ResponseWriter, change liveChange) error {
+	var data interface{}
+	switch change.Kind {
+	case liveHabit:
+		h, err := getHabit(change.Id, change.AccountId)
+		if err == errHabitNotFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		data = h
+	case liveGoal:
+		g, err := getGoal(change.Id, change.AccountId)
+		if err == errGoalNotFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		data = g
+	case liveResync:
+		data = struct{}{}
+	default:
+		return nil
+	}
+
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", change.Kind, b)
+	return err
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/context"
+)
+
+func TestLiveHub(t *testing.T) {
+	h := newLiveHub()
+	c := h.subscribe("a")
+	other := h.subscribe("b")
+
+	h.publish(liveChange{AccountId: "a", Kind: liveHabit, Id: "1"})
+	if change := <-c; change.Id != "1" {
+		t.Errorf("Expected the change of habit 1, got %+v", change)
+	}
+	if len(other) != 0 {
+		t.Errorf("Expected no change for another account, got %v", len(other))
+	}
+
+	h.unsubscribe("a", c)
+	h.publish(liveChange{AccountId: "a", Kind: liveHabit, Id: "2"})
+	if len(c) != 0 {
+		t.Errorf("Expected no change after unsubscribing, got %v", len(c))
+	}
+	if _, found := h.streams["a"]; found {
+		t.Error("Expected the account to be forgotten")
+	}
+}
+
+func TestLiveHubFullStream(t *testing.T) {
+	h := newLiveHub()
+	c := h.subscribe("a")
+
+	// a stream that isn't read doesn't hold up publishing
+	for i := 0; i < cap(c)+5; i++ {
+		h.publish(liveChange{AccountId: "a", Kind: liveHabit, Id: "1"})
+	}
+	var last liveChange
+	for len(c) > 0 {
+		last = <-c
+	}
+	if last.Kind != liveResync {
+		t.Errorf("Expected a resync after missed changes, got %+v", last)
+	}
+}
+
+func TestSQLiteStorePublishesChanges(t *testing.T) {
+	if _, ok := storage.(sqliteStore); !ok {
+		t.Skip("no SQLite test database")
+	}
+	account, _ := CreateAccount(emailForTests, passwordForTests)
+	defer truncateDatabase()
+	changes := live.subscribe(account.Id)
+	defer live.unsubscribe(account.Id, changes)
+
+	id, _ := createHabit(newHabit("Run", 2, PeriodWeek, time.Now()), account.Id)
+	if _, err := updateHabitProgress(*id, account.Id, &progressEntry{Delta: 1}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case change := <-changes:
+		if change.Kind != liveHabit || change.Id != *id {
+			t.Errorf("Expected the change of the habit, got %+v", change)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected the change of the habit to be published")
+	}
+}
+
+// readEvent returns the name and data of the next event of the stream,
+// skipping comments and the retry field.
+func readEvent(t *testing.T, r *bufio.Reader) (string, string) {
+	var name, data string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && name != "":
+			return name, data
+		case strings.HasPrefix(line, "event: "):
+			name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func TestEventsHandler(t *testing.T) {
+	_, restore := withMemoryStore()
+	defer restore()
+	account, _ := CreateAccount(emailForTests, passwordForTests)
+	id, _ := createHabit(newHabit("Run", 3, PeriodWeek, time.Now()), account.Id)
+	goalId, _ := createGoal(&goal{Description: "Read", PointsTotal: 4}, account.Id)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		context.Set(r, "accountId", account.Id)
+		defer context.Clear(r)
+		appHandler(eventsHandler).ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Errorf("Expected \"text/event-stream\", got %v", resp.Header.Get("Content-Type"))
+	}
+	r := bufio.NewReader(resp.Body)
+
+	// the handler has subscribed once the headers are sent
+	storage.CreateProgressEntry(*id, &progressEntry{Delta: 2})
+	live.publish(liveChange{AccountId: account.Id, Kind: liveHabit, Id: "deleted"})
+	live.publish(liveChange{AccountId: account.Id, Kind: liveHabit, Id: *id})
+	updateGoalPoints(*goalId, account.Id)
+	live.publish(liveChange{AccountId: account.Id, Kind: liveGoal, Id: *goalId})
+
+	name, data := readEvent(t, r)
+	var h habit
+	json.Unmarshal([]byte(data), &h)
+	if name != liveHabit || h.Id != *id || h.Done != 2 {
+		t.Errorf("Expected the habit with 2 done, got %v %v", name, data)
+	}
+	name, data = readEvent(t, r)
+	var g goal
+	json.Unmarshal([]byte(data), &g)
+	if name != liveGoal || g.Id != *goalId || g.PointsDone != 1 {
+		t.Errorf("Expected the goal with a point done, got %v %v", name, data)
+	}
+}
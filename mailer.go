@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// message is a plain text email to a single recipient.
+type message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// mailer sends emails, see newMailer for the implementations.
+type mailer interface {
+	Send(m message) error
+}
+
+// sender sends the emails of the server.
+var sender mailer
+
+// newMailer sends mail through the SMTP server at HABITCAT_SMTP (host:port)
+// if it's set, logging in with HABITCAT_SMTP_USER and HABITCAT_SMTP_PASSWORD
+// if those are set too. Otherwise mails are written to the HABITCAT_OUTBOX
+// directory, "outbox" by default, for local testing.
+func newMailer() mailer {
+	from := os.Getenv("HABITCAT_MAIL_FROM")
+	if from == "" {
+		from = "HabitCat <noreply@habitcat.net>"
+	}
+
+	addr := os.Getenv("HABITCAT_SMTP")
+	if addr == "" {
+		dir := os.Getenv("HABITCAT_OUTBOX")
+		if dir == "" {
+			dir = "outbox"
+		}
+		return outboxMailer{dir: dir, from: from}
+	}
+
+	m := smtpMailer{addr: addr, from: from}
+	if user := os.Getenv("HABITCAT_SMTP_USER"); user != "" {
+		host := strings.Split(addr, ":")[0]
+		m.auth = smtp.PlainAuth("", user, os.Getenv("HABITCAT_SMTP_PASSWORD"), host)
+	}
+	return m
+}
+
+type smtpMailer struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+func (s smtpMailer) Send(m message) error {
+	return smtp.SendMail(s.addr, s.auth, s.from, []string{m.To}, m.bytes(s.from, time.Now()))
+}
+
+// outboxMailer writes every mail to a file of its own in dir instead of
+// sending it.
+type outboxMailer struct {
+	dir  string
+	from string
+}
+
+func (o outboxMailer) Send(m message) error {
+	if err := os.MkdirAll(o.dir, 0755); err != nil {
+		return err
+	}
+	now := time.Now()
+	name := fmt.Sprintf("%d-%s.eml", now.UnixNano(), strings.Replace(m.To, string(filepath.Separator), "_", -1))
+	return ioutil.WriteFile(filepath.Join(o.dir, name), m.bytes(o.from, now), 0644)
+}
+
+// bytes formats the message as an RFC 5322 email.
+func (m message) bytes(from string, date time.Time) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", m.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("\r\n")
+	buf.WriteString(strings.Replace(m.Body, "\n", "\r\n", -1))
+	return buf.Bytes()
+}
@@ -6,7 +6,8 @@ import (
 	"log"
 	"net/http"
 	"os"
-	"text/template"
+	"strings"
+	"time"
 
 	"github.com/gorilla/context"
 	"github.com/gorilla/securecookie"
@@ -18,58 +19,137 @@ type viewHandler func(w http.ResponseWriter, r *http.Request)
 var hashKey = []byte{98, 231, 101, 158, 43, 6, 214, 248, 106, 188, 241, 109, 239, 5, 242, 221, 159, 154, 157, 87, 4, 184, 232, 107, 126, 71, 84, 67, 61, 189, 160, 65}
 var blockKey = []byte{84, 185, 64, 70, 61, 137, 3, 132, 95, 215, 51, 249, 142, 19, 209, 146}
 
-var db *sql.DB
-
 func main() {
+	conn, d, err := connect("activities")
+	if err != nil {
+		log.Fatalln("opening db connection failed:", err)
+	}
+	defer conn.Close()
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := migrateCommand(conn, d, os.Args[2:]); err != nil {
+			log.Fatalln("migrating db failed:", err)
+		}
+		return
+	}
+
 	port := os.Getenv("PORT")
 	if port == "" {
 		log.Fatal("$PORT must be set")
 	}
 
-	var err error
-	db, err = createDBConnection("activities")
+	migrations, err := loadMigrations(migrationFiles, d.migrations)
 	if err != nil {
-		log.Fatalln("opening db connection failed:", err)
+		log.Fatalln("loading migrations failed:", err)
+	}
+	if _, err := migrateDatabase(conn, d, migrations); err != nil {
+		log.Fatalln("migrating db failed:", err)
 	}
-	defer db.Close()
 
-	http.HandleFunc("/", indexHandler)
+	templates, err = loadTemplates("templates", os.Getenv("HABITCAT_DEV") != "")
+	if err != nil {
+		log.Fatalln("parsing templates failed:", err)
+	}
+
+	http.Handle("/", appHandler(indexHandler))
 
-	http.HandleFunc("/login", loginHandler)
+	http.Handle("/login", appHandler(loginHandler))
 	http.HandleFunc("/logout", logoutHandler)
-	http.HandleFunc("/signup", signupHandler)
+	http.Handle("/signup", appHandler(signupHandler))
 
 	http.HandleFunc("/goals", authHandler(goalHandler))
-	http.HandleFunc("/goals/", authHandler(goalUpdateHandler))
+	http.HandleFunc("/goals/", authHandler(idempotent(goalUpdateHandler)))
 	http.HandleFunc("/goals/new", authHandler(goalNewHandler))
 	http.HandleFunc("/goals/create", authHandler(goalCreateHandler))
 
 	http.HandleFunc("/habits", authHandler(habitHandler))
-	http.HandleFunc("/habits/", authHandler(habitUpdateHandler))
+	http.HandleFunc("/habits/", authHandler(idempotent(habitUpdateHandler)))
 	http.HandleFunc("/habits/new", authHandler(habitNewHandler))
 	http.HandleFunc("/habits/create", authHandler(habitCreateHandler))
+	http.HandleFunc("/habits/batch", authHandler(habitBatchHandler))
+	http.HandleFunc("/habits/history/", authHandler(habitHistoryHandler))
+	http.HandleFunc("/habits/pause/", authHandler(habitPauseHandler))
+	http.HandleFunc("/habits/unpause/", authHandler(habitUnpauseHandler))
+	http.HandleFunc("/progress", authHandler(progressSearchHandler))
+	http.HandleFunc("/sync", authHandler(syncHandler))
+	http.HandleFunc("/export", authHandler(exportHandler))
+	http.HandleFunc("/import", authHandler(importHandler))
+
+	http.HandleFunc("/events", authHandler(eventsHandler))
+
+	http.HandleFunc("/goals/reorder", authHandler(goalReorderHandler))
+	http.HandleFunc("/habits/reorder", authHandler(habitReorderHandler))
+	http.HandleFunc("/habits/remind/", authHandler(habitRemindHandler))
+	http.HandleFunc("/habits/unremind/", authHandler(habitUnremindHandler))
+	http.HandleFunc("/habits/tokens/", authHandler(habitTokenHandler))
+	http.HandleFunc("/habits/revoke/", authHandler(habitRevokeHandler))
+	// hooks aren't behind authHandler, which clears the context the
+	// account is kept in for the error log
+	http.Handle("/hooks/", context.ClearHandler(appHandler(hookHandler)))
+	http.HandleFunc("/settings", authHandler(settingsHandler))
+	http.HandleFunc("/calendar", authHandler(calendarPageHandler))
+	// nor are calendar feeds
+	http.Handle("/calendar/", context.ClearHandler(appHandler(calendarHandler)))
+	http.HandleFunc("/webhooks", authHandler(webhooksHandler))
+	http.HandleFunc("/webhooks/new", authHandler(webhookNewHandler))
+	http.HandleFunc("/webhooks/delete/", authHandler(webhookDeleteHandler))
+	http.HandleFunc("/webhooks/log", authHandler(webhookLogHandler))
+
+	sender = newMailer()
+	startJobs(
+		job{"summaries", 10 * time.Minute, sendSummaries},
+		job{"reminders", time.Minute, sendReminders},
+		job{"streaks", 10 * time.Minute, emitBrokenStreaks},
+		job{"webhooks", 30 * time.Second, deliverWebhooks},
+	)
+	// SQLite publishes the changes itself, see sqliteStore
+	if d == postgres {
+		go listenForChanges(postgresDSN("activities"))
+	}
 
 	staticFileServer := http.StripPrefix("/static/", http.FileServer(http.Dir("./static/")))
 	http.Handle("/static/", staticFileServer)
 	http.Handle("/favicon.ico", staticFileServer)
+	http.HandleFunc("/sw.js", serviceWorkerHandler)
 
 	log.Println("Server listening on http://0.0.0.0:" + port)
 	log.Fatal(http.ListenAndServe(":"+port, nil))
 }
 
-func createDBConnection(dbname string) (*sql.DB, error) {
-	var dsn string
-	_, found := os.LookupEnv("DATABASE_POSTGRESQL_USERNAME")
-	if found {
-		// for running test on semaphore ci
-		dsn = fmt.Sprintf("dbname=%s user=runner password=semaphoredb sslmode=disable", dbname)
-	} else {
-		dsn, found = os.LookupEnv("DATABASE_URL")
-		if !found {
-			dsn = fmt.Sprintf("dbname=%s user=postgres sslmode=disable", dbname)
+// connect opens the database of DATABASE_URL and sets storage to it. A URL
+// like sqlite:habitcat.db picks SQLite, anything else is Postgres.
+func connect(dbname string) (*sql.DB, dialect, error) {
+	if url := os.Getenv("DATABASE_URL"); strings.HasPrefix(url, "sqlite:") {
+		conn, err := openSQLite(strings.TrimPrefix(url, "sqlite:"))
+		if err != nil {
+			return nil, sqlite, err
 		}
+		storage = sqliteStore{conn}
+		return conn, sqlite, nil
 	}
-	db, err := sql.Open("postgres", dsn)
+
+	conn, err := createDBConnection(dbname)
+	if err != nil {
+		return nil, postgres, err
+	}
+	storage = postgresStore{conn}
+	return conn, postgres, nil
+}
+
+// postgresDSN returns the connection string of the Postgres database.
+func postgresDSN(dbname string) string {
+	if _, found := os.LookupEnv("DATABASE_POSTGRESQL_USERNAME"); found {
+		// for running test on semaphore ci
+		return fmt.Sprintf("dbname=%s user=runner password=semaphoredb sslmode=disable", dbname)
+	}
+	if dsn, found := os.LookupEnv("DATABASE_URL"); found {
+		return dsn
+	}
+	return fmt.Sprintf("dbname=%s user=postgres sslmode=disable", dbname)
+}
+
+func createDBConnection(dbname string) (*sql.DB, error) {
+	db, err := sql.Open("postgres", postgresDSN(dbname))
 	if err != nil {
 		return nil, err
 	}
@@ -79,34 +159,36 @@ func createDBConnection(dbname string) (*sql.DB, error) {
 	return db, nil
 }
 
-func renderTemplate(w http.ResponseWriter, templateFilename string, data interface{}) {
-	w.Header().Set("Content-Type", "text/html")
+// serviceWorkerHandler serves the service worker from the root, so it
+// controls every page, and uncached, so updates are picked up right away.
+func serviceWorkerHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeFile(w, r, "static/sw.js")
+}
 
-	t, err := template.ParseFiles(templateFilename)
-	if err != nil {
-		log.Fatal(err)
-	}
+func renderTemplate(w http.ResponseWriter, name string, data interface{}) error {
+	w.Header().Set("Content-Type", "text/html")
 
-	err = t.Execute(w, data)
-	if err != nil {
-		log.Fatal(err)
-	}
+	return templates.execute(w, name, data)
 }
 
-func renderTemplateWithErrorMessage(w http.ResponseWriter, templatePath string, msg string) {
+func renderTemplateWithErrorMessage(w http.ResponseWriter, name string, msg string) error {
 	data := struct {
 		ErrorMessage string
 	}{
 		msg,
 	}
-	renderTemplate(w, templatePath, data)
+	return renderTemplate(w, name, data)
 }
 
-func indexHandler(w http.ResponseWriter, r *http.Request) {
-	renderTemplate(w, "templates/index.html", nil)
+func indexHandler(w http.ResponseWriter, r *http.Request) error {
+	if r.URL.Path != "/" {
+		return notFound(nil)
+	}
+	return renderTemplate(w, "index.html", nil)
 }
 
-func authHandler(next viewHandler) viewHandler {
+func authHandler(next appHandler) viewHandler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		cookie, err := r.Cookie("habitcat")
 		if err != nil {
@@ -125,53 +207,34 @@ func authHandler(next viewHandler) viewHandler {
 		context.Set(r, "accountId", value["accountId"])
 		defer context.Clear(r) // clear request context after request is handled
 
-		next(w, r)
+		next.ServeHTTP(w, r)
 	}
 
 	return fn
 }
 
-func loginHandler(w http.ResponseWriter, r *http.Request) {
+func loginHandler(w http.ResponseWriter, r *http.Request) error {
 	if r.Method == "GET" {
-		renderTemplate(w, "templates/login.html", nil)
+		return renderTemplate(w, "login.html", nil)
 	} else if r.Method == "POST" {
 		if err := r.ParseForm(); err != nil {
-			http.Error(w, "", http.StatusInternalServerError)
-			log.Println(err)
-			return
+			return badRequest(err)
 		}
 		email, password := r.FormValue("email"), r.FormValue("password")
 		account, err := GetAccount(email)
 		if err != nil {
 			log.Println(err)
 			msg := "Email/password combination incorrect..."
-			renderTemplateWithErrorMessage(w, "templates/login.html", msg)
-			return
+			return renderTemplateWithErrorMessage(w, "login.html", msg)
 		}
-		if account.ValidatePassword([]byte(password)) {
-			var s = securecookie.New(hashKey, blockKey)
-
-			value := map[string]string{
-				"accountId": account.Id,
-			}
-
-			if encoded, err := s.Encode("habitcat", value); err == nil {
-				cookie := &http.Cookie{Name: "habitcat", Value: encoded, Path: "/"}
-				http.SetCookie(w, cookie)
-				http.Redirect(w, r, "/habits", http.StatusFound)
-				return
-			} else {
-				log.Println(err)
-				return
-			}
-		} else {
+		if !account.ValidatePassword([]byte(password)) {
 			log.Println("Incorrect credentials for user", email)
 			msg := "Email/password combination incorrect..."
-			renderTemplateWithErrorMessage(w, "templates/login.html", msg)
-			return
+			return renderTemplateWithErrorMessage(w, "login.html", msg)
 		}
+		return setSessionCookie(w, r, account)
 	} else {
-		http.Error(w, "", http.StatusMethodNotAllowed)
+		return methodNotAllowed()
 	}
 }
 
@@ -180,50 +243,47 @@ func logoutHandler(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/", http.StatusFound)
 }
 
-func signupHandler(w http.ResponseWriter, r *http.Request) {
+func signupHandler(w http.ResponseWriter, r *http.Request) error {
 	if r.Method == "GET" {
-		renderTemplate(w, "templates/signup.html", nil)
+		return renderTemplate(w, "signup.html", nil)
 	} else if r.Method == "POST" {
 		if err := r.ParseForm(); err != nil {
-			http.Error(w, "", http.StatusInternalServerError)
-			log.Println(err)
-			return
+			return badRequest(err)
 		}
 		email, password := r.FormValue("email"), r.FormValue("password")
 		if !emailInvited(email) {
 			log.Println("Somebody tried to sign up with disallowed email", email)
-			data := struct {
-				ErrorMessage string
-			}{
-				"Bummer! Your email is not on the invitation list...",
-			}
-			renderTemplate(w, "templates/signup.html", data)
-			return
+			msg := "Bummer! Your email is not on the invitation list..."
+			return renderTemplateWithErrorMessage(w, "signup.html", msg)
 		}
 		account, err := CreateAccount(email, password)
 		if err != nil {
 			log.Println("CreateAccount() failed", err)
 			msg := "Account with this email already exists..."
-			renderTemplateWithErrorMessage(w, "templates/signup.html", msg)
-			return
+			return renderTemplateWithErrorMessage(w, "signup.html", msg)
 		}
+		return setSessionCookie(w, r, account)
+	} else {
+		return methodNotAllowed()
+	}
+}
 
-		var s = securecookie.New(hashKey, blockKey)
+// setSessionCookie logs the account in and sends the user to their habits.
+func setSessionCookie(w http.ResponseWriter, r *http.Request, account *Account) error {
+	var s = securecookie.New(hashKey, blockKey)
 
-		value := map[string]string{
-			"accountId": account.Id,
-		}
+	value := map[string]string{
+		"accountId": account.Id,
+	}
 
-		if encoded, err := s.Encode("habitcat", value); err == nil {
-			cookie := &http.Cookie{Name: "habitcat", Value: encoded, Path: "/"}
-			http.SetCookie(w, cookie)
-			http.Redirect(w, r, "/habits", http.StatusFound)
-		} else {
-			log.Println(err)
-		}
-	} else {
-		http.Error(w, "", http.StatusMethodNotAllowed)
+	encoded, err := s.Encode("habitcat", value)
+	if err != nil {
+		return err
 	}
+	cookie := &http.Cookie{Name: "habitcat", Value: encoded, Path: "/"}
+	http.SetCookie(w, cookie)
+	http.Redirect(w, r, "/habits", http.StatusFound)
+	return nil
 }
 
 func emailInvited(email string) bool {
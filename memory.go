@@ -0,0 +1,1236 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var errEmailTaken = errors.New("email already taken")
+
+// errInvalidPeriod is what the period check of the habit table fails with
+// on the databases.
+var errInvalidPeriod = errors.New("invalid period")
+
+// memoryStore keeps everything in memory, for tests. It computes what the
+// Postgres queries compute, like the progress of the current period, so
+// handlers behave the same on both.
+type memoryStore struct {
+	mu       sync.Mutex
+	accounts []Account
+	habits   []memoryHabit
+	pauses   []memoryPause
+	progress []progressEntry
+	goals    []memoryGoal
+	// synced holds the habit and client IDs of the synced entries.
+	synced map[[2]string]bool
+	// keys holds the idempotency keys by account and key.
+	keys map[[2]string]memoryIdempotencyKey
+	// goalPoints holds when the points of the goals were added.
+	goalPoints []memoryGoalPoint
+	settings   map[string]memorySettings
+	tokens     []memoryToken
+	// requests holds the token and request IDs of the progress logged by
+	// hooks.
+	requests          map[[2]string]bool
+	reminders         []reminder
+	deliveries        []memoryReminderDelivery
+	webhooks          []memoryWebhook
+	webhookDeliveries []memoryWebhookDelivery
+
+	// now is when progress is logged and which period is the current one.
+	now func() time.Time
+}
+
+type memoryHabit struct {
+	habit
+	accountId string
+}
+
+type memoryPause struct {
+	habitPause
+	habitId string
+}
+
+type memoryGoal struct {
+	goal
+	accountId string
+}
+
+type memoryIdempotencyKey struct {
+	idempotentResponse
+	created time.Time
+}
+
+type memoryGoalPoint struct {
+	goalId  string
+	created time.Time
+}
+
+type memorySettings struct {
+	settings
+	summarySent   time.Time
+	calendarToken string
+}
+
+type memoryToken struct {
+	habitToken
+	habitId string
+}
+
+type memoryReminderDelivery struct {
+	reminderId string
+	day        time.Time
+	status     string
+	error      string
+	created    time.Time
+}
+
+type memoryWebhook struct {
+	webhook
+	accountId string
+}
+
+type memoryWebhookDelivery struct {
+	webhookDelivery
+	webhookId string
+	key       string
+	payload   string
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{now: time.Now}
+}
+
+// newId returns a random UUID like the ones Postgres generates.
+func newId() (string, error) {
+	b := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+func (s *memoryStore) CreateAccount(email string, hashedPassword []byte) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, a := range s.accounts {
+		if a.Email == email {
+			return "", errEmailTaken
+		}
+	}
+	id, err := newId()
+	if err != nil {
+		return "", err
+	}
+	s.accounts = append(s.accounts, Account{id, email, hashedPassword})
+	return id, nil
+}
+
+func (s *memoryStore) Account(email string) (*Account, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, a := range s.accounts {
+		if a.Email == email {
+			return &a, nil
+		}
+	}
+	return nil, errAccountNotFound
+}
+
+// findHabit returns the habit of the account, the caller holds the lock.
+func (s *memoryStore) findHabit(id, accountId string) (*memoryHabit, error) {
+	for i := range s.habits {
+		if s.habits[i].Id == id && s.habits[i].accountId == accountId {
+			return &s.habits[i], nil
+		}
+	}
+	return nil, errHabitNotFound
+}
+
+// current returns a copy of the habit with the progress of its current
+// period, like habitSelect.
+func (s *memoryStore) current(h habit) habit {
+	now := s.now()
+	start := h.Period.start(now)
+	h.Done = s.sum(h, start, h.Period.next(start))
+	h.PctDone = calcPercentage(h.Done, h.Todo)
+	h.Upcoming = h.Start.After(PeriodDay.start(now))
+	h.Tags = append([]string(nil), h.Tags...)
+	return h
+}
+
+// sum adds up the progress of the habit logged from from until to, leaving
+// out progress from before the habit started.
+func (s *memoryStore) sum(h habit, from, to time.Time) float64 {
+	var done float64
+	for _, e := range s.progress {
+		day := localDay(e.Created)
+		if e.HabitId == h.Id && !day.Before(h.Start) && !day.Before(from) && day.Before(to) {
+			done += e.Delta
+		}
+	}
+	return done
+}
+
+// localDay returns the day t is on in the server's time zone, as a date in
+// UTC like the ones of Period.start, which the periods are compared with.
+// Postgres keeps times in local time for the same reason.
+func localDay(t time.Time) time.Time {
+	return PeriodDay.start(t.Local())
+}
+
+func (s *memoryStore) Habits(accountId string) ([]habit, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var habits []habit
+	for _, h := range s.habits {
+		if h.accountId == accountId && h.Retired == nil {
+			habits = append(habits, s.current(h.habit))
+		}
+	}
+	return habits, nil
+}
+
+func (s *memoryStore) AllHabits(accountId string) ([]habit, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var habits []habit
+	for _, h := range s.habits {
+		if h.accountId == accountId {
+			habits = append(habits, s.current(h.habit))
+		}
+	}
+	return habits, nil
+}
+
+func (s *memoryStore) Habit(id, accountId string) (*habit, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	h, err := s.findHabit(id, accountId)
+	if err != nil {
+		return nil, err
+	}
+	current := s.current(h.habit)
+	return &current, nil
+}
+
+func (s *memoryStore) CreateHabit(h *habit, accountId string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !h.Period.valid() {
+		return "", errInvalidPeriod
+	}
+	id, err := newId()
+	if err != nil {
+		return "", err
+	}
+	stored := habit{
+		Id:          id,
+		Description: h.Description,
+		Kind:        h.Kind,
+		Category:    h.Category,
+		Tags:        append([]string(nil), h.Tags...),
+		Unit:        h.Unit,
+		Todo:        h.Todo,
+		Period:      h.Period,
+		Start:       PeriodDay.start(h.Start),
+		Schedule:    h.Schedule,
+		Weekdays:    h.Weekdays,
+		EveryDays:   h.EveryDays,
+		Retired:     h.Retired,
+	}
+	if stored.Schedule == "" {
+		stored.Schedule = SchedulePeriod
+	}
+	if stored.Kind == "" {
+		stored.Kind = KindTarget
+	}
+	if stored.scheduled() {
+		stored.Todo = h.PerOccurrence
+		stored.PerOccurrence = h.PerOccurrence
+	}
+	s.habits = append(s.habits, memoryHabit{stored, accountId})
+	return id, nil
+}
+
+// history returns the periods of the habit from the one containing its
+// start date up to the current one, like habitHistoryQuery.
+func (s *memoryStore) history(h habit) []periodProgress {
+	var history []periodProgress
+	last := h.Period.start(s.now())
+	for start := h.Period.start(h.Start); !start.After(last); start = h.Period.next(start) {
+		done := s.sum(h, start, h.Period.next(start))
+		history = append(history, periodProgress{
+			Start:   start,
+			Done:    done,
+			Todo:    h.Todo,
+			PctDone: calcPercentage(done, h.Todo),
+		})
+	}
+	return history
+}
+
+func (s *memoryStore) HabitHistories(accountId string) (map[string][]periodProgress, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	histories := make(map[string][]periodProgress)
+	for _, h := range s.habits {
+		if h.accountId == accountId && h.Retired == nil {
+			histories[h.Id] = s.history(h.habit)
+		}
+	}
+	return histories, nil
+}
+
+func (s *memoryStore) HabitHistory(id, accountId string) ([]periodProgress, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// like in Postgres, a habit that isn't found has no history
+	h, err := s.findHabit(id, accountId)
+	if err != nil {
+		return nil, nil
+	}
+	return s.history(h.habit), nil
+}
+
+func (s *memoryStore) DailyProgress(accountId string) (map[string]map[string]float64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	daily := make(map[string]map[string]float64)
+	for _, e := range s.progress {
+		h, err := s.findHabit(e.HabitId, accountId)
+		day := localDay(e.Created)
+		if err != nil || !h.scheduled() || day.Before(h.Start) {
+			continue
+		}
+		if daily[h.Id] == nil {
+			daily[h.Id] = make(map[string]float64)
+		}
+		daily[h.Id][day.Format(dateFormat)] += e.Delta
+	}
+	return daily, nil
+}
+
+func (s *memoryStore) HabitPauses(accountId string) (map[string][]habitPause, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pauses := make(map[string][]habitPause)
+	for _, p := range s.pauses {
+		if _, err := s.findHabit(p.habitId, accountId); err == nil {
+			pauses[p.habitId] = append(pauses[p.habitId], p.habitPause)
+		}
+	}
+	for _, list := range pauses {
+		sort.SliceStable(list, func(i, j int) bool {
+			return list[i].Start.Before(list[j].Start)
+		})
+	}
+	return pauses, nil
+}
+
+func (s *memoryStore) CreateHabitPause(habitId, accountId string, p *habitPause) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.findHabit(habitId, accountId); err != nil {
+		return "", err
+	}
+	id, err := newId()
+	if err != nil {
+		return "", err
+	}
+	s.pauses = append(s.pauses, memoryPause{habitPause{id, p.Start, p.Finish, p.Reason}, habitId})
+	return id, nil
+}
+
+func (s *memoryStore) DeleteHabitPause(id, accountId string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, p := range s.pauses {
+		if _, err := s.findHabit(p.habitId, accountId); err == nil && p.Id == id {
+			s.pauses = append(s.pauses[:i], s.pauses[i+1:]...)
+			return p.habitId, nil
+		}
+	}
+	return "", errPauseNotFound
+}
+
+func (s *memoryStore) CreateProgressEntry(habitId string, entry *progressEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id, err := newId()
+	if err != nil {
+		return err
+	}
+	s.progress = append(s.progress, progressEntry{
+		Id:      id,
+		HabitId: habitId,
+		Delta:   entry.Delta,
+		Note:    entry.Note,
+		Tags:    append([]string(nil), entry.Tags...),
+		Created: s.now(),
+	})
+	return nil
+}
+
+func (s *memoryStore) CreateProgressEntries(accountId string, entries []progressEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := make([]string, len(entries))
+	for i, e := range entries {
+		h, err := s.findHabit(e.HabitId, accountId)
+		if err != nil {
+			return err
+		}
+		if h.Retired != nil {
+			return errHabitNotFound
+		}
+		if ids[i], err = newId(); err != nil {
+			return err
+		}
+	}
+	for i, e := range entries {
+		s.progress = append(s.progress, progressEntry{
+			Id:      ids[i],
+			HabitId: e.HabitId,
+			Delta:   e.Delta,
+			Note:    e.Note,
+			Tags:    append([]string(nil), e.Tags...),
+			Created: e.Created,
+		})
+	}
+	return nil
+}
+
+func (s *memoryStore) SyncProgressEntry(accountId, clientId string, entry *progressEntry) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.findHabit(entry.HabitId, accountId); err != nil {
+		return false, err
+	}
+	key := [2]string{entry.HabitId, clientId}
+	if s.synced[key] {
+		return false, nil
+	}
+	id, err := newId()
+	if err != nil {
+		return false, err
+	}
+	if s.synced == nil {
+		s.synced = make(map[[2]string]bool)
+	}
+	s.synced[key] = true
+	s.progress = append(s.progress, progressEntry{
+		Id:      id,
+		HabitId: entry.HabitId,
+		Delta:   entry.Delta,
+		Note:    entry.Note,
+		Tags:    append([]string(nil), entry.Tags...),
+		Created: entry.Created,
+	})
+	return true, nil
+}
+
+// progressEntries returns the latest entries of the account's habits that
+// match, newest first, the caller holds the lock.
+func (s *memoryStore) progressEntries(accountId string, match func(e progressEntry) bool) []progressEntry {
+	var entries []progressEntry
+	for _, e := range s.progress {
+		h, err := s.findHabit(e.HabitId, accountId)
+		if err != nil || !match(e) {
+			continue
+		}
+		e.Description = h.Description
+		entries = append(entries, e)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Created.After(entries[j].Created)
+	})
+	if len(entries) > maxProgressEntries {
+		entries = entries[:maxProgressEntries]
+	}
+	return entries
+}
+
+func (s *memoryStore) ProgressEntries(habitId, accountId string) ([]progressEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.progressEntries(accountId, func(e progressEntry) bool {
+		return e.HabitId == habitId
+	}), nil
+}
+
+func (s *memoryStore) SearchProgressEntries(accountId, q, tag string) ([]progressEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	q = strings.ToLower(q)
+	return s.progressEntries(accountId, func(e progressEntry) bool {
+		return strings.Contains(strings.ToLower(e.Note), q) && (tag == "" || e.hasTag(tag))
+	}), nil
+}
+
+// EachProgressEntry calls fn without holding the lock, on a copy of the
+// entries.
+func (s *memoryStore) EachProgressEntry(accountId string, from time.Time, fn func(e progressEntry) error) error {
+	s.mu.Lock()
+	var entries []progressEntry
+	for _, e := range s.progress {
+		if localDay(e.Created).Before(from) {
+			continue
+		}
+		if h, err := s.findHabit(e.HabitId, accountId); err == nil {
+			e.Description = h.Description
+			e.Tags = append([]string(nil), e.Tags...)
+			entries = append(entries, e)
+		}
+	}
+	s.mu.Unlock()
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Created.Before(entries[j].Created)
+	})
+	for _, e := range entries {
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e progressEntry) hasTag(tag string) bool {
+	for _, t := range e.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *memoryStore) Goals(accountId string) ([]goal, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var goals []goal
+	for _, g := range s.goals {
+		if g.accountId == accountId {
+			goals = append(goals, g.goal)
+		}
+	}
+	return goals, nil
+}
+
+func (s *memoryStore) CreateGoal(g *goal, accountId string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id, err := newId()
+	if err != nil {
+		return "", err
+	}
+	s.goals = append(s.goals, memoryGoal{goal{
+		Id:          id,
+		Description: g.Description,
+		PointsTotal: g.PointsTotal,
+		Modified:    s.now(),
+		Due:         g.Due,
+	}, accountId})
+	return id, nil
+}
+
+func (s *memoryStore) AddGoalPoint(id, accountId string) (*goal, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.goals {
+		g := &s.goals[i].goal
+		if g.Id != id || s.goals[i].accountId != accountId {
+			continue
+		}
+		g.PointsDone++
+		g.PctDone = int(math.Floor(100*float64(g.PointsDone)/float64(g.PointsTotal) + 0.5))
+		g.Modified = s.now()
+		s.goalPoints = append(s.goalPoints, memoryGoalPoint{id, g.Modified})
+		updated := *g
+		return &updated, nil
+	}
+	return nil, errGoalNotFound
+}
+
+func (s *memoryStore) Import(accountId string, p *importPlan) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// make every ID first so nothing is added if one fails
+	ids := make([]string, len(p.Habits)+len(p.Goals)+len(p.Entries)+len(p.Pauses))
+	for i := range ids {
+		var err error
+		if ids[i], err = newId(); err != nil {
+			return err
+		}
+	}
+
+	habitIds := ids[:len(p.Habits)]
+	for i, h := range p.Habits {
+		h.Id = habitIds[i]
+		h.Start = PeriodDay.start(h.Start)
+		h.Tags = append([]string(nil), h.Tags...)
+		if h.Schedule == "" {
+			h.Schedule = SchedulePeriod
+		}
+		if h.Kind == "" {
+			h.Kind = KindTarget
+		}
+		s.habits = append(s.habits, memoryHabit{h, accountId})
+	}
+	for i, g := range p.Goals {
+		g.Id = ids[len(p.Habits)+i]
+		g.PctDone = int(math.Floor(100*float64(g.PointsDone)/float64(g.PointsTotal) + 0.5))
+		g.Modified = s.now()
+		s.goals = append(s.goals, memoryGoal{g, accountId})
+	}
+	for i, e := range p.Entries {
+		habitId := e.HabitId
+		if habitId == "" {
+			habitId = habitIds[e.NewHabit]
+		}
+		s.progress = append(s.progress, progressEntry{
+			Id:      ids[len(p.Habits)+len(p.Goals)+i],
+			HabitId: habitId,
+			Delta:   e.Delta,
+			Note:    e.Note,
+			Tags:    append([]string(nil), e.Tags...),
+			Created: e.Created,
+		})
+	}
+	for i, pause := range p.Pauses {
+		habitId := pause.HabitId
+		if habitId == "" {
+			habitId = habitIds[pause.NewHabit]
+		}
+		pause.Id = ids[len(p.Habits)+len(p.Goals)+len(p.Entries)+i]
+		s.pauses = append(s.pauses, memoryPause{pause.habitPause, habitId})
+	}
+	return nil
+}
+
+func (s *memoryStore) ClaimIdempotencyKey(accountId, key, request string, retention time.Duration) (*idempotentResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for k, saved := range s.keys {
+		if k[0] == accountId && saved.created.Before(s.now().Add(-retention)) {
+			delete(s.keys, k)
+		}
+	}
+	if saved, found := s.keys[[2]string{accountId, key}]; found {
+		resp := saved.idempotentResponse
+		return &resp, nil
+	}
+	if s.keys == nil {
+		s.keys = make(map[[2]string]memoryIdempotencyKey)
+	}
+	s.keys[[2]string{accountId, key}] = memoryIdempotencyKey{idempotentResponse{Request: request}, s.now()}
+	return nil, nil
+}
+
+func (s *memoryStore) SaveIdempotentResponse(accountId, key string, resp *idempotentResponse) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if saved, found := s.keys[[2]string{accountId, key}]; found {
+		saved.idempotentResponse = *resp
+		saved.Body = append([]byte(nil), resp.Body...)
+		s.keys[[2]string{accountId, key}] = saved
+	}
+	return nil
+}
+
+func (s *memoryStore) ReleaseIdempotencyKey(accountId, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.keys, [2]string{accountId, key})
+	return nil
+}
+
+func (s *memoryStore) GoalSummaries(accountId string, start, end time.Time) ([]goalSummary, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var goals []goalSummary
+	for _, g := range s.goals {
+		if g.accountId != accountId {
+			continue
+		}
+		advanced := 0
+		for _, p := range s.goalPoints {
+			if day := localDay(p.created); p.goalId == g.Id && !day.Before(start) && day.Before(end) {
+				advanced++
+			}
+		}
+		if advanced > 0 {
+			goals = append(goals, goalSummary{g.Description, advanced, g.PointsDone, g.PointsTotal})
+		}
+	}
+	return goals, nil
+}
+
+// ReorderHabits moves the habits of the account around in s.habits, which
+// is in the order they are listed.
+func (s *memoryStore) ReorderHabits(accountId string, ids []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var places []int
+	var current []string
+	byId := make(map[string]memoryHabit)
+	for i, h := range s.habits {
+		if h.accountId == accountId {
+			places = append(places, i)
+			current = append(current, h.Id)
+			byId[h.Id] = h
+		}
+	}
+	order, ok := reorderIds(current, ids)
+	if !ok {
+		return errHabitNotFound
+	}
+	for i, place := range places {
+		s.habits[place] = byId[order[i]]
+	}
+	return nil
+}
+
+func (s *memoryStore) ReorderGoals(accountId string, ids []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var places []int
+	var current []string
+	byId := make(map[string]memoryGoal)
+	for i, g := range s.goals {
+		if g.accountId == accountId {
+			places = append(places, i)
+			current = append(current, g.Id)
+			byId[g.Id] = g
+		}
+	}
+	order, ok := reorderIds(current, ids)
+	if !ok {
+		return errGoalNotFound
+	}
+	for i, place := range places {
+		s.goals[place] = byId[order[i]]
+	}
+	return nil
+}
+
+func (s *memoryStore) Settings(accountId string) (*settings, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st := s.settings[accountId].settings
+	return &st, nil
+}
+
+func (s *memoryStore) UpdateSettings(accountId string, st *settings, summarySent time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.settings == nil {
+		s.settings = make(map[string]memorySettings)
+	}
+	saved := s.settings[accountId]
+	saved.settings, saved.summarySent = *st, summarySent
+	s.settings[accountId] = saved
+	return nil
+}
+
+func (s *memoryStore) ClaimSummaries(p Period, end time.Time) ([]Account, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var accounts []Account
+	for _, a := range s.accounts {
+		saved := s.settings[a.Id]
+		if saved.Summary != p || !saved.summarySent.Before(end) {
+			continue
+		}
+		saved.summarySent = end
+		s.settings[a.Id] = saved
+		accounts = append(accounts, Account{Id: a.Id, Email: a.Email})
+	}
+	return accounts, nil
+}
+
+func (s *memoryStore) CalendarToken(accountId string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.settings[accountId].calendarToken, nil
+}
+
+func (s *memoryStore) SetCalendarToken(accountId, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.settings == nil {
+		s.settings = make(map[string]memorySettings)
+	}
+	saved := s.settings[accountId]
+	saved.calendarToken = token
+	s.settings[accountId] = saved
+	return nil
+}
+
+func (s *memoryStore) CalendarAccount(token string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for accountId, saved := range s.settings {
+		if token != "" && saved.calendarToken == token {
+			return accountId, nil
+		}
+	}
+	return "", errCalendarNotFound
+}
+
+func (s *memoryStore) CreateHabitToken(habitId, accountId string, t *habitToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.findHabit(habitId, accountId); err != nil {
+		return err
+	}
+	id, err := newId()
+	if err != nil {
+		return err
+	}
+	t.Id, t.Created = id, s.now()
+	s.tokens = append(s.tokens, memoryToken{*t, habitId})
+	return nil
+}
+
+func (s *memoryStore) RevokeHabitToken(id, accountId string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.tokens {
+		t := &s.tokens[i]
+		if _, err := s.findHabit(t.habitId, accountId); err != nil || t.Id != id {
+			continue
+		}
+		if t.Revoked == nil {
+			revoked := s.now()
+			t.Revoked = &revoked
+		}
+		return t.habitId, nil
+	}
+	return "", errTokenNotFound
+}
+
+func (s *memoryStore) HabitTokens(habitId, accountId string) ([]habitToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var tokens []habitToken
+	for _, t := range s.tokens {
+		if _, err := s.findHabit(t.habitId, accountId); err == nil && t.habitId == habitId {
+			tokens = append(tokens, t.habitToken)
+		}
+	}
+	return tokens, nil
+}
+
+func (s *memoryStore) HabitByToken(token string) (tokenId, habitId, accountId string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, t := range s.tokens {
+		if t.Token != token || t.Revoked != nil {
+			continue
+		}
+		for _, h := range s.habits {
+			if h.Id == t.habitId && h.Retired == nil {
+				return t.Id, h.Id, h.accountId, nil
+			}
+		}
+	}
+	return "", "", "", errTokenNotFound
+}
+
+// CreateHookProgressEntry holds the lock while logging, so there is never
+// a request in progress to tell about.
+func (s *memoryStore) CreateHookProgressEntry(tokenId, requestId string, entry *progressEntry) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := [2]string{tokenId, requestId}
+	if s.requests[key] {
+		return false, nil
+	}
+	id, err := newId()
+	if err != nil {
+		return false, err
+	}
+	if s.requests == nil {
+		s.requests = make(map[[2]string]bool)
+	}
+	s.requests[key] = true
+	s.progress = append(s.progress, progressEntry{
+		Id:      id,
+		HabitId: entry.HabitId,
+		Delta:   entry.Delta,
+		Note:    entry.Note,
+		Tags:    append([]string(nil), entry.Tags...),
+		Created: s.now(),
+	})
+	return true, nil
+}
+
+func (s *memoryStore) CreateReminder(habitId, accountId string, r *reminder) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.findHabit(habitId, accountId); err != nil {
+		return "", err
+	}
+	id, err := newId()
+	if err != nil {
+		return "", err
+	}
+	stored := *r
+	stored.Id, stored.HabitId = id, habitId
+	s.reminders = append(s.reminders, stored)
+	return id, nil
+}
+
+func (s *memoryStore) DeleteReminder(id, accountId string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, r := range s.reminders {
+		if _, err := s.findHabit(r.HabitId, accountId); err != nil || r.Id != id {
+			continue
+		}
+		s.reminders = append(s.reminders[:i], s.reminders[i+1:]...)
+		var deliveries []memoryReminderDelivery
+		for _, d := range s.deliveries {
+			if d.reminderId != id {
+				deliveries = append(deliveries, d)
+			}
+		}
+		s.deliveries = deliveries
+		return r.HabitId, nil
+	}
+	return "", errReminderNotFound
+}
+
+func (s *memoryStore) Reminders(habitId, accountId string) ([]reminder, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var reminders []reminder
+	for _, r := range s.reminders {
+		if _, err := s.findHabit(r.HabitId, accountId); err == nil && r.HabitId == habitId {
+			reminders = append(reminders, r)
+		}
+	}
+	sort.SliceStable(reminders, func(i, j int) bool {
+		return reminders[i].At < reminders[j].At
+	})
+	return reminders, nil
+}
+
+func (s *memoryStore) ReminderDeliveries(habitId, accountId string) ([]reminderDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var deliveries []reminderDelivery
+	for _, r := range s.reminders {
+		if _, err := s.findHabit(r.HabitId, accountId); err != nil || r.HabitId != habitId {
+			continue
+		}
+		for _, d := range s.deliveries {
+			if d.reminderId == r.Id {
+				deliveries = append(deliveries, reminderDelivery{d.day, r.At, r.Channel, d.status, d.error})
+			}
+		}
+	}
+	sort.SliceStable(deliveries, func(i, j int) bool {
+		if !deliveries[i].Day.Equal(deliveries[j].Day) {
+			return deliveries[i].Day.After(deliveries[j].Day)
+		}
+		return deliveries[i].At > deliveries[j].At
+	})
+	if len(deliveries) > maxReminderDeliveries {
+		deliveries = deliveries[:maxReminderDeliveries]
+	}
+	return deliveries, nil
+}
+
+// delivery returns the delivery of the reminder on day, the caller holds
+// the lock.
+func (s *memoryStore) delivery(reminderId string, day time.Time) *memoryReminderDelivery {
+	for i := range s.deliveries {
+		if s.deliveries[i].reminderId == reminderId && s.deliveries[i].day.Equal(day) {
+			return &s.deliveries[i]
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) DueReminders(at string, day, stale time.Time) ([]dueReminder, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var due []dueReminder
+	for _, r := range s.reminders {
+		if r.At > at {
+			continue
+		}
+		if d := s.delivery(r.Id, day); d != nil && (d.status != "pending" || !d.created.Before(stale)) {
+			continue
+		}
+		for _, h := range s.habits {
+			if h.Id != r.HabitId || h.Retired != nil {
+				continue
+			}
+			for _, a := range s.accounts {
+				if a.Id == h.accountId {
+					due = append(due, dueReminder{r, a.Id, a.Email})
+				}
+			}
+		}
+	}
+	sort.SliceStable(due, func(i, j int) bool {
+		return due[i].AccountId < due[j].AccountId
+	})
+	return due, nil
+}
+
+func (s *memoryStore) ClaimReminderDelivery(reminderId string, day, stale time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d := s.delivery(reminderId, day)
+	if d == nil {
+		s.deliveries = append(s.deliveries, memoryReminderDelivery{reminderId, day, "pending", "", s.now()})
+		return true, nil
+	}
+	if d.status != "pending" || !d.created.Before(stale) {
+		return false, nil
+	}
+	d.created = s.now()
+	return true, nil
+}
+
+func (s *memoryStore) FinishReminderDelivery(reminderId string, day time.Time, status, msg string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if d := s.delivery(reminderId, day); d != nil {
+		d.status, d.error = status, msg
+	}
+	return nil
+}
+
+func (s *memoryStore) CreateWebhook(accountId string, hook *webhook) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id, err := newId()
+	if err != nil {
+		return err
+	}
+	hook.Id = id
+	stored := *hook
+	stored.Events = append([]string(nil), hook.Events...)
+	s.webhooks = append(s.webhooks, memoryWebhook{stored, accountId})
+	return nil
+}
+
+func (s *memoryStore) DeleteWebhook(id, accountId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, h := range s.webhooks {
+		if h.Id != id || h.accountId != accountId {
+			continue
+		}
+		s.webhooks = append(s.webhooks[:i], s.webhooks[i+1:]...)
+		var deliveries []memoryWebhookDelivery
+		for _, d := range s.webhookDeliveries {
+			if d.webhookId != id {
+				deliveries = append(deliveries, d)
+			}
+		}
+		s.webhookDeliveries = deliveries
+		return nil
+	}
+	return errWebhookNotFound
+}
+
+func (s *memoryStore) Webhooks(accountId string) ([]webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var hooks []webhook
+	for _, h := range s.webhooks {
+		if h.accountId == accountId {
+			hook := h.webhook
+			hook.Secret = ""
+			hook.Events = append([]string(nil), h.Events...)
+			hooks = append(hooks, hook)
+		}
+	}
+	return hooks, nil
+}
+
+func (h memoryWebhook) subscribed(event string) bool {
+	for _, e := range h.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *memoryStore) WebhookAccounts(event string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var accounts []string
+	seen := make(map[string]bool)
+	for _, h := range s.webhooks {
+		if h.subscribed(event) && !seen[h.accountId] {
+			seen[h.accountId] = true
+			accounts = append(accounts, h.accountId)
+		}
+	}
+	return accounts, nil
+}
+
+func (s *memoryStore) QueueWebhookEvent(accountId, event, key, payload string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, h := range s.webhooks {
+		if h.accountId != accountId || !h.subscribed(event) || s.queued(h.Id, key) {
+			continue
+		}
+		id, err := newId()
+		if err != nil {
+			return err
+		}
+		now := s.now()
+		s.webhookDeliveries = append(s.webhookDeliveries, memoryWebhookDelivery{
+			webhookDelivery{Id: id, Event: event, Status: "pending", NextAttempt: now, Created: now},
+			h.Id, key, payload,
+		})
+	}
+	return nil
+}
+
+// queued reports whether an event with the key was queued for the webhook
+// before, the caller holds the lock.
+func (s *memoryStore) queued(webhookId, key string) bool {
+	for _, d := range s.webhookDeliveries {
+		if key != "" && d.webhookId == webhookId && d.key == key {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *memoryStore) WebhookDeliveries(accountId string) ([]webhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var deliveries []webhookDelivery
+	for i := len(s.webhookDeliveries) - 1; i >= 0; i-- {
+		d := s.webhookDeliveries[i]
+		for _, h := range s.webhooks {
+			if h.Id == d.webhookId && h.accountId == accountId {
+				d.URL = h.URL
+				deliveries = append(deliveries, d.webhookDelivery)
+			}
+		}
+	}
+	sort.SliceStable(deliveries, func(i, j int) bool {
+		return deliveries[i].Created.After(deliveries[j].Created)
+	})
+	if len(deliveries) > maxWebhookDeliveries {
+		deliveries = deliveries[:maxWebhookDeliveries]
+	}
+	return deliveries, nil
+}
+
+func (s *memoryStore) ClaimWebhookDeliveries(now, until time.Time) ([]pendingDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var due []*memoryWebhookDelivery
+	for i := range s.webhookDeliveries {
+		d := &s.webhookDeliveries[i]
+		if d.Status == "pending" && !d.NextAttempt.After(now) {
+			due = append(due, d)
+		}
+	}
+	sort.SliceStable(due, func(i, j int) bool {
+		return due[i].NextAttempt.Before(due[j].NextAttempt)
+	})
+	if len(due) > maxClaimedDeliveries {
+		due = due[:maxClaimedDeliveries]
+	}
+
+	var pending []pendingDelivery
+	for _, d := range due {
+		for _, h := range s.webhooks {
+			if h.Id == d.webhookId {
+				d.NextAttempt = until
+				pending = append(pending, pendingDelivery{d.Id, h.URL, h.Secret, d.Event, d.payload, d.Attempts})
+			}
+		}
+	}
+	return pending, nil
+}
+
+func (s *memoryStore) FinishWebhookDelivery(d webhookDelivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.webhookDeliveries {
+		saved := &s.webhookDeliveries[i]
+		if saved.Id == d.Id {
+			saved.Status, saved.Attempts, saved.NextAttempt = d.Status, d.Attempts, d.NextAttempt
+			saved.ResponseStatus, saved.Error, saved.Delivered = d.ResponseStatus, d.Error, d.Delivered
+		}
+	}
+	return nil
+}
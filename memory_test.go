@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/context"
+)
+
+func TestMemoryStoreHabitHistory(t *testing.T) {
+	s, restore := withMemoryStore()
+	defer restore()
+
+	// Wednesday of the third week of the habit
+	now := time.Date(2016, time.January, 27, 12, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	account, _ := CreateAccount(emailForTests, passwordForTests)
+	id, _ := createHabit(newHabit("Run", 2, PeriodWeek, date(2016, time.January, 13)), account.Id)
+
+	for _, day := range []time.Time{date(2016, time.January, 12), date(2016, time.January, 14), date(2016, time.January, 19), date(2016, time.January, 26)} {
+		now = day
+		storage.CreateProgressEntry(*id, &progressEntry{Delta: 1})
+	}
+	now = time.Date(2016, time.January, 27, 12, 0, 0, 0, time.UTC)
+
+	h, err := getHabit(*id, account.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Done != 1 {
+		t.Errorf("Expected 1, got %v", h.Done)
+	}
+	if len(h.history) != 3 {
+		t.Fatalf("Expected 3 periods, got %v", len(h.history))
+	}
+	// the progress from before the start date doesn't count
+	for i, done := range []float64{1, 1, 1} {
+		if h.history[i].Done != done {
+			t.Errorf("Expected %v in period %v, got %v", done, i, h.history[i].Done)
+		}
+	}
+	if !h.history[0].Start.Equal(date(2016, time.January, 11)) {
+		t.Errorf("Expected the week of the start date, got %v", h.history[0].Start)
+	}
+}
+
+func TestMemoryStoreLocalTime(t *testing.T) {
+	local := time.Local
+	defer func() { time.Local = local }()
+
+	// late west of UTC and early east of it, on another day in UTC
+	for _, test := range []struct {
+		zone *time.Location
+		hour int
+	}{
+		{time.FixedZone("UTC-5", -5*60*60), 22},
+		{time.FixedZone("UTC+14", 14*60*60), 1},
+	} {
+		time.Local = test.zone
+		s, restore := withMemoryStore()
+		now := time.Date(2016, time.January, 27, test.hour, 0, 0, 0, time.Local)
+		s.now = func() time.Time { return now }
+
+		account, _ := CreateAccount(emailForTests, passwordForTests)
+		id, _ := createHabit(newHabit("Run", 2, PeriodDay, date(2016, time.January, 20)), account.Id)
+		storage.CreateProgressEntry(*id, &progressEntry{Delta: 1})
+
+		h, _ := storage.Habit(*id, account.Id)
+		if h.Done != 1 {
+			t.Errorf("Expected 1 done today in %v, got %v", test.zone, h.Done)
+		}
+		history, _ := storage.HabitHistory(*id, account.Id)
+		if last := history[len(history)-1]; !last.Start.Equal(date(2016, time.January, 27)) || last.Done != 1 {
+			t.Errorf("Expected 1 done on 2016-01-27 in %v, got %+v", test.zone, last)
+		}
+		restore()
+	}
+}
+
+func TestMemoryStoreNotFound(t *testing.T) {
+	_, restore := withMemoryStore()
+	defer restore()
+
+	account, _ := CreateAccount(emailForTests, passwordForTests)
+	other, _ := CreateAccount("other@habitcat.net", passwordForTests)
+	id, _ := createHabit(newHabit("Run", 2, PeriodWeek, time.Now()), account.Id)
+	goalId, _ := createGoal(&goal{Description: "Read", PointsTotal: 3}, account.Id)
+
+	if _, err := getHabit(*id, other.Id); err != errHabitNotFound {
+		t.Errorf("Expected %v, got %v", errHabitNotFound, err)
+	}
+	if _, err := updateGoalPoints(*goalId, other.Id); err != errGoalNotFound {
+		t.Errorf("Expected %v, got %v", errGoalNotFound, err)
+	}
+	if _, err := CreateAccount(emailForTests, passwordForTests); err == nil {
+		t.Errorf("Expected error for a taken email")
+	}
+	if _, err := GetAccount("nobody@habitcat.net"); err != errAccountNotFound {
+		t.Errorf("Expected %v, got %v", errAccountNotFound, err)
+	}
+}
+
+func TestHabitHandlersMemory(t *testing.T) {
+	_, restore := withMemoryStore()
+	defer restore()
+	account, _ := CreateAccount(emailForTests, passwordForTests)
+
+	form := url.Values{"description": {"Read"}, "todo": {"3"}, "unit": {"pages"}, "period": {"week"}}
+	req, err := http.NewRequest("POST", "https://localhost/habits/create", strings.NewReader(form.Encode()))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	context.Set(req, "accountId", account.Id)
+	defer context.Clear(req)
+
+	w := httptest.NewRecorder()
+	appHandler(habitCreateHandler).ServeHTTP(w, req)
+	if w.Code != http.StatusFound {
+		t.Fatalf("Expected %v, got %v", http.StatusFound, w.Code)
+	}
+
+	habits, _ := getHabits(account.Id)
+	if len(habits) != 1 {
+		t.Fatalf("Expected 1 habit, got %v", len(habits))
+	}
+
+	req, err = http.NewRequest("POST", "https://localhost/habits/"+habits[0].Id, strings.NewReader("amount=2&note=chapter+1"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	context.Set(req, "accountId", account.Id)
+	defer context.Clear(req)
+
+	w = httptest.NewRecorder()
+	appHandler(habitUpdateHandler).ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected %v, got %v", http.StatusOK, w.Code)
+	}
+	var h habit
+	if err := json.Unmarshal(w.Body.Bytes(), &h); err != nil {
+		t.Fatal(err)
+	}
+	if h.Done != 2 || h.DoneText != "2 pages" {
+		t.Errorf("Expected 2 pages, got %v (%v)", h.Done, h.DoneText)
+	}
+
+	req, err = http.NewRequest("GET", "https://localhost/habits", nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	context.Set(req, "accountId", account.Id)
+	defer context.Clear(req)
+
+	w = httptest.NewRecorder()
+	appHandler(habitHandler).ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected %v, got %v", http.StatusOK, w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "Read") {
+		t.Errorf("Expected the habit to be listed")
+	}
+
+	entries, _ := storage.SearchProgressEntries(account.Id, "CHAPTER", "")
+	if len(entries) != 1 || entries[0].Description != "Read" {
+		t.Errorf("Expected the entry of Read, got %v", entries)
+	}
+}
+
+func TestGoalUpdateHandlerMemory(t *testing.T) {
+	_, restore := withMemoryStore()
+	defer restore()
+	account, _ := CreateAccount(emailForTests, passwordForTests)
+	id, _ := createGoal(&goal{Description: "Read", PointsTotal: 3}, account.Id)
+
+	req, err := http.NewRequest("POST", "https://localhost/goals/"+*id, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	context.Set(req, "accountId", account.Id)
+	defer context.Clear(req)
+
+	w := httptest.NewRecorder()
+	appHandler(goalUpdateHandler).ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected %v, got %v", http.StatusOK, w.Code)
+	}
+	if w.Body.String() != "33" {
+		t.Errorf("Expected 33, got %v", w.Body.String())
+	}
+}
+
+func TestLoginHandlerMemory(t *testing.T) {
+	_, restore := withMemoryStore()
+	defer restore()
+	CreateAccount(emailForTests, passwordForTests)
+
+	tests := []struct {
+		password string
+		expected int
+	}{
+		{passwordForTests, http.StatusFound},
+		{"wrong", http.StatusOK},
+	}
+	for _, test := range tests {
+		form := url.Values{"email": {emailForTests}, "password": {test.password}}
+		req, err := http.NewRequest("POST", "https://localhost/login", strings.NewReader(form.Encode()))
+		if err != nil {
+			log.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		w := httptest.NewRecorder()
+		appHandler(loginHandler).ServeHTTP(w, req)
+		if w.Code != test.expected {
+			t.Errorf("Expected %v for %v, got %v", test.expected, test.password, w.Code)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"database/sql"
+	"embed"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:embed sql_migrations/*.sql sqlite_migrations/*.sql
+var migrationFiles embed.FS
+
+// dialect holds what differs between the databases the app runs on.
+type dialect struct {
+	// driver is the database/sql driver name.
+	driver string
+	// migrations is the directory of the migrations of the database.
+	migrations string
+	// lockMigrations locks schema_migrations until the end of the
+	// transaction, if the database needs it.
+	lockMigrations string
+	// hasTables is an expression telling whether the tables of the app
+	// exist.
+	hasTables string
+}
+
+var postgres = dialect{
+	driver:         "postgres",
+	migrations:     "sql_migrations",
+	lockMigrations: "LOCK TABLE schema_migrations IN EXCLUSIVE MODE",
+	hasTables:      "to_regclass('habit') IS NOT NULL",
+}
+
+// sqlite transactions lock the whole database on their first write, which
+// is the insert into schema_migrations
+var sqlite = dialect{
+	driver:     "sqlite3",
+	migrations: "sqlite_migrations",
+	hasTables:  "EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'habit')",
+}
+
+// migration is one file of sql_migrations or sqlite_migrations, e.g.
+// 07_account.sql is version 7 named account.
+type migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+var errUnversionedSchema = errors.New("database has tables but no applied migrations, " +
+	"run `activities migrate -baseline <version>` with the last migration applied by hand")
+
+const schemaMigrationsTable = `CREATE TABLE IF NOT EXISTS schema_migrations (
+       version integer NOT NULL PRIMARY KEY,
+       name text NOT NULL,
+       applied timestamp NOT NULL DEFAULT current_timestamp
+)`
+
+// loadMigrations reads the migrations from the .sql files of the directory,
+// ordered by version.
+func loadMigrations(fsys fs.FS, dir string) ([]migration, error) {
+	names, err := fs.Glob(fsys, dir+"/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []migration
+	seen := make(map[int]string)
+	for _, name := range names {
+		base := strings.TrimSuffix(path.Base(name), ".sql")
+		i := strings.Index(base, "_")
+		if i < 0 {
+			return nil, fmt.Errorf("migration %s: name must look like 07_account.sql", name)
+		}
+		version, err := strconv.Atoi(base[:i])
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: name must start with a version number", name)
+		}
+		if other, found := seen[version]; found {
+			return nil, fmt.Errorf("migrations %s and %s have the same version", other, name)
+		}
+		seen[version] = name
+
+		b, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, migration{version, base[i+1:], string(b)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// migrateDatabase applies the migrations that haven't been applied yet, each
+// in its own transaction, and returns how many it applied. It fails without
+// changing anything if the database has a migration the code doesn't know
+// about, the code is then older than the schema.
+func migrateDatabase(db *sql.DB, d dialect, migrations []migration) (int, error) {
+	if _, err := db.Exec(schemaMigrationsTable); err != nil {
+		return 0, err
+	}
+
+	var latest, count int
+	var unversioned bool
+	query := "SELECT coalesce(max(version), -1), count(*), " + d.hasTables + " FROM schema_migrations"
+	if err := db.QueryRow(query).Scan(&latest, &count, &unversioned); err != nil {
+		return 0, err
+	}
+	if len(migrations) > 0 && latest > migrations[len(migrations)-1].Version {
+		return 0, fmt.Errorf("database schema is at version %d, ahead of the latest migration %d",
+			latest, migrations[len(migrations)-1].Version)
+	}
+	if count == 0 && unversioned {
+		return 0, errUnversionedSchema
+	}
+
+	applied := 0
+	for _, m := range migrations {
+		ok, err := applyMigration(db, d, m)
+		if err != nil {
+			return applied, fmt.Errorf("migration %02d_%s: %v", m.Version, m.Name, err)
+		}
+		if ok {
+			log.Printf("applied migration %02d_%s", m.Version, m.Name)
+			applied++
+		}
+	}
+	return applied, nil
+}
+
+// applyMigration runs the migration unless it was applied before. The lock
+// keeps two processes starting at the same time from both applying it.
+func applyMigration(db *sql.DB, d dialect, m migration) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if d.lockMigrations != "" {
+		if _, err := tx.Exec(d.lockMigrations); err != nil {
+			return false, err
+		}
+	}
+	var done bool
+	err = tx.QueryRow("SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1)", m.Version).Scan(&done)
+	if err != nil || done {
+		return false, err
+	}
+
+	if _, err := tx.Exec(m.SQL); err != nil {
+		return false, err
+	}
+	_, err = tx.Exec("INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", m.Version, m.Name)
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// baselineDatabase records the migrations up to version as applied without
+// running them, for databases that were migrated by hand before.
+func baselineDatabase(db *sql.DB, migrations []migration, version int) error {
+	known := false
+	for _, m := range migrations {
+		known = known || m.Version == version
+	}
+	if !known {
+		return fmt.Errorf("no migration with version %d", version)
+	}
+
+	if _, err := db.Exec(schemaMigrationsTable); err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		if m.Version > version {
+			break
+		}
+		query := `INSERT INTO schema_migrations (version, name) VALUES ($1, $2)
+                          ON CONFLICT DO NOTHING`
+		if _, err := db.Exec(query, m.Version, m.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateCommand runs `activities migrate`, which applies the pending
+// migrations and exits.
+func migrateCommand(conn *sql.DB, d dialect, args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	baseline := flags.Int("baseline", -1, "mark the migrations up to this version as applied without running them")
+	flags.Parse(args)
+
+	migrations, err := loadMigrations(migrationFiles, d.migrations)
+	if err != nil {
+		return err
+	}
+	if *baseline >= 0 {
+		if err := baselineDatabase(conn, migrations, *baseline); err != nil {
+			return err
+		}
+	}
+	n, err := migrateDatabase(conn, d, migrations)
+	if err != nil {
+		return err
+	}
+	log.Printf("%d migrations applied", n)
+	return nil
+}
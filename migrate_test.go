@@ -0,0 +1,81 @@
+package main
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	fsys := fstest.MapFS{
+		"sql_migrations/10_habit_schedule.sql": {Data: []byte("ALTER TABLE habit ADD schedule text;")},
+		"sql_migrations/02_set_timestamps.sql": {Data: []byte("SELECT 2;")},
+		"sql_migrations/readme.txt":            {Data: []byte("not a migration")},
+	}
+	migrations, err := loadMigrations(fsys, "sql_migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) != 2 {
+		t.Fatalf("Expected 2 migrations, got %v", len(migrations))
+	}
+	if migrations[0].Version != 2 || migrations[0].Name != "set_timestamps" {
+		t.Errorf("Expected 2 set_timestamps, got %v %v", migrations[0].Version, migrations[0].Name)
+	}
+	if migrations[1].Version != 10 || migrations[1].SQL != "ALTER TABLE habit ADD schedule text;" {
+		t.Errorf("Expected 10 with its SQL, got %v %q", migrations[1].Version, migrations[1].SQL)
+	}
+}
+
+func TestLoadMigrationsErrors(t *testing.T) {
+	tests := []fstest.MapFS{
+		{"sql_migrations/account.sql": {}},
+		{"sql_migrations/seven_account.sql": {}},
+		{"sql_migrations/07_account.sql": {}, "sql_migrations/7_account_again.sql": {}},
+	}
+	for _, fsys := range tests {
+		if _, err := loadMigrations(fsys, "sql_migrations"); err == nil {
+			t.Errorf("Expected error for %v", fsys)
+		}
+	}
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	for _, d := range []dialect{postgres, sqlite} {
+		migrations, err := loadMigrations(migrationFiles, d.migrations)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(migrations) == 0 {
+			t.Errorf("Expected migrations in %v", d.migrations)
+		}
+		for i, m := range migrations {
+			if m.Version != i {
+				t.Errorf("Expected version %v, got %v (%v)", i, m.Version, m.Name)
+			}
+		}
+	}
+}
+
+func TestMigrateDatabaseTwice(t *testing.T) {
+	requireDatabase(t)
+	migrations, _ := loadMigrations(migrationFiles, testDialect.migrations)
+
+	applied, err := migrateDatabase(testDB, testDialect, migrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if applied != 0 {
+		t.Errorf("Expected 0, got %v", applied)
+	}
+}
+
+func TestMigrateDatabaseAhead(t *testing.T) {
+	requireDatabase(t)
+	migrations, _ := loadMigrations(migrationFiles, testDialect.migrations)
+	testDB.Exec("INSERT INTO schema_migrations (version, name) VALUES (9999, 'future')")
+	defer testDB.Exec("DELETE FROM schema_migrations WHERE version = 9999")
+
+	if _, err := migrateDatabase(testDB, testDialect, migrations); err == nil {
+		t.Errorf("Expected error for a schema ahead of the code")
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/context"
+)
+
+func habitReorderHandler(w http.ResponseWriter, r *http.Request) error {
+	return reorderHandler(w, r, storage.ReorderHabits, errHabitNotFound)
+}
+
+func goalReorderHandler(w http.ResponseWriter, r *http.Request) error {
+	return reorderHandler(w, r, storage.ReorderGoals, errGoalNotFound)
+}
+
+// reorderHandler stores the order of the habits or goals listed in the
+// request, see readOrder.
+func reorderHandler(w http.ResponseWriter, r *http.Request, reorder func(accountId string, ids []string) error, errNotFound error) error {
+	if r.Method != "POST" {
+		return methodNotAllowed()
+	}
+	ids, err := readOrder(r)
+	if err != nil {
+		return err
+	}
+
+	accountId := context.Get(r, "accountId")
+	if err := reorder(accountId.(string), ids); err == errNotFound {
+		return notFound(err)
+	} else if err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// readOrder reads the IDs of a reorder request in their new order, from
+// either repeated id form values or a JSON body like {"ids": ["..."]}.
+func readOrder(r *http.Request) ([]string, error) {
+	var ids []string
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		var body struct {
+			Ids []string `json:"ids"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			return nil, badRequest(err)
+		}
+		ids = body.Ids
+	} else {
+		if err := r.ParseForm(); err != nil {
+			return nil, badRequest(err)
+		}
+		ids = r.PostForm["id"]
+	}
+
+	if len(ids) == 0 {
+		return nil, badRequest(errors.New("ids missing"))
+	}
+	seen := make(map[string]bool)
+	for _, id := range ids {
+		if seen[id] {
+			return nil, badRequest(errors.New("id listed twice: " + id))
+		}
+		seen[id] = true
+	}
+	return ids, nil
+}
+
+// reorderIds puts the listed IDs in the places the listed IDs take in the
+// current order, in the listed order. It reports false if an ID isn't in
+// the current order.
+func reorderIds(current, ids []string) ([]string, bool) {
+	listed := make(map[string]bool)
+	for _, id := range ids {
+		listed[id] = true
+	}
+
+	order := make([]string, len(current))
+	next := 0
+	for i, id := range current {
+		if listed[id] {
+			order[i] = ids[next]
+			next++
+		} else {
+			order[i] = id
+		}
+	}
+	if next != len(ids) {
+		return nil, false
+	}
+	return order, true
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/context"
+)
+
+func TestReorderIds(t *testing.T) {
+	tests := []struct {
+		current, ids []string
+		expected     string
+		ok           bool
+	}{
+		{[]string{"a", "b", "c"}, []string{"c", "a", "b"}, "c,a,b", true},
+		{[]string{"a", "b", "c", "d"}, []string{"d", "b"}, "a,d,c,b", true},
+		{[]string{"a", "b"}, []string{"b", "x"}, "", false},
+	}
+	for _, test := range tests {
+		order, ok := reorderIds(test.current, test.ids)
+		if ok != test.ok || strings.Join(order, ",") != test.expected {
+			t.Errorf("Expected %v (%v) for %v, got %v (%v)", test.expected, test.ok, test.ids, order, ok)
+		}
+	}
+}
+
+func TestReadOrder(t *testing.T) {
+	tests := []struct {
+		contentType, body string
+		expected          string
+		code              int
+	}{
+		{"application/x-www-form-urlencoded", "id=b&id=a", "b,a", 0},
+		{"application/json", `{"ids": ["b", "a"]}`, "b,a", 0},
+		{"application/x-www-form-urlencoded", "", "", http.StatusBadRequest},
+		{"application/x-www-form-urlencoded", "id=a&id=a", "", http.StatusBadRequest},
+		{"application/json", `{"ids": "a"}`, "", http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		req, err := http.NewRequest("POST", "https://localhost/habits/reorder", strings.NewReader(test.body))
+		if err != nil {
+			log.Fatal(err)
+		}
+		req.Header.Set("Content-Type", test.contentType)
+
+		ids, err := readOrder(req)
+		if test.code != 0 {
+			if e, ok := err.(*httpError); !ok || e.Code != test.code {
+				t.Errorf("Expected %v for %q, got %v", test.code, test.body, err)
+			}
+			continue
+		}
+		if err != nil || strings.Join(ids, ",") != test.expected {
+			t.Errorf("Expected %v for %q, got %v (%v)", test.expected, test.body, ids, err)
+		}
+	}
+}
+
+func TestHabitReorderHandler(t *testing.T) {
+	defer withTestStore()()
+	account, _ := CreateAccount(emailForTests, passwordForTests)
+	defer truncateDatabase()
+
+	first, _ := createHabit(newHabit("First", 1, PeriodWeek, time.Now()), account.Id)
+	second, _ := createHabit(newHabit("Second", 1, PeriodWeek, time.Now()), account.Id)
+	third, _ := createHabit(newHabit("Third", 1, PeriodWeek, time.Now()), account.Id)
+
+	body := "id=" + *third + "&id=" + *first + "&id=" + *second
+	req, err := http.NewRequest("POST", "https://localhost/habits/reorder", strings.NewReader(body))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	context.Set(req, "accountId", account.Id)
+	defer context.Clear(req)
+
+	w := httptest.NewRecorder()
+	appHandler(habitReorderHandler).ServeHTTP(w, req)
+
+	if w.Code != http.StatusNoContent {
+		t.Errorf("Expected %v, got %v", http.StatusNoContent, w.Code)
+	}
+	habits, err := getHabits(account.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var descriptions []string
+	for _, h := range habits {
+		descriptions = append(descriptions, h.Description)
+	}
+	if strings.Join(descriptions, ",") != "Third,First,Second" {
+		t.Errorf("Expected Third,First,Second, got %v", descriptions)
+	}
+}
+
+func TestHabitReorderHandlerNotFound(t *testing.T) {
+	defer withTestStore()()
+	account, _ := CreateAccount(emailForTests, passwordForTests)
+	defer truncateDatabase()
+
+	req, err := http.NewRequest("POST", "https://localhost/habits/reorder", strings.NewReader("id="+uuidForTests))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	context.Set(req, "accountId", account.Id)
+	defer context.Clear(req)
+
+	w := httptest.NewRecorder()
+	appHandler(habitReorderHandler).ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected %v, got %v", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestGetGoalsOrderedByPosition(t *testing.T) {
+	defer withTestStore()()
+	account, _ := CreateAccount(emailForTests, passwordForTests)
+	defer truncateDatabase()
+
+	first, _ := createGoal(&goal{Description: "First", PointsTotal: 1}, account.Id)
+	second, _ := createGoal(&goal{Description: "Second", PointsTotal: 1}, account.Id)
+	if err := storage.ReorderGoals(account.Id, []string{*second, *first}); err != nil {
+		t.Fatal(err)
+	}
+
+	goals, err := storage.Goals(account.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(goals) != 2 || goals[0].Id != *second || goals[1].Id != *first {
+		t.Errorf("Expected Second, First, got %v", goals)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/context"
+)
+
+// habitPause is an interval, both days included, during which a habit is
+// not tracked, e.g. because of a vacation or an illness.
+type habitPause struct {
+	Id     string
+	Start  time.Time
+	Finish time.Time
+	Reason string
+}
+
+var errPauseNotFound = errors.New("pause not found")
+
+// overlaps reports whether the pause covers any day of the period starting
+// at periodStart.
+func (p habitPause) overlaps(period Period, periodStart time.Time) bool {
+	return p.Start.Before(period.next(periodStart)) && !p.Finish.Before(periodStart)
+}
+
+// applyPauses marks the periods of the history that overlap a pause and
+// returns the pause overlapping the current (last) period, if any.
+func applyPauses(history []periodProgress, pauses []habitPause, period Period) *habitPause {
+	var current *habitPause
+	for i := range history {
+		for j := range pauses {
+			if pauses[j].overlaps(period, history[i].Start) {
+				history[i].Paused = true
+				if i == len(history)-1 {
+					current = &pauses[j]
+				}
+			}
+		}
+	}
+	return current
+}
+
+func habitPauseHandler(w http.ResponseWriter, r *http.Request) error {
+	uuid := r.URL.Path[len("/habits/pause/"):]
+	if len(uuid) == 0 {
+		return badRequest(errors.New("habit id missing"))
+	}
+
+	accountId := context.Get(r, "accountId")
+	if r.Method == "GET" {
+		if _, err := storage.Habit(uuid, accountId.(string)); err == errHabitNotFound {
+			return notFound(err)
+		} else if err != nil {
+			return err
+		}
+		today := time.Now()
+		defaults := url.Values{
+			"start":  {today.Format(dateFormat)},
+			"finish": {today.AddDate(0, 0, 7).Format(dateFormat)},
+		}
+		return renderTemplate(w, "habits_pause.html", formData{Values: defaults})
+	} else if r.Method != "POST" {
+		return methodNotAllowed()
+	}
+
+	if err := r.ParseForm(); err != nil {
+		return badRequest(err)
+	}
+	pause, errs := validatePauseForm(r.PostForm)
+	if errs != nil {
+		return renderInvalidForm(w, r, "habits_pause.html", errs)
+	}
+
+	if _, err := storage.CreateHabitPause(uuid, accountId.(string), pause); err == errHabitNotFound {
+		return notFound(err)
+	} else if err != nil {
+		return err
+	}
+
+	http.Redirect(w, r, "/habits/history/"+uuid, http.StatusFound)
+	return nil
+}
+
+func habitUnpauseHandler(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "POST" {
+		return methodNotAllowed()
+	}
+	uuid := r.URL.Path[len("/habits/unpause/"):]
+	if len(uuid) == 0 {
+		return badRequest(errors.New("pause id missing"))
+	}
+
+	accountId := context.Get(r, "accountId")
+	habitId, err := storage.DeleteHabitPause(uuid, accountId.(string))
+	if err == errPauseNotFound {
+		return notFound(err)
+	} else if err != nil {
+		return err
+	}
+
+	http.Redirect(w, r, "/habits/history/"+habitId, http.StatusFound)
+	return nil
+}
+
+func validatePauseForm(form url.Values) (*habitPause, validationErrors) {
+	errs := validationErrors{}
+
+	start, err := time.Parse(dateFormat, strings.TrimSpace(form.Get("start")))
+	if err != nil {
+		errs["start"] = "Must be a date like 2016-01-31"
+	}
+	finish, err := time.Parse(dateFormat, strings.TrimSpace(form.Get("finish")))
+	if err != nil {
+		errs["finish"] = "Must be a date like 2016-01-31"
+	} else if finish.Before(start) {
+		errs["finish"] = "Can't be before the first day of the pause"
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return &habitPause{
+		Start:  start,
+		Finish: finish,
+		Reason: strings.TrimSpace(form.Get("reason")),
+	}, nil
+}
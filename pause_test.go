@@ -0,0 +1,196 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/context"
+)
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func TestHabitPauseOverlaps(t *testing.T) {
+	monday := date(2016, time.January, 11)
+	tests := []struct {
+		start, finish time.Time
+		expected      bool
+	}{
+		{date(2016, time.January, 1), date(2016, time.January, 10), false},
+		{date(2016, time.January, 1), date(2016, time.January, 11), true},
+		{date(2016, time.January, 13), date(2016, time.January, 14), true},
+		{date(2016, time.January, 17), date(2016, time.January, 30), true},
+		{date(2016, time.January, 18), date(2016, time.January, 30), false},
+	}
+	for _, test := range tests {
+		p := habitPause{Start: test.start, Finish: test.finish}
+		if actual := p.overlaps(PeriodWeek, monday); actual != test.expected {
+			t.Errorf("Expected %v for %v - %v, got %v", test.expected, test.start, test.finish, actual)
+		}
+	}
+}
+
+func TestApplyPauses(t *testing.T) {
+	history := []periodProgress{
+		{Start: date(2016, time.January, 4), Done: 2, Todo: 2},
+		{Start: date(2016, time.January, 11), Done: 0, Todo: 2},
+		{Start: date(2016, time.January, 18), Done: 0, Todo: 2},
+	}
+	pauses := []habitPause{
+		{Start: date(2016, time.January, 12), Finish: date(2016, time.January, 20), Reason: "Flu"},
+	}
+
+	current := applyPauses(history, pauses, PeriodWeek)
+	if current == nil || current.Reason != "Flu" {
+		t.Errorf("Expected current pause, got %v", current)
+	}
+	if history[0].Paused || !history[1].Paused || !history[2].Paused {
+		t.Errorf("Expected last two periods to be paused, got %v", history)
+	}
+	if streak := currentStreak(history); streak != 1 {
+		t.Errorf("Expected streak 1, got %v", streak)
+	}
+	if completed, total := completionStats(history); completed != 1 || total != 1 {
+		t.Errorf("Expected 1 of 1, got %v of %v", completed, total)
+	}
+}
+
+func TestValidatePauseForm(t *testing.T) {
+	p, errs := validatePauseForm(url.Values{"start": {"2016-01-11"}, "finish": {"2016-01-17"}, "reason": {" Vacation "}})
+	if errs != nil {
+		t.Fatalf("Expected nil, got %v", errs)
+	}
+	if p.Reason != "Vacation" {
+		t.Errorf("Expected Vacation, got %v", p.Reason)
+	}
+
+	_, errs = validatePauseForm(url.Values{"start": {"2016-01-11"}, "finish": {"2016-01-10"}})
+	if errs["finish"] == "" {
+		t.Errorf("Expected finish error, got %v", errs)
+	}
+
+	_, errs = validatePauseForm(url.Values{"start": {"soon"}, "finish": {"2016-01-10"}})
+	if errs["start"] == "" {
+		t.Errorf("Expected start error, got %v", errs)
+	}
+}
+
+func TestGetHabitsPaused(t *testing.T) {
+	requireDatabase(t)
+	account, _ := CreateAccount(emailForTests, passwordForTests)
+	defer truncateDatabase()
+
+	now := time.Now()
+	id, _ := createHabit(newHabit("Paused", 2, PeriodWeek, now.AddDate(0, 0, -14)), account.Id)
+	createHabit(newHabit("Active", 3, PeriodWeek, now), account.Id)
+	_, err := storage.CreateHabitPause(*id, account.Id, &habitPause{Start: now, Finish: now.AddDate(0, 0, 3), Reason: "Trip"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	habits, err := getHabits(account.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, h := range habits {
+		if h.Description == "Paused" && (!h.Paused || h.Pause.Reason != "Trip") {
+			t.Errorf("Expected habit to be paused, got %v", h)
+		}
+		if h.Description == "Active" && h.Paused {
+			t.Errorf("Expected habit not to be paused")
+		}
+	}
+	if _, todo := totalPointsThisWeek(habits); todo != 3 {
+		t.Errorf("Expected 3, got %v", todo)
+	}
+}
+
+func TestCreateHabitPauseNotFound(t *testing.T) {
+	requireDatabase(t)
+	account, _ := CreateAccount(emailForTests, passwordForTests)
+	defer truncateDatabase()
+
+	now := time.Now()
+	_, err := storage.CreateHabitPause(uuidForTests, account.Id, &habitPause{Start: now, Finish: now})
+	if err != errHabitNotFound {
+		t.Errorf("Expected %v, got %v", errHabitNotFound, err)
+	}
+}
+
+func TestHabitPauseHandlerSuccess(t *testing.T) {
+	requireDatabase(t)
+	account, _ := CreateAccount(emailForTests, passwordForTests)
+	id, _ := createHabit(newHabit("Habit", 2, PeriodWeek, time.Now()), account.Id)
+	defer truncateDatabase()
+
+	body := "start=2016-01-11&finish=2016-01-17&reason=Ski"
+	req, err := http.NewRequest("POST", "https://localhost/habits/pause/"+*id, strings.NewReader(body))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	context.Set(req, "accountId", account.Id)
+	defer context.Clear(req)
+
+	w := httptest.NewRecorder()
+	appHandler(habitPauseHandler).ServeHTTP(w, req)
+
+	if w.Code != http.StatusFound {
+		t.Errorf("Expected %v, got %v", http.StatusFound, w.Code)
+	}
+	pauses, err := storage.HabitPauses(account.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pauses[*id]) != 1 {
+		t.Fatalf("Expected 1 pause, got %v", len(pauses[*id]))
+	}
+
+	req, err = http.NewRequest("POST", "https://localhost/habits/unpause/"+pauses[*id][0].Id, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	context.Set(req, "accountId", account.Id)
+	defer context.Clear(req)
+
+	w = httptest.NewRecorder()
+	appHandler(habitUnpauseHandler).ServeHTTP(w, req)
+
+	if w.Code != http.StatusFound {
+		t.Errorf("Expected %v, got %v", http.StatusFound, w.Code)
+	}
+	pauses, err = storage.HabitPauses(account.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pauses[*id]) != 0 {
+		t.Errorf("Expected no pauses, got %v", len(pauses[*id]))
+	}
+}
+
+func TestHabitPauseHandlerForm(t *testing.T) {
+	_, restore := withMemoryStore()
+	defer restore()
+	account, _ := CreateAccount(emailForTests, passwordForTests)
+	other, _ := CreateAccount("other@habitcat.net", passwordForTests)
+	id, _ := createHabit(newHabit("Habit", 2, PeriodWeek, time.Now()), account.Id)
+
+	for accountId, code := range map[string]int{account.Id: http.StatusOK, other.Id: http.StatusNotFound} {
+		req, _ := http.NewRequest("GET", "https://localhost/habits/pause/"+*id, nil)
+		context.Set(req, "accountId", accountId)
+
+		w := httptest.NewRecorder()
+		appHandler(habitPauseHandler).ServeHTTP(w, req)
+		context.Clear(req)
+
+		if w.Code != code {
+			t.Errorf("Expected %v, got %v", code, w.Code)
+		}
+	}
+}
@@ -0,0 +1,1169 @@
+package main
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// postgresStore keeps everything in the Postgres database set up by the
+// migrations.
+type postgresStore struct {
+	db *sql.DB
+}
+
+// queryer is what *sql.DB and *sql.Tx have in common, for queries that run
+// both on their own and as part of a transaction.
+type queryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func (s postgresStore) CreateAccount(email string, hashedPassword []byte) (string, error) {
+	var id string
+
+	query := "INSERT INTO account (email, password) VALUES ($1, $2) RETURNING id"
+	if err := s.db.QueryRow(query, email, string(hashedPassword)).Scan(&id); err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+func (s postgresStore) Account(email string) (*Account, error) {
+	var id, password string
+
+	query := "SELECT id, password FROM account WHERE email = $1"
+	err := s.db.QueryRow(query, email).Scan(&id, &password)
+	if err == sql.ErrNoRows {
+		return nil, errAccountNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &Account{id, email, []byte(password)}, nil
+}
+
+// habitSelect selects the columns read by scanHabit. The progress is the
+// sum of the current period.
+const habitSelect = `SELECT id,
+                    description,
+                    points,
+                    (SELECT coalesce(sum(delta), 0)
+                     FROM habit_progress p
+                     WHERE h.id = p.habit_id
+                       AND p.created >= h.start
+                       AND p.created >= date_trunc(h.period::text, now())
+                       AND p.created < date_trunc(h.period::text, now()) + ('1 ' || h.period)::interval),
+                    period,
+                    start,
+                    start > current_date,
+                    schedule,
+                    weekdays,
+                    every_days,
+                    unit,
+                    kind,
+                    category,
+                    array_to_string(tags, ','),
+                    retired
+                  FROM habit h`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanHabit(row rowScanner) (*habit, error) {
+	var id, description, period, schedule, unit, kind, category, tags string
+	var done, todo float64
+	var weekdays, everyDays int
+	var start time.Time
+	var upcoming bool
+	var retired *time.Time
+
+	err := row.Scan(&id, &description, &todo, &done, &period, &start, &upcoming, &schedule, &weekdays, &everyDays, &unit, &kind, &category, &tags, &retired)
+	if err != nil {
+		return nil, err
+	}
+
+	h := &habit{
+		Id:          id,
+		Description: description,
+		Kind:        Kind(kind),
+		Category:    category,
+		Unit:        unit,
+		Todo:        todo,
+		Done:        done,
+		PctDone:     calcPercentage(done, todo),
+		Period:      Period(period),
+		Start:       start,
+		Retired:     retired,
+		Upcoming:    upcoming,
+		Schedule:    Schedule(schedule),
+		Weekdays:    weekdaySet(weekdays),
+		EveryDays:   everyDays,
+	}
+	if tags != "" {
+		h.Tags = strings.Split(tags, ",")
+	}
+	if h.scheduled() {
+		h.PerOccurrence = todo
+	}
+	return h, nil
+}
+
+func (s postgresStore) Habits(accountId string) ([]habit, error) {
+	return s.queryHabits(habitSelect+" WHERE h.account_id = $1 AND retired IS NULL ORDER BY h.position, h.created, h.id", accountId)
+}
+
+func (s postgresStore) AllHabits(accountId string) ([]habit, error) {
+	return s.queryHabits(habitSelect+" WHERE h.account_id = $1 ORDER BY h.position, h.created, h.id", accountId)
+}
+
+func (s postgresStore) queryHabits(query string, args ...interface{}) ([]habit, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var habits []habit
+	for rows.Next() {
+		h, err := scanHabit(rows)
+		if err != nil {
+			return nil, err
+		}
+		habits = append(habits, *h)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return habits, nil
+}
+
+func (s postgresStore) Habit(id, accountId string) (*habit, error) {
+	query := habitSelect + " WHERE h.id = $1 AND h.account_id = $2"
+
+	h, err := scanHabit(s.db.QueryRow(query, id, accountId))
+	if err == sql.ErrNoRows {
+		return nil, errHabitNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	return h, nil
+}
+
+func (s postgresStore) CreateHabit(h *habit, accountId string) (string, error) {
+	return insertHabit(s.db, h, accountId)
+}
+
+func insertHabit(q queryer, h *habit, accountId string) (string, error) {
+	var id string
+
+	points := h.Todo
+	if h.scheduled() {
+		points = h.PerOccurrence
+	}
+	schedule := h.Schedule
+	if schedule == "" {
+		schedule = SchedulePeriod
+	}
+	kind := h.Kind
+	if kind == "" {
+		kind = KindTarget
+	}
+
+	query := `INSERT INTO habit (description, kind, points, unit, period, start, schedule, weekdays, every_days,
+                                     category, tags, retired, account_id, position)
+                  VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, string_to_array($11, ','), $12, $13,
+                          (SELECT coalesce(max(position), 0) + 1 FROM habit WHERE account_id = $13))
+                  RETURNING id`
+	err := q.QueryRow(query, h.Description, string(kind), points, h.Unit, string(h.Period), h.Start,
+		string(schedule), int(h.Weekdays), h.EveryDays, h.Category, strings.Join(h.Tags, ","), h.Retired, accountId).Scan(&id)
+	if err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+// habitHistoryQuery lists every period of a habit from the one containing
+// its start date up to the current one. Progress logged before the start
+// date is ignored.
+const habitHistoryQuery = `SELECT h.id,
+                    h.points,
+                    s.start,
+                    (SELECT coalesce(sum(delta), 0)
+                     FROM habit_progress p
+                     WHERE h.id = p.habit_id
+                       AND p.created >= h.start
+                       AND p.created >= s.start
+                       AND p.created < s.start + ('1 ' || h.period)::interval)
+                  FROM habit h,
+                       generate_series(date_trunc(h.period::text, h.start::timestamp),
+                                       date_trunc(h.period::text, now()::timestamp),
+                                       ('1 ' || h.period)::interval) AS s(start)
+                  WHERE h.account_id = $1`
+
+func (s postgresStore) HabitHistories(accountId string) (map[string][]periodProgress, error) {
+	query := habitHistoryQuery + " AND h.retired IS NULL ORDER BY h.id, s.start"
+	return s.queryHabitHistories(query, accountId)
+}
+
+func (s postgresStore) HabitHistory(id, accountId string) ([]periodProgress, error) {
+	query := habitHistoryQuery + " AND h.id = $2 ORDER BY s.start"
+	histories, err := s.queryHabitHistories(query, accountId, id)
+	if err != nil {
+		return nil, err
+	}
+	return histories[id], nil
+}
+
+func (s postgresStore) queryHabitHistories(query string, args ...interface{}) (map[string][]periodProgress, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	histories := make(map[string][]periodProgress)
+	var id string
+	var todo, done float64
+	var start time.Time
+
+	for rows.Next() {
+		if err := rows.Scan(&id, &todo, &start, &done); err != nil {
+			return nil, err
+		}
+		histories[id] = append(histories[id], periodProgress{
+			Start:   start,
+			Done:    done,
+			Todo:    todo,
+			PctDone: calcPercentage(done, todo),
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return histories, nil
+}
+
+func (s postgresStore) DailyProgress(accountId string) (map[string]map[string]float64, error) {
+	query := `SELECT p.habit_id, p.created::date, sum(p.delta)
+                  FROM habit_progress p JOIN habit h ON h.id = p.habit_id
+                  WHERE h.account_id = $1
+                    AND h.schedule <> 'period'
+                    AND p.created >= h.start
+                  GROUP BY p.habit_id, p.created::date`
+
+	rows, err := s.db.Query(query, accountId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	daily := make(map[string]map[string]float64)
+	var habitId string
+	var day time.Time
+	var done float64
+
+	for rows.Next() {
+		if err := rows.Scan(&habitId, &day, &done); err != nil {
+			return nil, err
+		}
+		if daily[habitId] == nil {
+			daily[habitId] = make(map[string]float64)
+		}
+		daily[habitId][day.Format(dateFormat)] = done
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return daily, nil
+}
+
+func (s postgresStore) HabitPauses(accountId string) (map[string][]habitPause, error) {
+	query := `SELECT p.habit_id, p.id, p.start, p.finish, p.reason
+                  FROM habit_pause p JOIN habit h ON h.id = p.habit_id
+                  WHERE h.account_id = $1
+                  ORDER BY p.start`
+
+	rows, err := s.db.Query(query, accountId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pauses := make(map[string][]habitPause)
+	var habitId, id, reason string
+	var start, finish time.Time
+
+	for rows.Next() {
+		if err := rows.Scan(&habitId, &id, &start, &finish, &reason); err != nil {
+			return nil, err
+		}
+		pauses[habitId] = append(pauses[habitId], habitPause{
+			Id:     id,
+			Start:  start,
+			Finish: finish,
+			Reason: reason,
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return pauses, nil
+}
+
+func (s postgresStore) CreateHabitPause(habitId, accountId string, p *habitPause) (string, error) {
+	var id string
+
+	query := `INSERT INTO habit_pause (habit_id, start, finish, reason)
+                  SELECT id, $3, $4, $5 FROM habit WHERE id = $1 AND account_id = $2
+                  RETURNING id`
+	err := s.db.QueryRow(query, habitId, accountId, p.Start, p.Finish, p.Reason).Scan(&id)
+	if err == sql.ErrNoRows {
+		return "", errHabitNotFound
+	} else if err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+func (s postgresStore) DeleteHabitPause(id, accountId string) (string, error) {
+	var habitId string
+
+	query := `DELETE FROM habit_pause p USING habit h
+                  WHERE p.id = $1 AND p.habit_id = h.id AND h.account_id = $2
+                  RETURNING h.id`
+	err := s.db.QueryRow(query, id, accountId).Scan(&habitId)
+	if err == sql.ErrNoRows {
+		return "", errPauseNotFound
+	} else if err != nil {
+		return "", err
+	}
+
+	return habitId, nil
+}
+
+func (s postgresStore) CreateProgressEntry(habitId string, entry *progressEntry) error {
+	query := `INSERT INTO habit_progress (habit_id, delta, note, tags)
+                  VALUES ($1, $2, $3, string_to_array($4, ','))`
+	_, err := s.db.Exec(query, habitId, entry.Delta, entry.Note, strings.Join(entry.Tags, ","))
+	return err
+}
+
+func (s postgresStore) CreateProgressEntries(accountId string, entries []progressEntry) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	ids := habitIds(entries)
+	var found int
+	query := `SELECT count(*) FROM habit
+                  WHERE account_id = $1 AND id = ANY($2::uuid[]) AND retired IS NULL`
+	if err := tx.QueryRow(query, accountId, textArray(ids)).Scan(&found); err != nil {
+		return err
+	}
+	if found != len(ids) {
+		return errHabitNotFound
+	}
+
+	stmt, err := tx.Prepare(`INSERT INTO habit_progress (habit_id, delta, note, tags, created)
+                                 VALUES ($1, $2, $3, string_to_array($4, ','), $5)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, e := range entries {
+		if _, err := stmt.Exec(e.HabitId, e.Delta, e.Note, strings.Join(e.Tags, ","), e.Created); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s postgresStore) SyncProgressEntry(accountId, clientId string, entry *progressEntry) (bool, error) {
+	query := `INSERT INTO habit_progress (habit_id, delta, note, tags, created, client_id)
+                  SELECT id, $3, $4, string_to_array($5, ','), $6, $7 FROM habit
+                  WHERE id = $1 AND account_id = $2
+                  ON CONFLICT (habit_id, client_id) DO NOTHING`
+	res, err := s.db.Exec(query, entry.HabitId, accountId, entry.Delta, entry.Note, strings.Join(entry.Tags, ","), entry.Created, clientId)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// progressSelect selects the columns read by queryProgressEntries. Tags are
+// passed as a comma separated string as they can't contain commas.
+const progressSelect = `SELECT p.id, h.id, h.description, p.delta, p.note,
+                    array_to_string(p.tags, ','), p.created
+                  FROM habit_progress p JOIN habit h ON h.id = p.habit_id`
+
+func (s postgresStore) ProgressEntries(habitId, accountId string) ([]progressEntry, error) {
+	query := progressSelect + ` WHERE h.id = $1 AND h.account_id = $2
+                  ORDER BY p.created DESC LIMIT ` + strconv.Itoa(maxProgressEntries)
+	return s.queryProgressEntries(query, habitId, accountId)
+}
+
+func (s postgresStore) SearchProgressEntries(accountId, q, tag string) ([]progressEntry, error) {
+	query := progressSelect + ` WHERE h.account_id = $1
+                    AND ($2 = '' OR p.note ILIKE '%' || $2 || '%')
+                    AND ($3 = '' OR $3 = ANY(p.tags))
+                  ORDER BY p.created DESC LIMIT ` + strconv.Itoa(maxProgressEntries)
+	return s.queryProgressEntries(query, accountId, escapeLike(q), tag)
+}
+
+func (s postgresStore) EachProgressEntry(accountId string, from time.Time, fn func(e progressEntry) error) error {
+	query := progressSelect + ` WHERE h.account_id = $1 AND p.created >= $2
+                  ORDER BY p.created, p.id`
+	return s.eachProgressEntry(query, fn, accountId, from)
+}
+
+func (s postgresStore) queryProgressEntries(query string, args ...interface{}) ([]progressEntry, error) {
+	var entries []progressEntry
+	err := s.eachProgressEntry(query, func(e progressEntry) error {
+		entries = append(entries, e)
+		return nil
+	}, args...)
+	return entries, err
+}
+
+// eachProgressEntry calls fn with the entries of the query as they are read.
+func (s postgresStore) eachProgressEntry(query string, fn func(e progressEntry) error, args ...interface{}) error {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e progressEntry
+		var tags string
+		err := rows.Scan(&e.Id, &e.HabitId, &e.Description, &e.Delta, &e.Note, &tags, &e.Created)
+		if err != nil {
+			return err
+		}
+		if tags != "" {
+			e.Tags = strings.Split(tags, ",")
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern so searching for
+// "100%" doesn't match everything starting with 100.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+func (s postgresStore) Goals(accountId string) ([]goal, error) {
+	var goals []goal
+
+	query := `SELECT id,
+                         description,
+                         ROUND(100.0 * points_done / points_total),
+                         points_done,
+                         points_total,
+                         modified,
+                         due
+                  FROM goal
+                  WHERE account_id = $1
+                  ORDER BY position, created, id`
+
+	rows, err := s.db.Query(query, accountId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id, description string
+		var pctDone, pointsDone, pointsTotal int
+		var modified time.Time
+		var due *time.Time
+
+		if err := rows.Scan(&id, &description, &pctDone, &pointsDone, &pointsTotal, &modified, &due); err != nil {
+			return nil, err
+		}
+		goals = append(goals, goal{
+			Id:          id,
+			Description: description,
+			PctDone:     pctDone,
+			PointsDone:  pointsDone,
+			PointsTotal: pointsTotal,
+			Modified:    modified,
+			Due:         due,
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return goals, nil
+}
+
+func (s postgresStore) CreateGoal(g *goal, accountId string) (string, error) {
+	var id string
+
+	query := `INSERT INTO goal (description, points_total, due, account_id, position)
+                  VALUES ($1, $2, $3, $4, (SELECT coalesce(max(position), 0) + 1 FROM goal WHERE account_id = $4))
+                  RETURNING id`
+	err := s.db.QueryRow(query, g.Description, g.PointsTotal, g.Due, accountId).Scan(&id)
+	if err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+func (s postgresStore) AddGoalPoint(id, accountId string) (*goal, error) {
+	g := goal{Id: id}
+	query := `WITH g AS (UPDATE goal SET points_done = points_done + 1
+                             WHERE id = $1 AND account_id = $2
+                             RETURNING id, description, points_done, points_total, modified,
+                                       ROUND(100.0 * points_done / points_total) AS pct_done),
+                       p AS (INSERT INTO goal_progress (goal_id, delta) SELECT id, 1 FROM g)
+                  SELECT description, points_done, points_total, pct_done, modified FROM g`
+	err := s.db.QueryRow(query, id, accountId).Scan(&g.Description, &g.PointsDone, &g.PointsTotal, &g.PctDone, &g.Modified)
+	if err == sql.ErrNoRows {
+		return nil, errGoalNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &g, nil
+}
+
+// Import loads the progress with COPY, which is much faster than an INSERT
+// per entry for long histories.
+func (s postgresStore) Import(accountId string, p *importPlan) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	habitIds := make([]string, len(p.Habits))
+	for i := range p.Habits {
+		if habitIds[i], err = insertHabit(tx, &p.Habits[i], accountId); err != nil {
+			return err
+		}
+	}
+	for _, g := range p.Goals {
+		query := `INSERT INTO goal (description, points_done, points_total, due, account_id, position)
+                          VALUES ($1, $2, $3, $4, $5, (SELECT coalesce(max(position), 0) + 1 FROM goal WHERE account_id = $5))`
+		if _, err := tx.Exec(query, g.Description, g.PointsDone, g.PointsTotal, g.Due, accountId); err != nil {
+			return err
+		}
+	}
+	for _, p := range p.Pauses {
+		habitId := p.HabitId
+		if habitId == "" {
+			habitId = habitIds[p.NewHabit]
+		}
+		query := "INSERT INTO habit_pause (habit_id, start, finish, reason) VALUES ($1, $2, $3, $4)"
+		if _, err := tx.Exec(query, habitId, p.Start, p.Finish, p.Reason); err != nil {
+			return err
+		}
+	}
+
+	stmt, err := tx.Prepare(pq.CopyIn("habit_progress", "habit_id", "delta", "note", "tags", "created"))
+	if err != nil {
+		return err
+	}
+	for _, e := range p.Entries {
+		habitId := e.HabitId
+		if habitId == "" {
+			habitId = habitIds[e.NewHabit]
+		}
+		if _, err := stmt.Exec(habitId, e.Delta, e.Note, textArray(e.Tags), e.Created); err != nil {
+			return err
+		}
+	}
+	if _, err := stmt.Exec(); err != nil {
+		return err
+	}
+	if err := stmt.Close(); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s postgresStore) ClaimIdempotencyKey(accountId, key, request string, retention time.Duration) (*idempotentResponse, error) {
+	query := `DELETE FROM idempotency_key
+                  WHERE account_id = $1 AND created < current_timestamp - $2 * interval '1 second'`
+	if _, err := s.db.Exec(query, accountId, retention.Seconds()); err != nil {
+		return nil, err
+	}
+
+	query = `INSERT INTO idempotency_key (account_id, key, request) VALUES ($1, $2, $3)
+                 ON CONFLICT DO NOTHING`
+	res, err := s.db.Exec(query, accountId, key, request)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 1 {
+		return nil, err
+	}
+
+	resp := &idempotentResponse{}
+	query = `SELECT request, coalesce(code, 0), coalesce(content_type, ''), coalesce(body, '')
+                 FROM idempotency_key WHERE account_id = $1 AND key = $2`
+	err = s.db.QueryRow(query, accountId, key).Scan(&resp.Request, &resp.Code, &resp.ContentType, &resp.Body)
+	if err == sql.ErrNoRows {
+		// released by the first request just now, it's still going on
+		return &idempotentResponse{Request: request}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (s postgresStore) SaveIdempotentResponse(accountId, key string, resp *idempotentResponse) error {
+	query := `UPDATE idempotency_key SET code = $3, content_type = $4, body = $5
+                  WHERE account_id = $1 AND key = $2`
+	_, err := s.db.Exec(query, accountId, key, resp.Code, resp.ContentType, resp.Body)
+	return err
+}
+
+func (s postgresStore) ReleaseIdempotencyKey(accountId, key string) error {
+	_, err := s.db.Exec("DELETE FROM idempotency_key WHERE account_id = $1 AND key = $2", accountId, key)
+	return err
+}
+
+func (s postgresStore) GoalSummaries(accountId string, start, end time.Time) ([]goalSummary, error) {
+	query := `SELECT g.description, sum(p.delta), g.points_done, g.points_total
+                  FROM goal_progress p JOIN goal g ON g.id = p.goal_id
+                  WHERE g.account_id = $1 AND p.created >= $2 AND p.created < $3
+                  GROUP BY g.id
+                  ORDER BY g.position, g.created`
+
+	rows, err := s.db.Query(query, accountId, start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var goals []goalSummary
+	for rows.Next() {
+		var g goalSummary
+		if err := rows.Scan(&g.Description, &g.Advanced, &g.PointsDone, &g.PointsTotal); err != nil {
+			return nil, err
+		}
+		goals = append(goals, g)
+	}
+	return goals, rows.Err()
+}
+
+func (s postgresStore) ReorderHabits(accountId string, ids []string) error {
+	return s.reorder("habit", accountId, ids, errHabitNotFound)
+}
+
+func (s postgresStore) ReorderGoals(accountId string, ids []string) error {
+	return s.reorder("goal", accountId, ids, errGoalNotFound)
+}
+
+// reorder renumbers the positions of the rows of the table so that the
+// listed ones are in the given order.
+func (s postgresStore) reorder(table, accountId string, ids []string, errNotFound error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := "SELECT id FROM " + table + " WHERE account_id = $1 ORDER BY position, created FOR UPDATE"
+	rows, err := tx.Query(query, accountId)
+	if err != nil {
+		return err
+	}
+	var current []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		current = append(current, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	order, ok := reorderIds(current, ids)
+	if !ok {
+		return errNotFound
+	}
+	for i, id := range order {
+		if _, err := tx.Exec("UPDATE "+table+" SET position = $1 WHERE id = $2", i+1, id); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s postgresStore) Settings(accountId string) (*settings, error) {
+	var summary sql.NullString
+
+	query := "SELECT summary FROM account WHERE id = $1"
+	if err := s.db.QueryRow(query, accountId).Scan(&summary); err != nil {
+		return nil, err
+	}
+
+	return &settings{Summary: Period(summary.String)}, nil
+}
+
+func (s postgresStore) UpdateSettings(accountId string, st *settings, summarySent time.Time) error {
+	var summary, sent interface{}
+	if st.Summary != "" {
+		summary, sent = string(st.Summary), summarySent
+	}
+
+	query := "UPDATE account SET summary = $2, summary_sent = $3 WHERE id = $1"
+	_, err := s.db.Exec(query, accountId, summary, sent)
+	return err
+}
+
+func (s postgresStore) ClaimSummaries(p Period, end time.Time) ([]Account, error) {
+	query := `UPDATE account SET summary_sent = $2
+                  WHERE summary = $1 AND (summary_sent IS NULL OR summary_sent < $2)
+                  RETURNING id, email`
+
+	rows, err := s.db.Query(query, string(p), end)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var accounts []Account
+	for rows.Next() {
+		var a Account
+		if err := rows.Scan(&a.Id, &a.Email); err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, a)
+	}
+	return accounts, rows.Err()
+}
+
+func (s postgresStore) CalendarToken(accountId string) (string, error) {
+	var token sql.NullString
+	err := s.db.QueryRow("SELECT calendar_token FROM account WHERE id = $1", accountId).Scan(&token)
+	return token.String, err
+}
+
+func (s postgresStore) SetCalendarToken(accountId, token string) error {
+	_, err := s.db.Exec("UPDATE account SET calendar_token = $2 WHERE id = $1", accountId, token)
+	return err
+}
+
+func (s postgresStore) CalendarAccount(token string) (string, error) {
+	var accountId string
+	err := s.db.QueryRow("SELECT id FROM account WHERE calendar_token = $1", token).Scan(&accountId)
+	if err == sql.ErrNoRows {
+		return "", errCalendarNotFound
+	}
+	return accountId, err
+}
+
+func (s postgresStore) CreateHabitToken(habitId, accountId string, t *habitToken) error {
+	query := `INSERT INTO habit_token (habit_id, token)
+                  SELECT id, $3 FROM habit WHERE id = $1 AND account_id = $2
+                  RETURNING id, created`
+	err := s.db.QueryRow(query, habitId, accountId, t.Token).Scan(&t.Id, &t.Created)
+	if err == sql.ErrNoRows {
+		return errHabitNotFound
+	}
+	return err
+}
+
+func (s postgresStore) RevokeHabitToken(id, accountId string) (string, error) {
+	var habitId string
+
+	query := `UPDATE habit_token t SET revoked = coalesce(t.revoked, current_timestamp)
+                  FROM habit h
+                  WHERE t.id = $1 AND t.habit_id = h.id AND h.account_id = $2
+                  RETURNING h.id`
+	err := s.db.QueryRow(query, id, accountId).Scan(&habitId)
+	if err == sql.ErrNoRows {
+		return "", errTokenNotFound
+	} else if err != nil {
+		return "", err
+	}
+
+	return habitId, nil
+}
+
+func (s postgresStore) HabitTokens(habitId, accountId string) ([]habitToken, error) {
+	query := `SELECT t.id, t.token, t.created, t.revoked
+                  FROM habit_token t JOIN habit h ON h.id = t.habit_id
+                  WHERE h.id = $1 AND h.account_id = $2
+                  ORDER BY t.created`
+
+	rows, err := s.db.Query(query, habitId, accountId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []habitToken
+	for rows.Next() {
+		var t habitToken
+		if err := rows.Scan(&t.Id, &t.Token, &t.Created, &t.Revoked); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+func (s postgresStore) HabitByToken(token string) (tokenId, habitId, accountId string, err error) {
+	query := `SELECT t.id, h.id, h.account_id
+                  FROM habit_token t JOIN habit h ON h.id = t.habit_id
+                  WHERE t.token = $1 AND t.revoked IS NULL AND h.retired IS NULL`
+	err = s.db.QueryRow(query, token).Scan(&tokenId, &habitId, &accountId)
+	if err == sql.ErrNoRows {
+		err = errTokenNotFound
+	}
+	return
+}
+
+func (s postgresStore) CreateHookProgressEntry(tokenId, requestId string, entry *progressEntry) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	// a duplicate would wait for the first request on the insert below
+	// otherwise, without a way to tell it from one that finished
+	var locked bool
+	query := "SELECT pg_try_advisory_xact_lock(hashtext($1), hashtext($2))"
+	if err := tx.QueryRow(query, tokenId, requestId).Scan(&locked); err != nil {
+		return false, err
+	}
+	if !locked {
+		return false, errRequestInProgress
+	}
+
+	query = `INSERT INTO habit_token_request (token_id, request_id) VALUES ($1, $2)
+                 ON CONFLICT DO NOTHING`
+	res, err := tx.Exec(query, tokenId, requestId)
+	if err != nil {
+		return false, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+
+	query = `INSERT INTO habit_progress (habit_id, delta, note, tags)
+                 VALUES ($1, $2, $3, string_to_array($4, ','))`
+	if _, err := tx.Exec(query, entry.HabitId, entry.Delta, entry.Note, strings.Join(entry.Tags, ",")); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+func (s postgresStore) CreateReminder(habitId, accountId string, r *reminder) (string, error) {
+	var id string
+
+	query := `INSERT INTO habit_reminder (habit_id, at, only_behind, channel, webhook_url)
+                  SELECT id, $3, $4, $5, $6 FROM habit WHERE id = $1 AND account_id = $2
+                  RETURNING id`
+	err := s.db.QueryRow(query, habitId, accountId, r.At, r.OnlyBehind, r.Channel, r.WebhookURL).Scan(&id)
+	if err == sql.ErrNoRows {
+		return "", errHabitNotFound
+	} else if err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+func (s postgresStore) DeleteReminder(id, accountId string) (string, error) {
+	var habitId string
+
+	query := `DELETE FROM habit_reminder r USING habit h
+                  WHERE r.id = $1 AND r.habit_id = h.id AND h.account_id = $2
+                  RETURNING h.id`
+	err := s.db.QueryRow(query, id, accountId).Scan(&habitId)
+	if err == sql.ErrNoRows {
+		return "", errReminderNotFound
+	} else if err != nil {
+		return "", err
+	}
+
+	return habitId, nil
+}
+
+// reminderSelect selects the columns read by scanReminder.
+const reminderSelect = `SELECT r.id, r.habit_id, to_char(r.at, 'HH24:MI'), r.only_behind, r.channel, r.webhook_url
+                  FROM habit_reminder r JOIN habit h ON h.id = r.habit_id`
+
+func scanReminder(row rowScanner, dest ...interface{}) (*reminder, error) {
+	var r reminder
+	dest = append([]interface{}{&r.Id, &r.HabitId, &r.At, &r.OnlyBehind, &r.Channel, &r.WebhookURL}, dest...)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func (s postgresStore) Reminders(habitId, accountId string) ([]reminder, error) {
+	query := reminderSelect + " WHERE h.id = $1 AND h.account_id = $2 ORDER BY r.at"
+
+	rows, err := s.db.Query(query, habitId, accountId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reminders []reminder
+	for rows.Next() {
+		r, err := scanReminder(rows)
+		if err != nil {
+			return nil, err
+		}
+		reminders = append(reminders, *r)
+	}
+	return reminders, rows.Err()
+}
+
+func (s postgresStore) ReminderDeliveries(habitId, accountId string) ([]reminderDelivery, error) {
+	query := `SELECT d.day, to_char(r.at, 'HH24:MI'), r.channel, d.status, d.error
+                  FROM reminder_delivery d
+                    JOIN habit_reminder r ON r.id = d.reminder_id
+                    JOIN habit h ON h.id = r.habit_id
+                  WHERE h.id = $1 AND h.account_id = $2
+                  ORDER BY d.day DESC, r.at DESC
+                  LIMIT ` + strconv.Itoa(maxReminderDeliveries)
+
+	rows, err := s.db.Query(query, habitId, accountId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []reminderDelivery
+	for rows.Next() {
+		var d reminderDelivery
+		if err := rows.Scan(&d.Day, &d.At, &d.Channel, &d.Status, &d.Error); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+func (s postgresStore) DueReminders(at string, day, stale time.Time) ([]dueReminder, error) {
+	query := `SELECT r.id, r.habit_id, to_char(r.at, 'HH24:MI'), r.only_behind, r.channel, r.webhook_url,
+                         a.id, a.email
+                  FROM habit_reminder r
+                    JOIN habit h ON h.id = r.habit_id
+                    JOIN account a ON a.id = h.account_id
+                  WHERE h.retired IS NULL
+                    AND r.at <= $1::time
+                    AND NOT EXISTS (SELECT 1 FROM reminder_delivery d
+                                    WHERE d.reminder_id = r.id AND d.day = $2
+                                      AND (d.status <> 'pending' OR d.created >= $3))
+                  ORDER BY a.id`
+
+	rows, err := s.db.Query(query, at, day, stale)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var due []dueReminder
+	for rows.Next() {
+		var d dueReminder
+		rem, err := scanReminder(rows, &d.AccountId, &d.Email)
+		if err != nil {
+			return nil, err
+		}
+		d.reminder = *rem
+		due = append(due, d)
+	}
+	return due, rows.Err()
+}
+
+func (s postgresStore) ClaimReminderDelivery(reminderId string, day, stale time.Time) (bool, error) {
+	query := `INSERT INTO reminder_delivery (reminder_id, day) VALUES ($1, $2)
+                  ON CONFLICT (reminder_id, day) DO UPDATE SET created = current_timestamp
+                  WHERE reminder_delivery.status = 'pending' AND reminder_delivery.created < $3`
+	res, err := s.db.Exec(query, reminderId, day, stale)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+func (s postgresStore) FinishReminderDelivery(reminderId string, day time.Time, status, msg string) error {
+	query := "UPDATE reminder_delivery SET status = $3, error = $4 WHERE reminder_id = $1 AND day = $2"
+	_, err := s.db.Exec(query, reminderId, day, status, msg)
+	return err
+}
+
+func (s postgresStore) CreateWebhook(accountId string, hook *webhook) error {
+	query := `INSERT INTO webhook (account_id, url, secret, events)
+                  VALUES ($1, $2, $3, string_to_array($4, ',')) RETURNING id`
+	return s.db.QueryRow(query, accountId, hook.URL, hook.Secret, strings.Join(hook.Events, ",")).Scan(&hook.Id)
+}
+
+func (s postgresStore) DeleteWebhook(id, accountId string) error {
+	res, err := s.db.Exec("DELETE FROM webhook WHERE id = $1 AND account_id = $2", id, accountId)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return errWebhookNotFound
+	}
+	return nil
+}
+
+func (s postgresStore) Webhooks(accountId string) ([]webhook, error) {
+	query := `SELECT id, url, array_to_string(events, ',') FROM webhook
+                  WHERE account_id = $1 ORDER BY created`
+
+	rows, err := s.db.Query(query, accountId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hooks []webhook
+	for rows.Next() {
+		var h webhook
+		var events string
+		if err := rows.Scan(&h.Id, &h.URL, &events); err != nil {
+			return nil, err
+		}
+		h.Events = strings.Split(events, ",")
+		hooks = append(hooks, h)
+	}
+	return hooks, rows.Err()
+}
+
+func (s postgresStore) WebhookAccounts(event string) ([]string, error) {
+	rows, err := s.db.Query("SELECT DISTINCT account_id FROM webhook WHERE $1 = ANY(events)", event)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var accounts []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, id)
+	}
+	return accounts, rows.Err()
+}
+
+func (s postgresStore) QueueWebhookEvent(accountId, event, key, payload string) error {
+	query := `INSERT INTO webhook_delivery (webhook_id, event, event_key, payload)
+                  SELECT id, $2, NULLIF($3, ''), $4 FROM webhook
+                  WHERE account_id = $1 AND $2 = ANY(events)
+                  ON CONFLICT (webhook_id, event_key) DO NOTHING`
+	_, err := s.db.Exec(query, accountId, event, key, payload)
+	return err
+}
+
+func (s postgresStore) WebhookDeliveries(accountId string) ([]webhookDelivery, error) {
+	query := `SELECT d.id, w.url, d.event, d.status, d.attempts, d.next_attempt,
+                         d.response_status, d.error, d.created, d.delivered
+                  FROM webhook_delivery d JOIN webhook w ON w.id = d.webhook_id
+                  WHERE w.account_id = $1
+                  ORDER BY d.created DESC
+                  LIMIT ` + strconv.Itoa(maxWebhookDeliveries)
+
+	rows, err := s.db.Query(query, accountId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []webhookDelivery
+	for rows.Next() {
+		var d webhookDelivery
+		err := rows.Scan(&d.Id, &d.URL, &d.Event, &d.Status, &d.Attempts, &d.NextAttempt,
+			&d.ResponseStatus, &d.Error, &d.Created, &d.Delivered)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// ClaimWebhookDeliveries moves the next attempt of the deliveries out of
+// the way of other servers while they are posted.
+func (s postgresStore) ClaimWebhookDeliveries(now, until time.Time) ([]pendingDelivery, error) {
+	query := `UPDATE webhook_delivery d SET next_attempt = $2
+                  FROM webhook w
+                  WHERE w.id = d.webhook_id
+                    AND d.id IN (SELECT id FROM webhook_delivery
+                                 WHERE status = 'pending' AND next_attempt <= $1
+                                 ORDER BY next_attempt
+                                 LIMIT ` + strconv.Itoa(maxClaimedDeliveries) + `
+                                 FOR UPDATE SKIP LOCKED)
+                  RETURNING d.id, w.url, w.secret, d.event, d.payload, d.attempts`
+
+	rows, err := s.db.Query(query, now, until)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var pending []pendingDelivery
+	for rows.Next() {
+		var d pendingDelivery
+		if err := rows.Scan(&d.id, &d.url, &d.secret, &d.event, &d.payload, &d.attempts); err != nil {
+			return nil, err
+		}
+		pending = append(pending, d)
+	}
+	return pending, rows.Err()
+}
+
+func (s postgresStore) FinishWebhookDelivery(d webhookDelivery) error {
+	query := `UPDATE webhook_delivery
+                  SET status = $2, attempts = $3, next_attempt = $4, response_status = $5, error = $6, delivered = $7
+                  WHERE id = $1`
+	_, err := s.db.Exec(query, d.Id, d.Status, d.Attempts, d.NextAttempt, d.ResponseStatus, d.Error, d.Delivered)
+	return err
+}
+
+// textArray formats a text[] literal, as COPY can't be given a []string.
+func textArray(values []string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(v) + `"`
+	}
+	return "{" + strings.Join(quoted, ",") + "}"
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/context"
+)
+
+// progressEntry is a single amount logged on a habit, optionally with a
+// note and tags describing it, e.g. which chapter was read.
+type progressEntry struct {
+	Id          string
+	HabitId     string
+	Description string
+	Delta       float64
+	Note        string
+	Tags        []string
+	Created     time.Time
+}
+
+// maxProgressEntries caps the entries shown in a habit's history and in
+// search results.
+const maxProgressEntries = 100
+
+// readProgressEntry reads the amount, note and tags of a progress update
+// from either a form or a JSON body like {"amount": 2.5, "note": "...",
+// "tags": ["..."]}. The amount defaults to 1.
+func readProgressEntry(r *http.Request) (*progressEntry, error) {
+	var form url.Values
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		var body struct {
+			Amount *float64 `json:"amount"`
+			Note   string   `json:"note"`
+			Tags   []string `json:"tags"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			return nil, badRequest(err)
+		}
+		form = url.Values{"note": {body.Note}, "tags": body.Tags}
+		if body.Amount != nil {
+			form.Set("amount", strconv.FormatFloat(*body.Amount, 'f', -1, 64))
+		}
+	} else {
+		if err := r.ParseForm(); err != nil {
+			return nil, badRequest(err)
+		}
+		form = r.Form
+	}
+
+	entry, errs := validateProgressForm(form)
+	if errs != nil {
+		return nil, errs
+	}
+	return entry, nil
+}
+
+// progressSearchHandler lists the progress entries of the account whose
+// note contains the q parameter and which have the tag parameter, newest
+// first.
+func progressSearchHandler(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "GET" {
+		return methodNotAllowed()
+	}
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	tag := normalizeTag(r.URL.Query().Get("tag"))
+
+	accountId := context.Get(r, "accountId")
+	entries, err := storage.SearchProgressEntries(accountId.(string), q, tag)
+	if err != nil {
+		return err
+	}
+
+	data := struct {
+		Query   string
+		Tag     string
+		Entries []progressEntry
+	}{
+		q,
+		tag,
+		entries,
+	}
+
+	return renderResponse(w, r, data, "progress.html")
+}
+
+// maxBatchItems caps the progress logged by one batch request.
+const maxBatchItems = 100
+
+// batchItem is progress logged through habitBatchHandler. Date is the day
+// it was done, today when it's empty.
+type batchItem struct {
+	HabitId string   `json:"habit_id"`
+	Amount  *float64 `json:"amount"`
+	Date    string   `json:"date"`
+	Note    string   `json:"note"`
+	Tags    []string `json:"tags"`
+}
+
+// habitBatchHandler logs progress on several habits at once. It takes a
+// JSON body like {"items": [{"habit_id": "...", "amount": 2, "date":
+// "2017-03-01"}]} and logs all of it or nothing. It returns the habits of
+// the items and the totals of the week like habitHandler.
+func habitBatchHandler(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "POST" {
+		return methodNotAllowed()
+	}
+	var body struct {
+		Items []batchItem `json:"items"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return badRequest(err)
+	}
+
+	entries, errs := validateBatchItems(body.Items, time.Now())
+	if errs != nil {
+		return errs
+	}
+
+	accountId := context.Get(r, "accountId").(string)
+	habits, all, err := logProgressBatch(accountId, entries)
+	if err == errHabitNotFound {
+		return notFound(err)
+	} else if err != nil {
+		return err
+	}
+
+	done, todo := totalPointsThisWeek(all)
+	return renderJSON(w, struct {
+		Habits          []habit
+		ThisWeekDone    float64
+		ThisWeekTodo    float64
+		ThisWeekPctDone int
+	}{
+		habits,
+		done,
+		todo,
+		calcPercentage(done, todo),
+	})
+}
+
+// validateBatchItems returns the progress entries of the items. Entries of
+// an earlier day are logged at noon of that day.
+func validateBatchItems(items []batchItem, now time.Time) ([]progressEntry, validationErrors) {
+	if len(items) == 0 {
+		return nil, validationErrors{"items": "Can't be empty"}
+	} else if len(items) > maxBatchItems {
+		return nil, validationErrors{"items": fmt.Sprintf("Can't be more than %d", maxBatchItems)}
+	}
+
+	today := now.Format(dateFormat)
+	entries := make([]progressEntry, len(items))
+	for i, item := range items {
+		form := url.Values{"note": {item.Note}, "tags": item.Tags}
+		if item.Amount != nil {
+			form.Set("amount", strconv.FormatFloat(*item.Amount, 'f', -1, 64))
+		}
+		entry, errs := validateProgressForm(form)
+		if errs == nil {
+			errs = validationErrors{}
+			entry.HabitId = item.HabitId
+			entry.Created = now
+		}
+		if item.HabitId == "" {
+			errs["habit_id"] = "Can't be empty"
+		} else if !validUUID(item.HabitId) {
+			errs["habit_id"] = "Must be the ID of a habit"
+		}
+		if item.Date != "" && item.Date != today {
+			d, err := time.ParseInLocation(dateFormat, item.Date, now.Location())
+			if err != nil {
+				errs["date"] = "Must be a date like 2017-03-01"
+			} else if d.After(now) {
+				errs["date"] = "Can't be in the future"
+			} else if d.Before(earliestStart) {
+				errs["date"] = "Can't be before 2000"
+			} else if entry != nil {
+				entry.Created = d.Add(12 * time.Hour)
+			}
+		}
+		if len(errs) > 0 {
+			return nil, validationErrors{"items": fmt.Sprintf("Item %d: %s", i+1, errs.join())}
+		}
+		entries[i] = *entry
+	}
+	return entries, nil
+}
+
+// logProgressBatch logs the entries and returns their habits, in the order
+// of the entries, and all the habits of the account after logging them.
+func logProgressBatch(accountId string, entries []progressEntry) ([]habit, []habit, error) {
+	before, err := getHabits(accountId)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := storage.CreateProgressEntries(accountId, entries); err != nil {
+		return nil, nil, err
+	}
+	after, err := getHabits(accountId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	beforeById := make(map[string]*habit)
+	for i := range before {
+		beforeById[before[i].Id] = &before[i]
+	}
+	afterById := make(map[string]*habit)
+	for i := range after {
+		afterById[after[i].Id] = &after[i]
+	}
+
+	var habits []habit
+	for i := range entries {
+		id := entries[i].HabitId
+		b, a := beforeById[id], afterById[id]
+		if b == nil || a == nil {
+			continue
+		}
+		emitProgress(accountId, b, a, &entries[i])
+		if !containsHabit(habits, id) {
+			habits = append(habits, *a)
+		}
+	}
+	return habits, after, nil
+}
+
+func containsHabit(habits []habit, id string) bool {
+	for _, h := range habits {
+		if h.Id == id {
+			return true
+		}
+	}
+	return false
+}
+
+// habitIds returns the IDs of the habits of the entries, each once.
+func habitIds(entries []progressEntry) []string {
+	var ids []string
+	seen := make(map[string]bool)
+	for _, e := range entries {
+		if !seen[e.HabitId] {
+			seen[e.HabitId] = true
+			ids = append(ids, e.HabitId)
+		}
+	}
+	return ids
+}
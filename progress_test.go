@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/context"
+)
+
+func TestReadProgressEntryJSON(t *testing.T) {
+	body := `{"amount": 1.5, "note": "easy run", "tags": ["Park"]}`
+	req, err := http.NewRequest("POST", "https://localhost/habits/"+uuidForTests, strings.NewReader(body))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	entry, err := readProgressEntry(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Delta != 1.5 {
+		t.Errorf("Expected 1.5, got %v", entry.Delta)
+	}
+	if entry.Note != "easy run" {
+		t.Errorf("Expected easy run, got %v", entry.Note)
+	}
+	if len(entry.Tags) != 1 || entry.Tags[0] != "park" {
+		t.Errorf("Expected [park], got %v", entry.Tags)
+	}
+}
+
+func TestReadProgressEntryBadJSON(t *testing.T) {
+	req, err := http.NewRequest("POST", "https://localhost/habits/"+uuidForTests, strings.NewReader("{"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	_, err = readProgressEntry(req)
+	if e, ok := err.(*httpError); !ok || e.Code != http.StatusBadRequest {
+		t.Errorf("Expected bad request, got %v", err)
+	}
+}
+
+func TestEscapeLike(t *testing.T) {
+	if s := escapeLike(`100%_\`); s != `100\%\_\\` {
+		t.Errorf("Expected 100\\%%\\_\\\\, got %v", s)
+	}
+}
+
+func TestSearchProgressEntries(t *testing.T) {
+	requireDatabase(t)
+	account, _ := CreateAccount(emailForTests, passwordForTests)
+	defer truncateDatabase()
+
+	id, _ := createHabit(newHabit("Read", 10, PeriodWeek, time.Now()), account.Id)
+	updateHabitProgress(*id, account.Id, &progressEntry{Delta: 1, Note: "Chapter 3", Tags: []string{"fiction"}})
+	updateHabitProgress(*id, account.Id, &progressEntry{Delta: 2, Note: "Chapter 4"})
+	updateHabitProgress(*id, account.Id, &progressEntry{Delta: 1, Note: "Foreword", Tags: []string{"fiction", "slow"}})
+
+	entries, err := storage.SearchProgressEntries(account.Id, "chapter", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("Expected 2 entries, got %v", len(entries))
+	}
+
+	entries, err = storage.SearchProgressEntries(account.Id, "", "fiction")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %v", len(entries))
+	}
+	if entries[0].Note != "Foreword" || len(entries[0].Tags) != 2 {
+		t.Errorf("Expected newest entry with 2 tags first, got %v", entries[0])
+	}
+	if entries[0].Description != "Read" {
+		t.Errorf("Expected Read, got %v", entries[0].Description)
+	}
+}
+
+func TestHabitUpdateHandlerJSON(t *testing.T) {
+	requireDatabase(t)
+	account, _ := CreateAccount(emailForTests, passwordForTests)
+	id, _ := createHabit(newHabit("Run", 10, PeriodWeek, time.Now()), account.Id)
+	defer truncateDatabase()
+
+	body := `{"amount": 5, "note": "windy", "tags": ["park"]}`
+	req, err := http.NewRequest("POST", "https://localhost/habits/"+*id, strings.NewReader(body))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	context.Set(req, "accountId", account.Id)
+	defer context.Clear(req)
+
+	w := httptest.NewRecorder()
+	appHandler(habitUpdateHandler).ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %v", w.Code)
+	}
+	var h habit
+	if err := json.Unmarshal(w.Body.Bytes(), &h); err != nil {
+		t.Fatal(err)
+	}
+	if h.Done != 5 {
+		t.Errorf("Expected 5, got %v", h.Done)
+	}
+
+	entries, err := storage.ProgressEntries(*id, account.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Note != "windy" {
+		t.Errorf("Expected the windy entry, got %v", entries)
+	}
+}
+
+func TestProgressSearchHandler(t *testing.T) {
+	requireDatabase(t)
+	account, _ := CreateAccount(emailForTests, passwordForTests)
+	defer truncateDatabase()
+
+	req, err := http.NewRequest("GET", "https://localhost/progress?q=chapter&tag=fiction", nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	context.Set(req, "accountId", account.Id)
+	defer context.Clear(req)
+
+	w := httptest.NewRecorder()
+	appHandler(progressSearchHandler).ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected %v, got %v", http.StatusOK, w.Code)
+	}
+}
+
+func postBatch(accountId, body string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("POST", "https://localhost/habits/batch", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	context.Set(req, "accountId", accountId)
+	defer context.Clear(req)
+
+	w := httptest.NewRecorder()
+	appHandler(habitBatchHandler).ServeHTTP(w, req)
+	return w
+}
+
+func testHabitBatchHandler(t *testing.T) {
+	account, _ := CreateAccount(emailForTests, passwordForTests)
+	other, _ := CreateAccount("other@habitcat.net", passwordForTests)
+	start := PeriodWeek.start(time.Now()).AddDate(0, 0, -7)
+	run, _ := createHabit(newHabit("Run", 10, PeriodWeek, start), account.Id)
+	read, _ := createHabit(newHabit("Read", 4, PeriodWeek, start), account.Id)
+	otherId, _ := createHabit(newHabit("Other", 1, PeriodWeek, start), other.Id)
+	lastWeek := start.Format(dateFormat)
+
+	body := `{"items": [
+		{"habit_id": "` + *run + `", "amount": 5, "note": "windy"},
+		{"habit_id": "` + *read + `"},
+		{"habit_id": "` + *run + `", "amount": 2, "date": "` + lastWeek + `"}
+	]}`
+	w := postBatch(account.Id, body)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %v: %s", w.Code, w.Body)
+	}
+	var resp struct {
+		Habits       []habit
+		ThisWeekDone float64
+		ThisWeekTodo float64
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Habits) != 2 || resp.Habits[0].Id != *run || resp.Habits[0].Done != 5 || resp.Habits[1].Done != 1 {
+		t.Errorf("Expected Run with 5 done and Read with 1, got %+v", resp.Habits)
+	}
+	if resp.ThisWeekDone != 6 || resp.ThisWeekTodo != 14 {
+		t.Errorf("Expected 6 / 14 this week, got %v / %v", resp.ThisWeekDone, resp.ThisWeekTodo)
+	}
+	history, _ := storage.HabitHistory(*run, account.Id)
+	if len(history) != 2 || history[0].Done != 2 {
+		t.Errorf("Expected 2 done last week, got %+v", history)
+	}
+
+	// nothing is logged when one of the habits isn't the account's
+	body = `{"items": [{"habit_id": "` + *read + `"}, {"habit_id": "` + *otherId + `"}]}`
+	if w := postBatch(account.Id, body); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404, got %v", w.Code)
+	}
+	if entries, _ := storage.ProgressEntries(*read, account.Id); len(entries) != 1 {
+		t.Errorf("Expected Read to still have 1 entry, got %v", len(entries))
+	}
+
+	// IDs that can't be a habit's don't reach the database
+	body = `{"items": [{"habit_id": "` + *read + `"}, {"habit_id": "read"}]}`
+	if w := postBatch(account.Id, body); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400, got %v", w.Code)
+	}
+}
+
+func TestHabitBatchHandlerMemory(t *testing.T) {
+	_, restore := withMemoryStore()
+	defer restore()
+	testHabitBatchHandler(t)
+}
+
+func TestHabitBatchHandler(t *testing.T) {
+	requireDatabase(t)
+	defer truncateDatabase()
+	testHabitBatchHandler(t)
+}
+
+func TestValidateBatchItems(t *testing.T) {
+	now := time.Now()
+	tomorrow := now.AddDate(0, 0, 1).Format(dateFormat)
+	tests := []string{
+		`[]`,
+		`[{"amount": 1}]`,
+		`[{"habit_id": "h", "amount": 1}]`,
+		`[{"habit_id": "` + uuidForTests + `", "date": "yesterday"}]`,
+		`[{"habit_id": "` + uuidForTests + `", "date": "` + tomorrow + `"}]`,
+		`[{"habit_id": "` + uuidForTests + `", "date": "1999-03-01"}]`,
+		`[{"habit_id": "` + uuidForTests + `", "amount": 1}, {"habit_id": "` + uuidForTests + `", "note": "` + strings.Repeat("a", maxNoteLength+1) + `"}]`,
+	}
+	for _, test := range tests {
+		var items []batchItem
+		if err := json.Unmarshal([]byte(test), &items); err != nil {
+			t.Fatal(err)
+		}
+		if _, errs := validateBatchItems(items, now); errs["items"] == "" {
+			t.Errorf("Expected an error for %v, got %v", test, errs)
+		}
+	}
+}
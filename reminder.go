@@ -0,0 +1,227 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/context"
+)
+
+// reminder notifies the owner of a habit every day at a time of day,
+// optionally only when the habit is behind its pace.
+type reminder struct {
+	Id         string
+	HabitId    string
+	At         string
+	OnlyBehind bool
+	Channel    string
+	WebhookURL string
+}
+
+// reminderDelivery records what happened to a reminder on a day.
+type reminderDelivery struct {
+	Day     time.Time
+	At      string
+	Channel string
+	Status  string
+	Error   string
+}
+
+var errReminderNotFound = errors.New("reminder not found")
+
+// timeOfDayFormat is the format of the time of day of reminders.
+const timeOfDayFormat = "15:04"
+
+// reminderLease is how long a pending delivery is left to the server that
+// claimed it. After that the server is taken to have died while delivering
+// and the reminder is delivered again.
+const reminderLease = 10 * time.Minute
+
+// maxReminderDeliveries is how many of the latest deliveries of the
+// reminders of a habit are shown.
+const maxReminderDeliveries = 20
+
+// behind reports whether the habit is behind the pace needed to complete
+// its current period, e.g. 1 of 3 done with half of the week gone.
+// Scheduled habits are behind when an occurrence of the period is not done
+// yet. Limits can't be behind.
+func (h habit) behind(now time.Time) bool {
+	if h.Upcoming || h.Paused || h.Kind == KindLimit {
+		return false
+	}
+	if h.scheduled() {
+		return h.DueToday || len(h.Missed) > 0
+	}
+	if h.Done >= h.Todo {
+		return false
+	}
+
+	// the period starts are dates in UTC, compare with the local clock
+	clock := time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), now.Minute(), 0, 0, time.UTC)
+	start := h.Period.start(now)
+	end := h.Period.next(start)
+	if h.Start.After(start) {
+		start = h.Start
+	}
+	elapsed := clock.Sub(start).Hours() / end.Sub(start).Hours()
+	return h.Done < h.Todo*elapsed
+}
+
+func habitRemindHandler(w http.ResponseWriter, r *http.Request) error {
+	uuid := r.URL.Path[len("/habits/remind/"):]
+	if len(uuid) == 0 {
+		return badRequest(errors.New("habit id missing"))
+	}
+
+	if r.Method == "GET" {
+		defaults := url.Values{
+			"at":          {"18:00"},
+			"only_behind": {"on"},
+			"channel":     {"email"},
+		}
+		return renderTemplate(w, "habits_remind.html", formData{Values: defaults})
+	} else if r.Method != "POST" {
+		return methodNotAllowed()
+	}
+
+	if err := r.ParseForm(); err != nil {
+		return badRequest(err)
+	}
+	rem, errs := validateReminderForm(r.PostForm)
+	if errs != nil {
+		return renderInvalidForm(w, r, "habits_remind.html", errs)
+	}
+
+	accountId := context.Get(r, "accountId")
+	if _, err := storage.CreateReminder(uuid, accountId.(string), rem); err == errHabitNotFound {
+		return notFound(err)
+	} else if err != nil {
+		return err
+	}
+
+	http.Redirect(w, r, "/habits/history/"+uuid, http.StatusFound)
+	return nil
+}
+
+func habitUnremindHandler(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "POST" {
+		return methodNotAllowed()
+	}
+	uuid := r.URL.Path[len("/habits/unremind/"):]
+	if len(uuid) == 0 {
+		return badRequest(errors.New("reminder id missing"))
+	}
+
+	accountId := context.Get(r, "accountId")
+	habitId, err := storage.DeleteReminder(uuid, accountId.(string))
+	if err == errReminderNotFound {
+		return notFound(err)
+	} else if err != nil {
+		return err
+	}
+
+	http.Redirect(w, r, "/habits/history/"+habitId, http.StatusFound)
+	return nil
+}
+
+func validateReminderForm(form url.Values) (*reminder, validationErrors) {
+	errs := validationErrors{}
+
+	at, err := time.Parse(timeOfDayFormat, strings.TrimSpace(form.Get("at")))
+	if err != nil {
+		errs["at"] = "Must be a time of day like 18:30"
+	}
+
+	rem := &reminder{
+		At:         at.Format(timeOfDayFormat),
+		OnlyBehind: form.Get("only_behind") != "",
+		Channel:    form.Get("channel"),
+	}
+	switch rem.Channel {
+	case "email":
+	case "webhook":
+		var msg string
+		if rem.WebhookURL, msg = parseWebhookURL(form.Get("webhook_url")); msg != "" {
+			errs["webhook_url"] = msg
+		}
+	default:
+		errs["channel"] = "Reminders are sent by email or to a webhook"
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return rem, nil
+}
+
+// dueReminder is a reminder whose time of day has come, with the account
+// of its habit.
+type dueReminder struct {
+	reminder
+	AccountId string
+	Email     string
+}
+
+// sendReminders delivers the reminders whose time of day has passed today
+// and that were not delivered today yet. Reminders that only apply when
+// their habit is behind are recorded as skipped otherwise.
+func sendReminders(now time.Time) error {
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	stale := now.Add(-reminderLease)
+	due, err := storage.DueReminders(now.Format(timeOfDayFormat), day, stale)
+	if err != nil {
+		return err
+	}
+
+	habits := make(map[string]map[string]habit)
+	for _, r := range due {
+		if habits[r.AccountId] == nil {
+			hs, err := getHabits(r.AccountId)
+			if err != nil {
+				log.Printf("reminders for account=%v: %v", r.AccountId, err)
+				continue
+			}
+			habits[r.AccountId] = make(map[string]habit)
+			for _, h := range hs {
+				habits[r.AccountId][h.Id] = h
+			}
+		}
+
+		claimed, err := storage.ClaimReminderDelivery(r.Id, day, stale)
+		if err != nil {
+			return err
+		} else if !claimed {
+			continue
+		}
+
+		status, msg := "sent", ""
+		h, ok := habits[r.AccountId][r.HabitId]
+		if !ok || h.Upcoming || h.Paused || (r.OnlyBehind && !h.behind(now)) {
+			status = "skipped"
+		} else if err := channels[r.Channel].deliver(r.notification(h)); err != nil {
+			status, msg = "failed", err.Error()
+			log.Printf("reminder %v account=%v: %v", r.Id, r.AccountId, err)
+		}
+		if err := storage.FinishReminderDelivery(r.Id, day, status, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r dueReminder) notification(h habit) notification {
+	return notification{
+		To:          r.Email,
+		URL:         r.WebhookURL,
+		HabitId:     h.Id,
+		Description: h.Description,
+		Done:        h.Done,
+		Todo:        h.Todo,
+		Text:        h.Description + ": " + h.DoneText + " / " + h.TodoText + " " + h.ScheduleDescription() + ".",
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/context"
+)
+
+func TestHabitBehind(t *testing.T) {
+	// Friday noon, 4.5 days of the week are gone
+	friday := time.Date(2016, time.January, 15, 12, 0, 0, 0, time.UTC)
+	monday := date(2016, time.January, 11)
+
+	tests := []struct {
+		h        habit
+		expected bool
+	}{
+		{habit{Todo: 3, Done: 0, Period: PeriodWeek, Start: monday}, true},
+		{habit{Todo: 3, Done: 2, Period: PeriodWeek, Start: monday}, false},
+		{habit{Todo: 3, Done: 3, Period: PeriodWeek, Start: monday}, false},
+		{habit{Todo: 3, Done: 1, Period: PeriodWeek, Start: date(2016, time.January, 15)}, false},
+		{habit{Todo: 1, Done: 0, Period: PeriodDay, Start: monday}, true},
+		{habit{Todo: 3, Done: 0, Period: PeriodWeek, Start: monday, Paused: true}, false},
+		{habit{Todo: 3, Done: 5, Period: PeriodWeek, Start: monday, Kind: KindLimit}, false},
+		{habit{Todo: 2, Done: 2, Period: PeriodWeek, Start: monday, Schedule: ScheduleInterval, DueToday: true}, true},
+	}
+	for i, test := range tests {
+		if actual := test.h.behind(friday); actual != test.expected {
+			t.Errorf("Expected %v for test %v, got %v", test.expected, i, actual)
+		}
+	}
+}
+
+func TestValidateReminderForm(t *testing.T) {
+	rem, errs := validateReminderForm(url.Values{"at": {"7:05"}, "channel": {"email"}, "only_behind": {"on"}})
+	if errs != nil {
+		t.Fatalf("Expected nil, got %v", errs)
+	}
+	if rem.At != "07:05" || !rem.OnlyBehind {
+		t.Errorf("Expected 07:05 when behind, got %v", rem)
+	}
+
+	tests := []struct {
+		form  url.Values
+		field string
+	}{
+		{url.Values{"at": {"25:00"}, "channel": {"email"}}, "at"},
+		{url.Values{"at": {"18:00"}, "channel": {"sms"}}, "channel"},
+		{url.Values{"at": {"18:00"}, "channel": {"webhook"}, "webhook_url": {"ftp://example.com"}}, "webhook_url"},
+		{url.Values{"at": {"18:00"}, "channel": {"webhook"}, "webhook_url": {"http://localhost:8080/hook"}}, "webhook_url"},
+		{url.Values{"at": {"18:00"}, "channel": {"webhook"}, "webhook_url": {"http://127.0.0.1/hook"}}, "webhook_url"},
+		{url.Values{"at": {"18:00"}, "channel": {"webhook"}, "webhook_url": {"http://[::1]/hook"}}, "webhook_url"},
+		{url.Values{"at": {"18:00"}, "channel": {"webhook"}, "webhook_url": {"http://10.0.0.8/hook"}}, "webhook_url"},
+		{url.Values{"at": {"18:00"}, "channel": {"webhook"}, "webhook_url": {"http://169.254.169.254/latest"}}, "webhook_url"},
+		{url.Values{"at": {"18:00"}, "channel": {"webhook"}, "webhook_url": {"http://0.0.0.0/hook"}}, "webhook_url"},
+	}
+	for _, test := range tests {
+		rem, errs := validateReminderForm(test.form)
+		if rem != nil {
+			t.Errorf("Expected nil, got %v", rem)
+		}
+		if len(errs) != 1 || errs[test.field] == "" {
+			t.Errorf("Expected error for %v, got %v", test.field, errs)
+		}
+	}
+}
+
+func TestWebhookChannel(t *testing.T) {
+	var got notification
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer server.Close()
+
+	c := webhookChannel{client: http.DefaultClient}
+	n := notification{URL: server.URL, HabitId: "id1", Description: "Run", Done: 1, Todo: 3, Text: "Run: 1 / 3"}
+	if err := c.deliver(n); err != nil {
+		t.Fatal(err)
+	}
+	if got.HabitId != "id1" || got.Todo != 3 || got.Text != "Run: 1 / 3" {
+		t.Errorf("Unexpected payload %v", got)
+	}
+
+	n.URL = server.URL + "/missing"
+	server.Config.Handler = http.NotFoundHandler()
+	if err := c.deliver(n); err == nil {
+		t.Error("Expected error for 404")
+	}
+}
+
+func TestWebhookClientPrivateAddress(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Expected no request to a loopback address")
+	}))
+	defer server.Close()
+
+	// a name resolving to a private address is refused when dialing
+	named := strings.Replace(server.URL, "127.0.0.1", "localhost", 1)
+	c := webhookChannel{client: newWebhookClient()}
+	for _, u := range []string{server.URL, named} {
+		if err := c.deliver(notification{URL: u}); err == nil || !strings.Contains(err.Error(), errPrivateAddress.Error()) {
+			t.Errorf("Expected %v for %v, got %v", errPrivateAddress, u, err)
+		}
+	}
+}
+
+func TestSendReminders(t *testing.T) {
+	defer withTestStore()()
+	account, _ := CreateAccount(emailForTests, passwordForTests)
+	defer truncateDatabase()
+
+	mails := &testMailer{}
+	sender = mails
+
+	now := time.Now()
+	behindId, _ := createHabit(newHabit("Run", 100, PeriodDay, now), account.Id)
+	doneId, _ := createHabit(newHabit("Read", 1, PeriodDay, now), account.Id)
+	createHabitProgress(*doneId, 1, nil)
+	at := now.Add(-time.Minute).Format(timeOfDayFormat)
+	storage.CreateReminder(*behindId, account.Id, &reminder{At: at, OnlyBehind: true, Channel: "email"})
+	storage.CreateReminder(*doneId, account.Id, &reminder{At: at, OnlyBehind: true, Channel: "email"})
+
+	if err := sendReminders(now); err != nil {
+		t.Fatal(err)
+	}
+	if len(mails.sent) != 1 || mails.sent[0].Subject != "Reminder: Run" {
+		t.Fatalf("Expected a reminder for Run, got %v", mails.sent)
+	}
+
+	// reminders are delivered once a day
+	if err := sendReminders(now); err != nil {
+		t.Fatal(err)
+	}
+	if len(mails.sent) != 1 {
+		t.Errorf("Expected no more mails, got %v", len(mails.sent))
+	}
+
+	deliveries, err := storage.ReminderDeliveries(*doneId, account.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 || deliveries[0].Status != "skipped" {
+		t.Errorf("Expected a skipped delivery, got %v", deliveries)
+	}
+
+	// a delivery left pending by a server that died is delivered again
+	abandonReminderDeliveries(t)
+	if err := sendReminders(now); err != nil {
+		t.Fatal(err)
+	}
+	if len(mails.sent) != 2 {
+		t.Errorf("Expected the pending reminder to be sent again, got %v", mails.sent)
+	}
+}
+
+// abandonReminderDeliveries makes the deliveries look like they were
+// claimed an hour ago by a server that died before sending them.
+func abandonReminderDeliveries(t *testing.T) {
+	if s, ok := storage.(*memoryStore); ok {
+		for i := range s.deliveries {
+			s.deliveries[i].status = "pending"
+			s.deliveries[i].created = s.deliveries[i].created.Add(-time.Hour)
+		}
+		return
+	}
+	query := "UPDATE reminder_delivery SET status = 'pending', created = created - interval '1 hour'"
+	if testDialect == sqlite {
+		query = "UPDATE reminder_delivery SET status = 'pending', created = strftime('%Y-%m-%d %H:%M:%f', created, '-1 hour')"
+	}
+	if _, err := testDB.Exec(query); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHabitRemindHandler(t *testing.T) {
+	defer withTestStore()()
+	account, _ := CreateAccount(emailForTests, passwordForTests)
+	id, _ := createHabit(newHabit("Habit", 2, PeriodWeek, time.Now()), account.Id)
+	defer truncateDatabase()
+
+	body := "at=18:00&channel=webhook&webhook_url=https://example.com/hook"
+	req, err := http.NewRequest("POST", "https://localhost/habits/remind/"+*id, strings.NewReader(body))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	context.Set(req, "accountId", account.Id)
+	defer context.Clear(req)
+
+	w := httptest.NewRecorder()
+	appHandler(habitRemindHandler).ServeHTTP(w, req)
+
+	if w.Code != http.StatusFound {
+		t.Errorf("Expected %v, got %v", http.StatusFound, w.Code)
+	}
+	reminders, err := storage.Reminders(*id, account.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reminders) != 1 || reminders[0].At != "18:00" || reminders[0].WebhookURL != "https://example.com/hook" {
+		t.Errorf("Unexpected reminders %v", reminders)
+	}
+}
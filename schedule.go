@@ -0,0 +1,120 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Schedule string
+
+const (
+	// SchedulePeriod habits are done when enough points are collected
+	// during the period, no matter on which days.
+	SchedulePeriod Schedule = "period"
+	// ScheduleWeekdays habits are due on fixed days of the week.
+	ScheduleWeekdays Schedule = "weekdays"
+	// ScheduleInterval habits are due every EveryDays days after the start.
+	ScheduleInterval Schedule = "interval"
+)
+
+// weekdaySet is a bit mask of time.Weekday values.
+type weekdaySet int
+
+func (s weekdaySet) has(d time.Weekday) bool {
+	return s&(1<<uint(d)) != 0
+}
+
+func (s weekdaySet) add(d time.Weekday) weekdaySet {
+	return s | 1<<uint(d)
+}
+
+// String lists the days starting from Monday, e.g. "Mon, Wed, Fri".
+func (s weekdaySet) String() string {
+	var names []string
+	for i := 1; i <= 7; i++ {
+		d := time.Weekday(i % 7)
+		if s.has(d) {
+			names = append(names, d.String()[:3])
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+func (h habit) scheduled() bool {
+	return h.Schedule == ScheduleWeekdays || h.Schedule == ScheduleInterval
+}
+
+// dueOn reports whether a scheduled habit has an occurrence on the given day.
+func (h habit) dueOn(day time.Time) bool {
+	if day.Before(h.Start) {
+		return false
+	}
+	switch h.Schedule {
+	case ScheduleWeekdays:
+		return h.Weekdays.has(day.Weekday())
+	case ScheduleInterval:
+		if h.EveryDays <= 0 {
+			return false
+		}
+		days := int(day.Sub(h.Start).Hours() / 24)
+		return days%h.EveryDays == 0
+	}
+	return false
+}
+
+// ScheduleDescription describes how often the habit is due.
+func (h habit) ScheduleDescription() string {
+	switch h.Schedule {
+	case ScheduleWeekdays:
+		return "on " + h.Weekdays.String()
+	case ScheduleInterval:
+		if h.EveryDays == 1 {
+			return "every day"
+		}
+		return "every " + strconv.Itoa(h.EveryDays) + " days"
+	}
+	return "per " + string(h.Period)
+}
+
+// applySchedule scores the habit per scheduled occurrence instead of per
+// period total: every period of the history counts its occurrences and how
+// many of them got enough progress on their day. Occurrences of the current
+// period that were not done before today are reported as missed.
+func (h *habit) applySchedule(history []periodProgress, daily map[string]float64, today time.Time) {
+	today = time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, time.UTC)
+	h.DueToday = false
+	h.Missed = nil
+
+	for i := range history {
+		p := &history[i]
+		p.Done, p.Todo = 0, 0
+		current := i == len(history)-1
+
+		end := h.Period.next(p.Start)
+		for day := p.Start; day.Before(end); day = day.AddDate(0, 0, 1) {
+			if !h.dueOn(day) {
+				continue
+			}
+			p.Todo++
+			done := daily[day.Format(dateFormat)] >= h.PerOccurrence
+			if done {
+				p.Done++
+			}
+			if !current || done {
+				continue
+			}
+			if day.Equal(today) {
+				h.DueToday = true
+			} else if day.Before(today) {
+				h.Missed = append(h.Missed, day)
+			}
+		}
+		p.PctDone = calcPercentage(p.Done, p.Todo)
+	}
+
+	if len(history) > 0 {
+		last := history[len(history)-1]
+		h.Done, h.Todo, h.PctDone = last.Done, last.Todo, last.PctDone
+	}
+}
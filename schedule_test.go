@@ -0,0 +1,163 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestWeekdaySetString(t *testing.T) {
+	var s weekdaySet
+	s = s.add(time.Sunday).add(time.Monday).add(time.Wednesday)
+	if s.String() != "Mon, Wed, Sun" {
+		t.Errorf("Expected \"Mon, Wed, Sun\", got %v", s.String())
+	}
+	if s.has(time.Tuesday) {
+		t.Errorf("Expected Tuesday not to be in the set")
+	}
+}
+
+func TestHabitDueOn(t *testing.T) {
+	start := date(2016, time.January, 11) // Monday
+	gym := habit{Schedule: ScheduleWeekdays, Weekdays: weekdaySet(0).add(time.Monday).add(time.Friday), Start: start}
+	plants := habit{Schedule: ScheduleInterval, EveryDays: 3, Start: start}
+
+	tests := []struct {
+		h        habit
+		day      time.Time
+		expected bool
+	}{
+		{gym, date(2016, time.January, 4), false}, // before start
+		{gym, date(2016, time.January, 11), true},
+		{gym, date(2016, time.January, 12), false},
+		{gym, date(2016, time.January, 15), true},
+		{plants, date(2016, time.January, 11), true},
+		{plants, date(2016, time.January, 13), false},
+		{plants, date(2016, time.January, 14), true},
+	}
+	for _, test := range tests {
+		if actual := test.h.dueOn(test.day); actual != test.expected {
+			t.Errorf("Expected %v for %v on %v, got %v", test.expected, test.h.ScheduleDescription(), test.day, actual)
+		}
+	}
+}
+
+func TestApplySchedule(t *testing.T) {
+	var weekdays weekdaySet
+	weekdays = weekdays.add(time.Monday).add(time.Wednesday).add(time.Friday)
+	h := habit{
+		Period:        PeriodWeek,
+		Start:         date(2016, time.January, 4),
+		Schedule:      ScheduleWeekdays,
+		Weekdays:      weekdays,
+		PerOccurrence: 1,
+	}
+	history := []periodProgress{
+		{Start: date(2016, time.January, 4)},
+		{Start: date(2016, time.January, 11)},
+	}
+	daily := map[string]float64{
+		"2016-01-04": 1,
+		"2016-01-06": 1,
+		"2016-01-08": 2,
+		"2016-01-11": 1,
+		"2016-01-12": 1, // not scheduled
+	}
+	wednesday := time.Date(2016, time.January, 13, 18, 0, 0, 0, time.Local)
+
+	h.applySchedule(history, daily, wednesday)
+
+	if history[0].Done != 3 || history[0].Todo != 3 {
+		t.Errorf("Expected 3 / 3 in first week, got %v / %v", history[0].Done, history[0].Todo)
+	}
+	if h.Done != 1 || h.Todo != 3 || h.PctDone != 33 {
+		t.Errorf("Expected 1 / 3 (33%%), got %v / %v (%v%%)", h.Done, h.Todo, h.PctDone)
+	}
+	if !h.DueToday {
+		t.Errorf("Expected habit to be due today")
+	}
+	if len(h.Missed) != 0 {
+		t.Errorf("Expected nothing missed, got %v", h.Missed)
+	}
+
+	friday := date(2016, time.January, 15)
+	h.applySchedule(history, daily, friday.AddDate(0, 0, 1))
+	if h.DueToday {
+		t.Errorf("Expected habit not to be due on Saturday")
+	}
+	if len(h.Missed) != 2 || !h.Missed[1].Equal(friday) {
+		t.Errorf("Expected Wednesday and Friday to be missed, got %v", h.Missed)
+	}
+}
+
+func TestValidateHabitFormSchedule(t *testing.T) {
+	form := url.Values{
+		"description": {"Gym"},
+		"todo":        {"1"},
+		"period":      {"week"},
+		"schedule":    {"weekdays"},
+		"weekdays":    {"1", "3", "5"},
+	}
+	h, errs := validateHabitForm(form, today)
+	if errs != nil {
+		t.Fatalf("Expected nil, got %v", errs)
+	}
+	if h.Weekdays.String() != "Mon, Wed, Fri" {
+		t.Errorf("Expected \"Mon, Wed, Fri\", got %v", h.Weekdays)
+	}
+	if h.PerOccurrence != 1 {
+		t.Errorf("Expected 1, got %v", h.PerOccurrence)
+	}
+
+	form.Del("weekdays")
+	if _, errs := validateHabitForm(form, today); errs["weekdays"] == "" {
+		t.Errorf("Expected weekdays error, got %v", errs)
+	}
+
+	form.Set("schedule", "interval")
+	form.Set("every_days", "0")
+	if _, errs := validateHabitForm(form, today); errs["every_days"] == "" {
+		t.Errorf("Expected every_days error, got %v", errs)
+	}
+
+	form.Set("schedule", "hourly")
+	if _, errs := validateHabitForm(form, today); errs["schedule"] == "" {
+		t.Errorf("Expected schedule error, got %v", errs)
+	}
+}
+
+func TestGetHabitsScheduled(t *testing.T) {
+	requireDatabase(t)
+	account, _ := CreateAccount(emailForTests, passwordForTests)
+	defer truncateDatabase()
+
+	now := time.Now()
+	h := newHabit("Daily", 1, PeriodWeek, now)
+	h.Schedule = ScheduleInterval
+	h.EveryDays = 1
+	h.PerOccurrence = 1
+	id, err := createHabit(h, account.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	habits, err := getHabits(account.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !habits[0].DueToday {
+		t.Errorf("Expected habit to be due today")
+	}
+
+	createHabitProgress(*id, 1, &now)
+	got, err := getHabit(*id, account.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.DueToday {
+		t.Errorf("Expected habit not to be due after progress")
+	}
+	if got.Done != 1 {
+		t.Errorf("Expected 1 occurrence done, got %v", got.Done)
+	}
+}
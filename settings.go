@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gorilla/context"
+)
+
+// settings are the preferences of an account.
+type settings struct {
+	// Summary is how often the account gets a progress summary email,
+	// empty for never.
+	Summary Period
+}
+
+var validSummaries = []Period{PeriodWeek, PeriodMonth}
+
+func settingsHandler(w http.ResponseWriter, r *http.Request) error {
+	accountId := context.Get(r, "accountId").(string)
+
+	if r.Method == "GET" {
+		s, err := storage.Settings(accountId)
+		if err != nil {
+			return err
+		}
+		if wantsJSON(r) {
+			return renderJSON(w, s)
+		}
+		values := url.Values{"summary": {string(s.Summary)}}
+		return renderTemplate(w, "settings.html", formData{Values: values})
+	} else if r.Method != "POST" {
+		return methodNotAllowed()
+	}
+
+	if err := r.ParseForm(); err != nil {
+		return badRequest(err)
+	}
+	s, errs := validateSettingsForm(r.PostForm)
+	if errs != nil {
+		return renderInvalidForm(w, r, "settings.html", errs)
+	}
+	if err := updateSettings(accountId, s, time.Now()); err != nil {
+		return err
+	}
+
+	http.Redirect(w, r, "/settings", http.StatusFound)
+	return nil
+}
+
+func validateSettingsForm(form url.Values) (*settings, validationErrors) {
+	s := &settings{Summary: Period(form.Get("summary"))}
+	if s.Summary == "" {
+		return s, nil
+	}
+	for _, p := range validSummaries {
+		if s.Summary == p {
+			return s, nil
+		}
+	}
+	return nil, validationErrors{"summary": "Summaries are sent weekly or monthly"}
+}
+
+// updateSettings saves the settings. The first summary after opting in is
+// sent at the end of the current period rather than for the period that
+// ended before.
+func updateSettings(accountId string, s *settings, now time.Time) error {
+	var sent time.Time
+	if s.Summary != "" {
+		sent = s.Summary.start(now)
+	}
+	return storage.UpdateSettings(accountId, s, sent)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/context"
+)
+
+func TestValidateSettingsForm(t *testing.T) {
+	for _, summary := range []string{"", "week", "month"} {
+		s, errs := validateSettingsForm(url.Values{"summary": {summary}})
+		if errs != nil || string(s.Summary) != summary {
+			t.Errorf("Expected %q, got %v (%v)", summary, s, errs)
+		}
+	}
+	if _, errs := validateSettingsForm(url.Values{"summary": {"day"}}); errs["summary"] == "" {
+		t.Errorf("Expected summary error, got %v", errs)
+	}
+}
+
+func TestSettingsHandlerSave(t *testing.T) {
+	defer withTestStore()()
+	account, _ := CreateAccount(emailForTests, passwordForTests)
+	defer truncateDatabase()
+
+	req, err := http.NewRequest("POST", "https://localhost/settings", strings.NewReader("summary=month"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	context.Set(req, "accountId", account.Id)
+	defer context.Clear(req)
+
+	w := httptest.NewRecorder()
+	appHandler(settingsHandler).ServeHTTP(w, req)
+
+	if w.Code != http.StatusFound {
+		t.Errorf("Expected %v, got %v", http.StatusFound, w.Code)
+	}
+	s, err := storage.Settings(account.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Summary != PeriodMonth {
+		t.Errorf("Expected %v, got %v", PeriodMonth, s.Summary)
+	}
+}
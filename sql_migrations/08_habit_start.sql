@@ -0,0 +1,2 @@
+-- habits created from the web form never had their start date set
+UPDATE habit SET start = created::date WHERE start < '2000-01-01';
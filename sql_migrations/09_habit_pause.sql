@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS habit_pause (
+       id uuid NOT NULL PRIMARY KEY DEFAULT uuid_generate_v4(),
+       habit_id uuid NOT NULL REFERENCES habit (id),
+       start date NOT NULL,
+       finish date NOT NULL,
+       reason text NOT NULL DEFAULT '',
+       created timestamp NOT NULL DEFAULT current_timestamp,
+       CHECK (finish >= start)
+);
@@ -0,0 +1,7 @@
+CREATE TYPE schedule_type AS ENUM ('period', 'weekdays', 'interval');
+
+-- weekdays is a bit mask, bit 0 is Sunday and bit 6 is Saturday
+ALTER TABLE habit
+  ADD COLUMN schedule schedule_type NOT NULL DEFAULT 'period',
+  ADD COLUMN weekdays integer NOT NULL DEFAULT 0,
+  ADD COLUMN every_days integer NOT NULL DEFAULT 0;
@@ -0,0 +1,6 @@
+ALTER TABLE habit
+  ALTER COLUMN points TYPE numeric(12, 2),
+  ADD COLUMN unit text NOT NULL DEFAULT '';
+
+ALTER TABLE habit_progress
+  ALTER COLUMN delta TYPE numeric(12, 2);
@@ -0,0 +1,4 @@
+CREATE TYPE habit_kind AS ENUM ('target', 'limit');
+
+ALTER TABLE habit
+  ADD COLUMN kind habit_kind NOT NULL DEFAULT 'target';
@@ -0,0 +1,6 @@
+ALTER TABLE habit_progress
+  ADD COLUMN id uuid NOT NULL DEFAULT uuid_generate_v4() PRIMARY KEY,
+  ADD COLUMN note text NOT NULL DEFAULT '',
+  ADD COLUMN tags text[] NOT NULL DEFAULT '{}';
+
+CREATE INDEX habit_progress_tags ON habit_progress USING gin (tags);
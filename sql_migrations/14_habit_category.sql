@@ -0,0 +1,3 @@
+ALTER TABLE habit
+  ADD COLUMN category text NOT NULL DEFAULT '',
+  ADD COLUMN tags text[] NOT NULL DEFAULT '{}';
@@ -0,0 +1,13 @@
+ALTER TABLE habit ADD COLUMN position integer NOT NULL DEFAULT 0;
+ALTER TABLE goal ADD COLUMN position integer NOT NULL DEFAULT 0;
+
+-- keep the order the lists were shown in so far
+UPDATE habit SET position = o.position
+  FROM (SELECT id, row_number() OVER (PARTITION BY account_id ORDER BY created) AS position
+        FROM habit) o
+  WHERE habit.id = o.id;
+
+UPDATE goal SET position = o.position
+  FROM (SELECT id, row_number() OVER (PARTITION BY account_id ORDER BY modified DESC) AS position
+        FROM goal) o
+  WHERE goal.id = o.id;
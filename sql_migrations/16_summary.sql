@@ -0,0 +1,10 @@
+ALTER TABLE account
+  ADD COLUMN summary period_type,
+  ADD COLUMN summary_sent date;
+
+CREATE TABLE IF NOT EXISTS goal_progress (
+       id uuid NOT NULL PRIMARY KEY DEFAULT uuid_generate_v4(),
+       goal_id uuid NOT NULL REFERENCES goal (id),
+       delta integer NOT NULL,
+       created timestamp NOT NULL DEFAULT current_timestamp
+);
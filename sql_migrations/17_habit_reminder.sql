@@ -0,0 +1,25 @@
+CREATE TYPE reminder_channel AS ENUM ('email', 'webhook');
+
+CREATE TABLE IF NOT EXISTS habit_reminder (
+       id uuid NOT NULL PRIMARY KEY DEFAULT uuid_generate_v4(),
+       habit_id uuid NOT NULL REFERENCES habit (id),
+       at time NOT NULL,
+       only_behind boolean NOT NULL DEFAULT false,
+       channel reminder_channel NOT NULL,
+       webhook_url text NOT NULL DEFAULT '',
+       created timestamp NOT NULL DEFAULT current_timestamp
+);
+
+CREATE TYPE delivery_status AS ENUM ('pending', 'sent', 'skipped', 'failed');
+
+-- one delivery per reminder and day, which keeps reminders from being sent
+-- twice
+CREATE TABLE IF NOT EXISTS reminder_delivery (
+       id uuid NOT NULL PRIMARY KEY DEFAULT uuid_generate_v4(),
+       reminder_id uuid NOT NULL REFERENCES habit_reminder (id) ON DELETE CASCADE,
+       day date NOT NULL,
+       status delivery_status NOT NULL DEFAULT 'pending',
+       error text NOT NULL DEFAULT '',
+       created timestamp NOT NULL DEFAULT current_timestamp,
+       UNIQUE (reminder_id, day)
+);
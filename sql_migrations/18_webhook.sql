@@ -0,0 +1,30 @@
+CREATE TABLE IF NOT EXISTS webhook (
+       id uuid NOT NULL PRIMARY KEY DEFAULT uuid_generate_v4(),
+       account_id uuid NOT NULL REFERENCES account (id),
+       url text NOT NULL,
+       secret text NOT NULL,
+       events text[] NOT NULL,
+       created timestamp NOT NULL DEFAULT current_timestamp
+);
+
+CREATE TYPE webhook_delivery_status AS ENUM ('pending', 'sent', 'failed');
+
+-- the queue of events to post to the webhooks, kept as their log
+CREATE TABLE IF NOT EXISTS webhook_delivery (
+       id uuid NOT NULL PRIMARY KEY DEFAULT uuid_generate_v4(),
+       webhook_id uuid NOT NULL REFERENCES webhook (id) ON DELETE CASCADE,
+       event text NOT NULL,
+       -- events with a key are queued once per webhook
+       event_key text,
+       payload text NOT NULL,
+       status webhook_delivery_status NOT NULL DEFAULT 'pending',
+       attempts integer NOT NULL DEFAULT 0,
+       next_attempt timestamp NOT NULL DEFAULT current_timestamp,
+       response_status integer NOT NULL DEFAULT 0,
+       error text NOT NULL DEFAULT '',
+       created timestamp NOT NULL DEFAULT current_timestamp,
+       delivered timestamp,
+       UNIQUE (webhook_id, event_key)
+);
+
+CREATE INDEX webhook_delivery_pending ON webhook_delivery (next_attempt) WHERE status = 'pending';
@@ -0,0 +1,16 @@
+-- secret tokens for logging progress on a habit through /hooks/{token}
+CREATE TABLE IF NOT EXISTS habit_token (
+       id uuid NOT NULL PRIMARY KEY DEFAULT uuid_generate_v4(),
+       habit_id uuid NOT NULL REFERENCES habit (id),
+       token text NOT NULL UNIQUE,
+       created timestamp NOT NULL DEFAULT current_timestamp,
+       revoked timestamp
+);
+
+-- request IDs seen through a token, so retried requests are logged once
+CREATE TABLE IF NOT EXISTS habit_token_request (
+       token_id uuid NOT NULL REFERENCES habit_token (id) ON DELETE CASCADE,
+       request_id text NOT NULL,
+       created timestamp NOT NULL DEFAULT current_timestamp,
+       PRIMARY KEY (token_id, request_id)
+);
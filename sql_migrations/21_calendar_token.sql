@@ -0,0 +1,2 @@
+-- secret token of the account's calendar feed, /calendar/{token}.ics
+ALTER TABLE account ADD COLUMN calendar_token text UNIQUE;
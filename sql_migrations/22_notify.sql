@@ -0,0 +1,40 @@
+-- tell listeners of account_change which account's habit progress or goals
+-- changed, the server pushes the change to the account's open pages
+CREATE OR REPLACE FUNCTION notify_progress_change() RETURNS TRIGGER AS $$
+DECLARE
+   r habit_progress;
+BEGIN
+   IF TG_OP = 'DELETE' THEN
+      r = OLD;
+   ELSE
+      r = NEW;
+   END IF;
+   PERFORM pg_notify('account_change', json_build_object(
+      'account_id', habit.account_id, 'kind', 'habit', 'id', habit.id)::text)
+      FROM habit WHERE habit.id = r.habit_id;
+   RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION notify_goal_change() RETURNS TRIGGER AS $$
+DECLARE
+   r goal;
+BEGIN
+   IF TG_OP = 'DELETE' THEN
+      r = OLD;
+   ELSE
+      r = NEW;
+   END IF;
+   PERFORM pg_notify('account_change', json_build_object(
+      'account_id', r.account_id, 'kind', 'goal', 'id', r.id)::text);
+   RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER notify_habit_progress_change
+AFTER INSERT OR UPDATE OR DELETE ON habit_progress
+FOR EACH ROW EXECUTE PROCEDURE notify_progress_change();
+
+CREATE TRIGGER notify_goal_change
+AFTER INSERT OR UPDATE OR DELETE ON goal
+FOR EACH ROW EXECUTE PROCEDURE notify_goal_change();
@@ -0,0 +1,12 @@
+-- Idempotency-Key headers of progress requests with the response to replay
+-- when the request is retried, code is NULL while it's being handled
+CREATE TABLE IF NOT EXISTS idempotency_key (
+       account_id uuid NOT NULL REFERENCES account (id) ON DELETE CASCADE,
+       key text NOT NULL,
+       request text NOT NULL,
+       code integer,
+       content_type text,
+       body bytea,
+       created timestamp NOT NULL DEFAULT current_timestamp,
+       PRIMARY KEY (account_id, key)
+);
@@ -0,0 +1,1230 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sqliteStore keeps everything in an SQLite file, for running the app for
+// a single user, e.g. on a Raspberry Pi, without a database server. The
+// driver is only built in with `go build -tags sqlite`. With a single
+// server there's nothing to listen to, the store publishes the changes of
+// progress and goals to live itself.
+type sqliteStore struct {
+	db *sql.DB
+}
+
+// sqliteTimeFormat is how timestamps are stored, the format of
+// strftime('%Y-%m-%d %H:%M:%f'), so they can be compared as text.
+const sqliteTimeFormat = "2006-01-02 15:04:05.000"
+
+// sqliteNow is the current local time in SQL. Times are kept in local time
+// like in the timestamp columns of Postgres, so periods and days start at
+// local midnight.
+const sqliteNow = "'now', 'localtime'"
+
+// openSQLite opens the database file, creating it if needed.
+func openSQLite(path string) (*sql.DB, error) {
+	conn, err := sql.Open(sqlite.driver, "file:"+path+"?_foreign_keys=1&_busy_timeout=5000")
+	if err != nil {
+		return nil, fmt.Errorf("%v, SQLite needs a build with -tags sqlite", err)
+	}
+	// a single connection keeps writers from locking each other out,
+	// and in-memory databases from being one per connection
+	conn.SetMaxOpenConns(1)
+	if err := conn.Ping(); err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// sqlitePeriodStart is the SQL for the start of the period of habit h
+// containing the time t, like date_trunc does in Postgres. Weeks start on
+// Monday.
+func sqlitePeriodStart(t string) string {
+	return fmt.Sprintf(`CASE h.period WHEN 'day' THEN date(%[1]s)
+                                         WHEN 'week' THEN date(%[1]s, 'weekday 0', '-6 days')
+                                         ELSE date(%[1]s, 'start of month') END`, t)
+}
+
+// sqlitePeriodNext is the SQL for the start of the period of habit h
+// following the one starting at t.
+func sqlitePeriodNext(t string) string {
+	return fmt.Sprintf(`CASE h.period WHEN 'day' THEN date(%[1]s, '+1 day')
+                                         WHEN 'week' THEN date(%[1]s, '+7 days')
+                                         ELSE date(%[1]s, '+1 month') END`, t)
+}
+
+func (s sqliteStore) CreateAccount(email string, hashedPassword []byte) (string, error) {
+	var id string
+
+	query := "INSERT INTO account (email, password) VALUES (?1, ?2) RETURNING id"
+	if err := s.db.QueryRow(query, email, string(hashedPassword)).Scan(&id); err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+func (s sqliteStore) Account(email string) (*Account, error) {
+	var id, password string
+
+	query := "SELECT id, password FROM account WHERE email = ?1"
+	err := s.db.QueryRow(query, email).Scan(&id, &password)
+	if err == sql.ErrNoRows {
+		return nil, errAccountNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &Account{id, email, []byte(password)}, nil
+}
+
+// sqliteHabitSelect selects the columns read by scanHabit, like habitSelect.
+var sqliteHabitSelect = `SELECT id,
+                    description,
+                    points,
+                    (SELECT round(coalesce(sum(delta), 0), 2)
+                     FROM habit_progress p
+                     WHERE h.id = p.habit_id
+                       AND p.created >= h.start
+                       AND p.created >= ` + sqlitePeriodStart(sqliteNow) + `
+                       AND p.created < ` + sqlitePeriodNext(sqlitePeriodStart(sqliteNow)) + `),
+                    period,
+                    start,
+                    start > date(` + sqliteNow + `),
+                    schedule,
+                    weekdays,
+                    every_days,
+                    unit,
+                    kind,
+                    category,
+                    tags,
+                    retired
+                  FROM habit h`
+
+func (s sqliteStore) Habits(accountId string) ([]habit, error) {
+	return s.queryHabits(sqliteHabitSelect+" WHERE h.account_id = ?1 AND retired IS NULL ORDER BY h.position, h.created, h.id", accountId)
+}
+
+func (s sqliteStore) AllHabits(accountId string) ([]habit, error) {
+	return s.queryHabits(sqliteHabitSelect+" WHERE h.account_id = ?1 ORDER BY h.position, h.created, h.id", accountId)
+}
+
+func (s sqliteStore) queryHabits(query string, args ...interface{}) ([]habit, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var habits []habit
+	for rows.Next() {
+		h, err := scanHabit(rows)
+		if err != nil {
+			return nil, err
+		}
+		h.Retired = sqliteLocalPtr(h.Retired)
+		habits = append(habits, *h)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return habits, nil
+}
+
+func (s sqliteStore) Habit(id, accountId string) (*habit, error) {
+	query := sqliteHabitSelect + " WHERE h.id = ?1 AND h.account_id = ?2"
+
+	h, err := scanHabit(s.db.QueryRow(query, id, accountId))
+	if err == sql.ErrNoRows {
+		return nil, errHabitNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	h.Retired = sqliteLocalPtr(h.Retired)
+
+	return h, nil
+}
+
+func (s sqliteStore) CreateHabit(h *habit, accountId string) (string, error) {
+	return sqliteInsertHabit(s.db, h, accountId)
+}
+
+func sqliteInsertHabit(q queryer, h *habit, accountId string) (string, error) {
+	var id string
+
+	points := h.Todo
+	if h.scheduled() {
+		points = h.PerOccurrence
+	}
+	schedule := h.Schedule
+	if schedule == "" {
+		schedule = SchedulePeriod
+	}
+	kind := h.Kind
+	if kind == "" {
+		kind = KindTarget
+	}
+
+	var retired interface{}
+	if h.Retired != nil {
+		retired = sqliteTime(*h.Retired)
+	}
+
+	query := `INSERT INTO habit (description, kind, points, unit, period, start, schedule, weekdays, every_days,
+                                     category, tags, retired, account_id, position)
+                  VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8, ?9, ?10, ?11, ?12, ?13,
+                          (SELECT coalesce(max(position), 0) + 1 FROM habit WHERE account_id = ?13))
+                  RETURNING id`
+	err := q.QueryRow(query, h.Description, string(kind), points, h.Unit, string(h.Period), h.Start.Format(dateFormat),
+		string(schedule), int(h.Weekdays), h.EveryDays, h.Category, strings.Join(h.Tags, ","), retired, accountId).Scan(&id)
+	if err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+// sqliteHistoryQuery lists every period of the habits like
+// habitHistoryQuery, the recursive s takes the place of generate_series.
+// The habits are filtered by the condition filling in %s.
+var sqliteHistoryQuery = `WITH RECURSIVE s(habit_id, start) AS (
+                      SELECT h.id, ` + sqlitePeriodStart("h.start") + `
+                      FROM habit h
+                      WHERE h.account_id = ?1 %s
+                        AND ` + sqlitePeriodStart("h.start") + ` <= ` + sqlitePeriodStart(sqliteNow) + `
+                    UNION ALL
+                      SELECT h.id, ` + sqlitePeriodNext("s.start") + `
+                      FROM s JOIN habit h ON h.id = s.habit_id
+                      WHERE ` + sqlitePeriodNext("s.start") + ` <= ` + sqlitePeriodStart(sqliteNow) + `
+                  )
+                  SELECT h.id,
+                    h.points,
+                    s.start,
+                    (SELECT round(coalesce(sum(delta), 0), 2)
+                     FROM habit_progress p
+                     WHERE h.id = p.habit_id
+                       AND p.created >= h.start
+                       AND p.created >= s.start
+                       AND p.created < ` + sqlitePeriodNext("s.start") + `)
+                  FROM s JOIN habit h ON h.id = s.habit_id
+                  ORDER BY h.id, s.start`
+
+func (s sqliteStore) HabitHistories(accountId string) (map[string][]periodProgress, error) {
+	return s.queryHabitHistories(fmt.Sprintf(sqliteHistoryQuery, "AND h.retired IS NULL"), accountId)
+}
+
+func (s sqliteStore) HabitHistory(id, accountId string) ([]periodProgress, error) {
+	histories, err := s.queryHabitHistories(fmt.Sprintf(sqliteHistoryQuery, "AND h.id = ?2"), accountId, id)
+	if err != nil {
+		return nil, err
+	}
+	return histories[id], nil
+}
+
+func (s sqliteStore) queryHabitHistories(query string, args ...interface{}) (map[string][]periodProgress, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	histories := make(map[string][]periodProgress)
+	var id, start string
+	var todo, done float64
+
+	for rows.Next() {
+		if err := rows.Scan(&id, &todo, &start, &done); err != nil {
+			return nil, err
+		}
+		t, err := time.Parse(dateFormat, start)
+		if err != nil {
+			return nil, err
+		}
+		histories[id] = append(histories[id], periodProgress{
+			Start:   t,
+			Done:    done,
+			Todo:    todo,
+			PctDone: calcPercentage(done, todo),
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return histories, nil
+}
+
+func (s sqliteStore) DailyProgress(accountId string) (map[string]map[string]float64, error) {
+	query := `SELECT p.habit_id, date(p.created), round(sum(p.delta), 2)
+                  FROM habit_progress p JOIN habit h ON h.id = p.habit_id
+                  WHERE h.account_id = ?1
+                    AND h.schedule <> 'period'
+                    AND p.created >= h.start
+                  GROUP BY p.habit_id, date(p.created)`
+
+	rows, err := s.db.Query(query, accountId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	daily := make(map[string]map[string]float64)
+	var habitId, day string
+	var done float64
+
+	for rows.Next() {
+		if err := rows.Scan(&habitId, &day, &done); err != nil {
+			return nil, err
+		}
+		if daily[habitId] == nil {
+			daily[habitId] = make(map[string]float64)
+		}
+		daily[habitId][day] = done
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return daily, nil
+}
+
+func (s sqliteStore) HabitPauses(accountId string) (map[string][]habitPause, error) {
+	query := `SELECT p.habit_id, p.id, p.start, p.finish, p.reason
+                  FROM habit_pause p JOIN habit h ON h.id = p.habit_id
+                  WHERE h.account_id = ?1
+                  ORDER BY p.start`
+
+	rows, err := s.db.Query(query, accountId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pauses := make(map[string][]habitPause)
+	var habitId, id, reason string
+	var start, finish time.Time
+
+	for rows.Next() {
+		if err := rows.Scan(&habitId, &id, &start, &finish, &reason); err != nil {
+			return nil, err
+		}
+		pauses[habitId] = append(pauses[habitId], habitPause{
+			Id:     id,
+			Start:  start,
+			Finish: finish,
+			Reason: reason,
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return pauses, nil
+}
+
+func (s sqliteStore) CreateHabitPause(habitId, accountId string, p *habitPause) (string, error) {
+	var id string
+
+	query := `INSERT INTO habit_pause (habit_id, start, finish, reason)
+                  SELECT id, ?3, ?4, ?5 FROM habit WHERE id = ?1 AND account_id = ?2
+                  RETURNING id`
+	err := s.db.QueryRow(query, habitId, accountId, p.Start.Format(dateFormat), p.Finish.Format(dateFormat), p.Reason).Scan(&id)
+	if err == sql.ErrNoRows {
+		return "", errHabitNotFound
+	} else if err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+func (s sqliteStore) DeleteHabitPause(id, accountId string) (string, error) {
+	var habitId string
+
+	query := `DELETE FROM habit_pause
+                  WHERE id = ?1 AND habit_id IN (SELECT id FROM habit WHERE account_id = ?2)
+                  RETURNING habit_id`
+	err := s.db.QueryRow(query, id, accountId).Scan(&habitId)
+	if err == sql.ErrNoRows {
+		return "", errPauseNotFound
+	} else if err != nil {
+		return "", err
+	}
+
+	return habitId, nil
+}
+
+func (s sqliteStore) CreateProgressEntry(habitId string, entry *progressEntry) error {
+	var accountId string
+
+	query := `INSERT INTO habit_progress (habit_id, delta, note, tags) VALUES (?1, ?2, ?3, ?4)
+                  RETURNING (SELECT account_id FROM habit WHERE id = ?1)`
+	err := s.db.QueryRow(query, habitId, entry.Delta, entry.Note, strings.Join(entry.Tags, ",")).Scan(&accountId)
+	if err != nil {
+		return err
+	}
+	live.publish(liveChange{accountId, liveHabit, habitId})
+	return nil
+}
+
+func (s sqliteStore) CreateProgressEntries(accountId string, entries []progressEntry) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	ids := habitIds(entries)
+	var found int
+	query := `SELECT count(*) FROM habit
+                  WHERE account_id = ?1 AND instr(',' || ?2 || ',', ',' || id || ',') > 0 AND retired IS NULL`
+	if err := tx.QueryRow(query, accountId, strings.Join(ids, ",")).Scan(&found); err != nil {
+		return err
+	}
+	if found != len(ids) {
+		return errHabitNotFound
+	}
+
+	stmt, err := tx.Prepare("INSERT INTO habit_progress (habit_id, delta, note, tags, created) VALUES (?1, ?2, ?3, ?4, ?5)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, e := range entries {
+		_, err := stmt.Exec(e.HabitId, e.Delta, e.Note, strings.Join(e.Tags, ","), sqliteTime(e.Created))
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		live.publish(liveChange{accountId, liveHabit, id})
+	}
+	return nil
+}
+
+func (s sqliteStore) SyncProgressEntry(accountId, clientId string, entry *progressEntry) (bool, error) {
+	query := `INSERT INTO habit_progress (habit_id, delta, note, tags, created, client_id)
+                  SELECT id, ?3, ?4, ?5, ?6, ?7 FROM habit
+                  WHERE id = ?1 AND account_id = ?2
+                  ON CONFLICT (habit_id, client_id) DO NOTHING`
+	res, err := s.db.Exec(query, entry.HabitId, accountId, entry.Delta, entry.Note, strings.Join(entry.Tags, ","),
+		sqliteTime(entry.Created), clientId)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if n == 1 {
+		live.publish(liveChange{accountId, liveHabit, entry.HabitId})
+	}
+	return n == 1, err
+}
+
+const sqliteProgressSelect = `SELECT p.id, h.id, h.description, p.delta, p.note, p.tags, p.created
+                  FROM habit_progress p JOIN habit h ON h.id = p.habit_id`
+
+func (s sqliteStore) ProgressEntries(habitId, accountId string) ([]progressEntry, error) {
+	query := sqliteProgressSelect + ` WHERE h.id = ?1 AND h.account_id = ?2
+                  ORDER BY p.created DESC LIMIT ` + strconv.Itoa(maxProgressEntries)
+	return s.queryProgressEntries(query, habitId, accountId)
+}
+
+// SearchProgressEntries matches notes with LIKE, which ignores the case of
+// ASCII letters only.
+func (s sqliteStore) SearchProgressEntries(accountId, q, tag string) ([]progressEntry, error) {
+	query := sqliteProgressSelect + ` WHERE h.account_id = ?1
+                    AND (?2 = '' OR p.note LIKE '%' || ?2 || '%' ESCAPE '\')
+                    AND (?3 = '' OR instr(',' || p.tags || ',', ',' || ?3 || ',') > 0)
+                  ORDER BY p.created DESC LIMIT ` + strconv.Itoa(maxProgressEntries)
+	return s.queryProgressEntries(query, accountId, escapeLike(q), tag)
+}
+
+func (s sqliteStore) EachProgressEntry(accountId string, from time.Time, fn func(e progressEntry) error) error {
+	query := sqliteProgressSelect + ` WHERE h.account_id = ?1 AND p.created >= ?2
+                  ORDER BY p.created, p.id`
+	return s.eachProgressEntry(query, fn, accountId, from.Format(dateFormat))
+}
+
+func (s sqliteStore) queryProgressEntries(query string, args ...interface{}) ([]progressEntry, error) {
+	var entries []progressEntry
+	err := s.eachProgressEntry(query, func(e progressEntry) error {
+		entries = append(entries, e)
+		return nil
+	}, args...)
+	return entries, err
+}
+
+// eachProgressEntry calls fn with the entries of the query as they are read.
+func (s sqliteStore) eachProgressEntry(query string, fn func(e progressEntry) error, args ...interface{}) error {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e progressEntry
+		var tags string
+		err := rows.Scan(&e.Id, &e.HabitId, &e.Description, &e.Delta, &e.Note, &tags, &e.Created)
+		if err != nil {
+			return err
+		}
+		e.Created = sqliteLocal(e.Created)
+		if tags != "" {
+			e.Tags = strings.Split(tags, ",")
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (s sqliteStore) Goals(accountId string) ([]goal, error) {
+	var goals []goal
+
+	query := `SELECT id,
+                         description,
+                         CAST(round(100.0 * points_done / points_total) AS integer),
+                         points_done,
+                         points_total,
+                         modified,
+                         due
+                  FROM goal
+                  WHERE account_id = ?1
+                  ORDER BY position, created, id`
+
+	rows, err := s.db.Query(query, accountId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var g goal
+		if err := rows.Scan(&g.Id, &g.Description, &g.PctDone, &g.PointsDone, &g.PointsTotal, &g.Modified, &g.Due); err != nil {
+			return nil, err
+		}
+		g.Modified = sqliteLocal(g.Modified)
+		goals = append(goals, g)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return goals, nil
+}
+
+func (s sqliteStore) CreateGoal(g *goal, accountId string) (string, error) {
+	var id string
+
+	query := `INSERT INTO goal (description, points_total, due, account_id, position)
+                  VALUES (?1, ?2, ?3, ?4, (SELECT coalesce(max(position), 0) + 1 FROM goal WHERE account_id = ?4))
+                  RETURNING id`
+	err := s.db.QueryRow(query, g.Description, g.PointsTotal, sqliteDate(g.Due), accountId).Scan(&id)
+	if err != nil {
+		return "", err
+	}
+
+	live.publish(liveChange{accountId, liveGoal, id})
+	return id, nil
+}
+
+func (s sqliteStore) AddGoalPoint(id, accountId string) (*goal, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	g := goal{Id: id}
+	query := `UPDATE goal SET points_done = points_done + 1, modified = strftime('%Y-%m-%d %H:%M:%f', 'now', 'localtime')
+                  WHERE id = ?1 AND account_id = ?2
+                  RETURNING description, points_done, points_total,
+                            CAST(round(100.0 * points_done / points_total) AS integer), modified`
+	err = tx.QueryRow(query, id, accountId).Scan(&g.Description, &g.PointsDone, &g.PointsTotal, &g.PctDone, &g.Modified)
+	if err == sql.ErrNoRows {
+		return nil, errGoalNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	g.Modified = sqliteLocal(g.Modified)
+
+	if _, err := tx.Exec("INSERT INTO goal_progress (goal_id, delta) VALUES (?1, 1)", id); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	live.publish(liveChange{accountId, liveGoal, id})
+	return &g, nil
+}
+
+func (s sqliteStore) Import(accountId string, p *importPlan) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	habitIds := make([]string, len(p.Habits))
+	for i := range p.Habits {
+		if habitIds[i], err = sqliteInsertHabit(tx, &p.Habits[i], accountId); err != nil {
+			return err
+		}
+	}
+	goalIds := make([]string, len(p.Goals))
+	for i, g := range p.Goals {
+		query := `INSERT INTO goal (description, points_done, points_total, due, account_id, position)
+                          VALUES (?1, ?2, ?3, ?4, ?5, (SELECT coalesce(max(position), 0) + 1 FROM goal WHERE account_id = ?5))
+                          RETURNING id`
+		err := tx.QueryRow(query, g.Description, g.PointsDone, g.PointsTotal, sqliteDate(g.Due), accountId).Scan(&goalIds[i])
+		if err != nil {
+			return err
+		}
+	}
+	changed := make(map[string]bool)
+	for _, p := range p.Pauses {
+		habitId := p.HabitId
+		if habitId == "" {
+			habitId = habitIds[p.NewHabit]
+		}
+		query := "INSERT INTO habit_pause (habit_id, start, finish, reason) VALUES (?1, ?2, ?3, ?4)"
+		if _, err := tx.Exec(query, habitId, p.Start.Format(dateFormat), p.Finish.Format(dateFormat), p.Reason); err != nil {
+			return err
+		}
+		changed[habitId] = true
+	}
+
+	stmt, err := tx.Prepare("INSERT INTO habit_progress (habit_id, delta, note, tags, created) VALUES (?1, ?2, ?3, ?4, ?5)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, e := range p.Entries {
+		habitId := e.HabitId
+		if habitId == "" {
+			habitId = habitIds[e.NewHabit]
+		}
+		_, err := stmt.Exec(habitId, e.Delta, e.Note, strings.Join(e.Tags, ","), sqliteTime(e.Created))
+		if err != nil {
+			return err
+		}
+		changed[habitId] = true
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	for id := range changed {
+		live.publish(liveChange{accountId, liveHabit, id})
+	}
+	for _, id := range goalIds {
+		live.publish(liveChange{accountId, liveGoal, id})
+	}
+	return nil
+}
+
+func (s sqliteStore) ClaimIdempotencyKey(accountId, key, request string, retention time.Duration) (*idempotentResponse, error) {
+	query := `DELETE FROM idempotency_key
+                  WHERE account_id = ?1 AND created < strftime('%Y-%m-%d %H:%M:%f', 'now', 'localtime', ?2)`
+	if _, err := s.db.Exec(query, accountId, fmt.Sprintf("-%d seconds", int(retention.Seconds()))); err != nil {
+		return nil, err
+	}
+
+	query = `INSERT INTO idempotency_key (account_id, key, request) VALUES (?1, ?2, ?3)
+                 ON CONFLICT DO NOTHING`
+	res, err := s.db.Exec(query, accountId, key, request)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 1 {
+		return nil, err
+	}
+
+	resp := &idempotentResponse{}
+	query = `SELECT request, coalesce(code, 0), coalesce(content_type, ''), coalesce(body, x'')
+                 FROM idempotency_key WHERE account_id = ?1 AND key = ?2`
+	err = s.db.QueryRow(query, accountId, key).Scan(&resp.Request, &resp.Code, &resp.ContentType, &resp.Body)
+	if err == sql.ErrNoRows {
+		// released by the first request just now, it's still going on
+		return &idempotentResponse{Request: request}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (s sqliteStore) SaveIdempotentResponse(accountId, key string, resp *idempotentResponse) error {
+	query := `UPDATE idempotency_key SET code = ?3, content_type = ?4, body = ?5
+                  WHERE account_id = ?1 AND key = ?2`
+	_, err := s.db.Exec(query, accountId, key, resp.Code, resp.ContentType, resp.Body)
+	return err
+}
+
+func (s sqliteStore) ReleaseIdempotencyKey(accountId, key string) error {
+	_, err := s.db.Exec("DELETE FROM idempotency_key WHERE account_id = ?1 AND key = ?2", accountId, key)
+	return err
+}
+
+// sqliteDate formats an optional date as it's stored, NULL when it's nil.
+func sqliteDate(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.Format(dateFormat)
+}
+
+// sqliteTime formats a time as it's stored, in local time.
+func sqliteTime(t time.Time) string {
+	return t.In(time.Local).Format(sqliteTimeFormat)
+}
+
+// sqliteLocal returns the time read from a timestamp column, which the
+// driver reads as UTC, in local time like it was stored.
+func sqliteLocal(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.Local)
+}
+
+func sqliteLocalPtr(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	local := sqliteLocal(*t)
+	return &local
+}
+
+func (s sqliteStore) GoalSummaries(accountId string, start, end time.Time) ([]goalSummary, error) {
+	query := `SELECT g.description, sum(p.delta), g.points_done, g.points_total
+                  FROM goal_progress p JOIN goal g ON g.id = p.goal_id
+                  WHERE g.account_id = ?1 AND p.created >= ?2 AND p.created < ?3
+                  GROUP BY g.id
+                  ORDER BY g.position, g.created`
+
+	// the periods start at local midnight, which sorts like their date
+	rows, err := s.db.Query(query, accountId, start.Format(dateFormat), end.Format(dateFormat))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var goals []goalSummary
+	for rows.Next() {
+		var g goalSummary
+		if err := rows.Scan(&g.Description, &g.Advanced, &g.PointsDone, &g.PointsTotal); err != nil {
+			return nil, err
+		}
+		goals = append(goals, g)
+	}
+	return goals, rows.Err()
+}
+
+func (s sqliteStore) ReorderHabits(accountId string, ids []string) error {
+	return s.reorder("habit", accountId, ids, errHabitNotFound)
+}
+
+func (s sqliteStore) ReorderGoals(accountId string, ids []string) error {
+	if err := s.reorder("goal", accountId, ids, errGoalNotFound); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		live.publish(liveChange{accountId, liveGoal, id})
+	}
+	return nil
+}
+
+// reorder renumbers the positions of the rows of the table like
+// postgresStore.reorder, the transaction keeps out other writers.
+func (s sqliteStore) reorder(table, accountId string, ids []string, errNotFound error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := "SELECT id FROM " + table + " WHERE account_id = ?1 ORDER BY position, created"
+	rows, err := tx.Query(query, accountId)
+	if err != nil {
+		return err
+	}
+	var current []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		current = append(current, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	order, ok := reorderIds(current, ids)
+	if !ok {
+		return errNotFound
+	}
+	for i, id := range order {
+		if _, err := tx.Exec("UPDATE "+table+" SET position = ?1 WHERE id = ?2", i+1, id); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s sqliteStore) Settings(accountId string) (*settings, error) {
+	var summary sql.NullString
+
+	query := "SELECT summary FROM account WHERE id = ?1"
+	if err := s.db.QueryRow(query, accountId).Scan(&summary); err != nil {
+		return nil, err
+	}
+
+	return &settings{Summary: Period(summary.String)}, nil
+}
+
+func (s sqliteStore) UpdateSettings(accountId string, st *settings, summarySent time.Time) error {
+	var summary, sent interface{}
+	if st.Summary != "" {
+		summary, sent = string(st.Summary), summarySent.Format(dateFormat)
+	}
+
+	query := "UPDATE account SET summary = ?2, summary_sent = ?3 WHERE id = ?1"
+	_, err := s.db.Exec(query, accountId, summary, sent)
+	return err
+}
+
+func (s sqliteStore) ClaimSummaries(p Period, end time.Time) ([]Account, error) {
+	query := `UPDATE account SET summary_sent = ?2
+                  WHERE summary = ?1 AND (summary_sent IS NULL OR summary_sent < ?2)
+                  RETURNING id, email`
+
+	rows, err := s.db.Query(query, string(p), end.Format(dateFormat))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var accounts []Account
+	for rows.Next() {
+		var a Account
+		if err := rows.Scan(&a.Id, &a.Email); err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, a)
+	}
+	return accounts, rows.Err()
+}
+
+func (s sqliteStore) CalendarToken(accountId string) (string, error) {
+	var token sql.NullString
+	err := s.db.QueryRow("SELECT calendar_token FROM account WHERE id = ?1", accountId).Scan(&token)
+	return token.String, err
+}
+
+func (s sqliteStore) SetCalendarToken(accountId, token string) error {
+	_, err := s.db.Exec("UPDATE account SET calendar_token = ?2 WHERE id = ?1", accountId, token)
+	return err
+}
+
+func (s sqliteStore) CalendarAccount(token string) (string, error) {
+	var accountId string
+	err := s.db.QueryRow("SELECT id FROM account WHERE calendar_token = ?1", token).Scan(&accountId)
+	if err == sql.ErrNoRows {
+		return "", errCalendarNotFound
+	}
+	return accountId, err
+}
+
+func (s sqliteStore) CreateHabitToken(habitId, accountId string, t *habitToken) error {
+	query := `INSERT INTO habit_token (habit_id, token)
+                  SELECT id, ?3 FROM habit WHERE id = ?1 AND account_id = ?2
+                  RETURNING id, created`
+	err := s.db.QueryRow(query, habitId, accountId, t.Token).Scan(&t.Id, &t.Created)
+	if err == sql.ErrNoRows {
+		return errHabitNotFound
+	}
+	t.Created = sqliteLocal(t.Created)
+	return err
+}
+
+func (s sqliteStore) RevokeHabitToken(id, accountId string) (string, error) {
+	var habitId string
+
+	query := `UPDATE habit_token SET revoked = coalesce(revoked, strftime('%Y-%m-%d %H:%M:%f', 'now', 'localtime'))
+                  WHERE id = ?1 AND habit_id IN (SELECT id FROM habit WHERE account_id = ?2)
+                  RETURNING habit_id`
+	err := s.db.QueryRow(query, id, accountId).Scan(&habitId)
+	if err == sql.ErrNoRows {
+		return "", errTokenNotFound
+	} else if err != nil {
+		return "", err
+	}
+
+	return habitId, nil
+}
+
+func (s sqliteStore) HabitTokens(habitId, accountId string) ([]habitToken, error) {
+	query := `SELECT t.id, t.token, t.created, t.revoked
+                  FROM habit_token t JOIN habit h ON h.id = t.habit_id
+                  WHERE h.id = ?1 AND h.account_id = ?2
+                  ORDER BY t.created`
+
+	rows, err := s.db.Query(query, habitId, accountId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []habitToken
+	for rows.Next() {
+		var t habitToken
+		if err := rows.Scan(&t.Id, &t.Token, &t.Created, &t.Revoked); err != nil {
+			return nil, err
+		}
+		t.Created, t.Revoked = sqliteLocal(t.Created), sqliteLocalPtr(t.Revoked)
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+func (s sqliteStore) HabitByToken(token string) (tokenId, habitId, accountId string, err error) {
+	query := `SELECT t.id, h.id, h.account_id
+                  FROM habit_token t JOIN habit h ON h.id = t.habit_id
+                  WHERE t.token = ?1 AND t.revoked IS NULL AND h.retired IS NULL`
+	err = s.db.QueryRow(query, token).Scan(&tokenId, &habitId, &accountId)
+	if err == sql.ErrNoRows {
+		err = errTokenNotFound
+	}
+	return
+}
+
+// CreateHookProgressEntry never finds a request in progress, the single
+// connection has a duplicate wait until the first one is committed.
+func (s sqliteStore) CreateHookProgressEntry(tokenId, requestId string, entry *progressEntry) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	query := `INSERT INTO habit_token_request (token_id, request_id) VALUES (?1, ?2)
+                  ON CONFLICT DO NOTHING`
+	res, err := tx.Exec(query, tokenId, requestId)
+	if err != nil {
+		return false, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+
+	var accountId string
+	query = `INSERT INTO habit_progress (habit_id, delta, note, tags) VALUES (?1, ?2, ?3, ?4)
+                 RETURNING (SELECT account_id FROM habit WHERE id = ?1)`
+	err = tx.QueryRow(query, entry.HabitId, entry.Delta, entry.Note, strings.Join(entry.Tags, ",")).Scan(&accountId)
+	if err != nil {
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+	live.publish(liveChange{accountId, liveHabit, entry.HabitId})
+	return true, nil
+}
+
+func (s sqliteStore) CreateReminder(habitId, accountId string, r *reminder) (string, error) {
+	var id string
+
+	query := `INSERT INTO habit_reminder (habit_id, at, only_behind, channel, webhook_url)
+                  SELECT id, ?3, ?4, ?5, ?6 FROM habit WHERE id = ?1 AND account_id = ?2
+                  RETURNING id`
+	err := s.db.QueryRow(query, habitId, accountId, r.At, r.OnlyBehind, r.Channel, r.WebhookURL).Scan(&id)
+	if err == sql.ErrNoRows {
+		return "", errHabitNotFound
+	} else if err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+func (s sqliteStore) DeleteReminder(id, accountId string) (string, error) {
+	var habitId string
+
+	query := `DELETE FROM habit_reminder
+                  WHERE id = ?1 AND habit_id IN (SELECT id FROM habit WHERE account_id = ?2)
+                  RETURNING habit_id`
+	err := s.db.QueryRow(query, id, accountId).Scan(&habitId)
+	if err == sql.ErrNoRows {
+		return "", errReminderNotFound
+	} else if err != nil {
+		return "", err
+	}
+
+	return habitId, nil
+}
+
+// sqliteReminderSelect selects the columns read by scanReminder.
+const sqliteReminderSelect = `SELECT r.id, r.habit_id, r.at, r.only_behind, r.channel, r.webhook_url
+                  FROM habit_reminder r JOIN habit h ON h.id = r.habit_id`
+
+func (s sqliteStore) Reminders(habitId, accountId string) ([]reminder, error) {
+	query := sqliteReminderSelect + " WHERE h.id = ?1 AND h.account_id = ?2 ORDER BY r.at"
+
+	rows, err := s.db.Query(query, habitId, accountId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reminders []reminder
+	for rows.Next() {
+		r, err := scanReminder(rows)
+		if err != nil {
+			return nil, err
+		}
+		reminders = append(reminders, *r)
+	}
+	return reminders, rows.Err()
+}
+
+func (s sqliteStore) ReminderDeliveries(habitId, accountId string) ([]reminderDelivery, error) {
+	query := `SELECT d.day, r.at, r.channel, d.status, d.error
+                  FROM reminder_delivery d
+                    JOIN habit_reminder r ON r.id = d.reminder_id
+                    JOIN habit h ON h.id = r.habit_id
+                  WHERE h.id = ?1 AND h.account_id = ?2
+                  ORDER BY d.day DESC, r.at DESC
+                  LIMIT ` + strconv.Itoa(maxReminderDeliveries)
+
+	rows, err := s.db.Query(query, habitId, accountId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []reminderDelivery
+	for rows.Next() {
+		var d reminderDelivery
+		if err := rows.Scan(&d.Day, &d.At, &d.Channel, &d.Status, &d.Error); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+func (s sqliteStore) DueReminders(at string, day, stale time.Time) ([]dueReminder, error) {
+	query := `SELECT r.id, r.habit_id, r.at, r.only_behind, r.channel, r.webhook_url, a.id, a.email
+                  FROM habit_reminder r
+                    JOIN habit h ON h.id = r.habit_id
+                    JOIN account a ON a.id = h.account_id
+                  WHERE h.retired IS NULL
+                    AND r.at <= ?1
+                    AND NOT EXISTS (SELECT 1 FROM reminder_delivery d
+                                    WHERE d.reminder_id = r.id AND d.day = ?2
+                                      AND (d.status <> 'pending' OR d.created >= ?3))
+                  ORDER BY a.id`
+
+	rows, err := s.db.Query(query, at, day.Format(dateFormat), sqliteTime(stale))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var due []dueReminder
+	for rows.Next() {
+		var d dueReminder
+		rem, err := scanReminder(rows, &d.AccountId, &d.Email)
+		if err != nil {
+			return nil, err
+		}
+		d.reminder = *rem
+		due = append(due, d)
+	}
+	return due, rows.Err()
+}
+
+func (s sqliteStore) ClaimReminderDelivery(reminderId string, day, stale time.Time) (bool, error) {
+	query := `INSERT INTO reminder_delivery (reminder_id, day) VALUES (?1, ?2)
+                  ON CONFLICT (reminder_id, day) DO UPDATE SET created = strftime('%Y-%m-%d %H:%M:%f', 'now', 'localtime')
+                  WHERE reminder_delivery.status = 'pending' AND reminder_delivery.created < ?3`
+	res, err := s.db.Exec(query, reminderId, day.Format(dateFormat), sqliteTime(stale))
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+func (s sqliteStore) FinishReminderDelivery(reminderId string, day time.Time, status, msg string) error {
+	query := "UPDATE reminder_delivery SET status = ?3, error = ?4 WHERE reminder_id = ?1 AND day = ?2"
+	_, err := s.db.Exec(query, reminderId, day.Format(dateFormat), status, msg)
+	return err
+}
+
+func (s sqliteStore) CreateWebhook(accountId string, hook *webhook) error {
+	query := "INSERT INTO webhook (account_id, url, secret, events) VALUES (?1, ?2, ?3, ?4) RETURNING id"
+	return s.db.QueryRow(query, accountId, hook.URL, hook.Secret, strings.Join(hook.Events, ",")).Scan(&hook.Id)
+}
+
+func (s sqliteStore) DeleteWebhook(id, accountId string) error {
+	res, err := s.db.Exec("DELETE FROM webhook WHERE id = ?1 AND account_id = ?2", id, accountId)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return errWebhookNotFound
+	}
+	return nil
+}
+
+func (s sqliteStore) Webhooks(accountId string) ([]webhook, error) {
+	query := "SELECT id, url, events FROM webhook WHERE account_id = ?1 ORDER BY created"
+
+	rows, err := s.db.Query(query, accountId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hooks []webhook
+	for rows.Next() {
+		var h webhook
+		var events string
+		if err := rows.Scan(&h.Id, &h.URL, &events); err != nil {
+			return nil, err
+		}
+		h.Events = strings.Split(events, ",")
+		hooks = append(hooks, h)
+	}
+	return hooks, rows.Err()
+}
+
+// sqliteHasEvent is the condition of the webhooks subscribed to the event
+// of the parameter, like = ANY(events) in Postgres.
+func sqliteHasEvent(param string) string {
+	return "instr(',' || events || ',', ',' || " + param + " || ',') > 0"
+}
+
+func (s sqliteStore) WebhookAccounts(event string) ([]string, error) {
+	rows, err := s.db.Query("SELECT DISTINCT account_id FROM webhook WHERE "+sqliteHasEvent("?1"), event)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var accounts []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, id)
+	}
+	return accounts, rows.Err()
+}
+
+func (s sqliteStore) QueueWebhookEvent(accountId, event, key, payload string) error {
+	query := `INSERT INTO webhook_delivery (webhook_id, event, event_key, payload)
+                  SELECT id, ?2, NULLIF(?3, ''), ?4 FROM webhook
+                  WHERE account_id = ?1 AND ` + sqliteHasEvent("?2") + `
+                  ON CONFLICT (webhook_id, event_key) DO NOTHING`
+	_, err := s.db.Exec(query, accountId, event, key, payload)
+	return err
+}
+
+func (s sqliteStore) WebhookDeliveries(accountId string) ([]webhookDelivery, error) {
+	query := `SELECT d.id, w.url, d.event, d.status, d.attempts, d.next_attempt,
+                         d.response_status, d.error, d.created, d.delivered
+                  FROM webhook_delivery d JOIN webhook w ON w.id = d.webhook_id
+                  WHERE w.account_id = ?1
+                  ORDER BY d.created DESC
+                  LIMIT ` + strconv.Itoa(maxWebhookDeliveries)
+
+	rows, err := s.db.Query(query, accountId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []webhookDelivery
+	for rows.Next() {
+		var d webhookDelivery
+		err := rows.Scan(&d.Id, &d.URL, &d.Event, &d.Status, &d.Attempts, &d.NextAttempt,
+			&d.ResponseStatus, &d.Error, &d.Created, &d.Delivered)
+		if err != nil {
+			return nil, err
+		}
+		d.NextAttempt, d.Created, d.Delivered = sqliteLocal(d.NextAttempt), sqliteLocal(d.Created), sqliteLocalPtr(d.Delivered)
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// ClaimWebhookDeliveries moves the next attempt of the deliveries out of
+// the way of the next run while they are posted.
+func (s sqliteStore) ClaimWebhookDeliveries(now, until time.Time) ([]pendingDelivery, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `SELECT d.id, w.url, w.secret, d.event, d.payload, d.attempts
+                  FROM webhook_delivery d JOIN webhook w ON w.id = d.webhook_id
+                  WHERE d.status = 'pending' AND d.next_attempt <= ?1
+                  ORDER BY d.next_attempt
+                  LIMIT ` + strconv.Itoa(maxClaimedDeliveries)
+	rows, err := tx.Query(query, sqliteTime(now))
+	if err != nil {
+		return nil, err
+	}
+	var pending []pendingDelivery
+	for rows.Next() {
+		var d pendingDelivery
+		if err := rows.Scan(&d.id, &d.url, &d.secret, &d.event, &d.payload, &d.attempts); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		pending = append(pending, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, d := range pending {
+		if _, err := tx.Exec("UPDATE webhook_delivery SET next_attempt = ?2 WHERE id = ?1", d.id, sqliteTime(until)); err != nil {
+			return nil, err
+		}
+	}
+	return pending, tx.Commit()
+}
+
+func (s sqliteStore) FinishWebhookDelivery(d webhookDelivery) error {
+	var delivered interface{}
+	if d.Delivered != nil {
+		delivered = sqliteTime(*d.Delivered)
+	}
+
+	query := `UPDATE webhook_delivery
+                  SET status = ?2, attempts = ?3, next_attempt = ?4, response_status = ?5, error = ?6, delivered = ?7
+                  WHERE id = ?1`
+	_, err := s.db.Exec(query, d.Id, d.Status, d.Attempts, sqliteTime(d.NextAttempt), d.ResponseStatus, d.Error, delivered)
+	return err
+}
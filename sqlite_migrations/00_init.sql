@@ -0,0 +1,84 @@
+-- SQLite has the tables of sqliteStore only, the features needing other
+-- tables run on Postgres. IDs are random UUIDs like uuid_generate_v4()
+-- makes, timestamps are UTC text like 2016-01-13 18:30:00.000 and tags
+-- are comma separated.
+
+CREATE TABLE account (
+       id text NOT NULL PRIMARY KEY DEFAULT (lower(hex(randomblob(4)) || '-' || hex(randomblob(2)) || '-4' ||
+           substr(hex(randomblob(2)), 2) || '-' || substr('89ab', 1 + abs(random()) % 4, 1) ||
+           substr(hex(randomblob(2)), 2) || '-' || hex(randomblob(6)))),
+       email text NOT NULL UNIQUE,
+       password text NOT NULL,
+       created timestamp NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now', 'localtime')),
+       modified timestamp NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now', 'localtime'))
+);
+
+CREATE TABLE habit (
+       id text NOT NULL PRIMARY KEY DEFAULT (lower(hex(randomblob(4)) || '-' || hex(randomblob(2)) || '-4' ||
+           substr(hex(randomblob(2)), 2) || '-' || substr('89ab', 1 + abs(random()) % 4, 1) ||
+           substr(hex(randomblob(2)), 2) || '-' || hex(randomblob(6)))),
+       account_id text NOT NULL REFERENCES account (id),
+       description text NOT NULL,
+       kind text NOT NULL DEFAULT 'target' CHECK (kind IN ('target', 'limit')),
+       points real NOT NULL,
+       unit text NOT NULL DEFAULT '',
+       period text NOT NULL CHECK (period IN ('day', 'week', 'month')),
+       start date NOT NULL,
+       -- weekdays is a bit mask, bit 0 is Sunday and bit 6 is Saturday
+       schedule text NOT NULL DEFAULT 'period' CHECK (schedule IN ('period', 'weekdays', 'interval')),
+       weekdays integer NOT NULL DEFAULT 0,
+       every_days integer NOT NULL DEFAULT 0,
+       category text NOT NULL DEFAULT '',
+       tags text NOT NULL DEFAULT '',
+       position integer NOT NULL DEFAULT 0,
+       retired timestamp,
+       created timestamp NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now', 'localtime')),
+       modified timestamp NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now', 'localtime'))
+);
+
+CREATE TABLE habit_progress (
+       id text NOT NULL PRIMARY KEY DEFAULT (lower(hex(randomblob(4)) || '-' || hex(randomblob(2)) || '-4' ||
+           substr(hex(randomblob(2)), 2) || '-' || substr('89ab', 1 + abs(random()) % 4, 1) ||
+           substr(hex(randomblob(2)), 2) || '-' || hex(randomblob(6)))),
+       habit_id text NOT NULL REFERENCES habit (id),
+       delta real NOT NULL,
+       note text NOT NULL DEFAULT '',
+       tags text NOT NULL DEFAULT '',
+       created timestamp NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now', 'localtime'))
+);
+
+CREATE INDEX habit_progress_habit ON habit_progress (habit_id, created);
+
+CREATE TABLE habit_pause (
+       id text NOT NULL PRIMARY KEY DEFAULT (lower(hex(randomblob(4)) || '-' || hex(randomblob(2)) || '-4' ||
+           substr(hex(randomblob(2)), 2) || '-' || substr('89ab', 1 + abs(random()) % 4, 1) ||
+           substr(hex(randomblob(2)), 2) || '-' || hex(randomblob(6)))),
+       habit_id text NOT NULL REFERENCES habit (id),
+       start date NOT NULL,
+       finish date NOT NULL,
+       reason text NOT NULL DEFAULT '',
+       created timestamp NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now', 'localtime')),
+       CHECK (finish >= start)
+);
+
+CREATE TABLE goal (
+       id text NOT NULL PRIMARY KEY DEFAULT (lower(hex(randomblob(4)) || '-' || hex(randomblob(2)) || '-4' ||
+           substr(hex(randomblob(2)), 2) || '-' || substr('89ab', 1 + abs(random()) % 4, 1) ||
+           substr(hex(randomblob(2)), 2) || '-' || hex(randomblob(6)))),
+       account_id text NOT NULL REFERENCES account (id),
+       description text NOT NULL,
+       points_done integer NOT NULL DEFAULT 0,
+       points_total integer NOT NULL,
+       position integer NOT NULL DEFAULT 0,
+       created timestamp NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now', 'localtime')),
+       modified timestamp NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now', 'localtime'))
+);
+
+CREATE TABLE goal_progress (
+       id text NOT NULL PRIMARY KEY DEFAULT (lower(hex(randomblob(4)) || '-' || hex(randomblob(2)) || '-4' ||
+           substr(hex(randomblob(2)), 2) || '-' || substr('89ab', 1 + abs(random()) % 4, 1) ||
+           substr(hex(randomblob(2)), 2) || '-' || hex(randomblob(6)))),
+       goal_id text NOT NULL REFERENCES goal (id),
+       delta integer NOT NULL,
+       created timestamp NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now', 'localtime'))
+);
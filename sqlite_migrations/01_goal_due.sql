@@ -0,0 +1,2 @@
+-- the optional date a goal should be done by
+ALTER TABLE goal ADD COLUMN due date;
@@ -0,0 +1,4 @@
+-- ID the offline queue of the browser gives an entry, so syncing it again
+-- doesn't log it twice
+ALTER TABLE habit_progress ADD COLUMN client_id text;
+CREATE UNIQUE INDEX habit_progress_client_id ON habit_progress (habit_id, client_id);
@@ -0,0 +1,12 @@
+-- Idempotency-Key headers of progress requests with the response to replay
+-- when the request is retried, code is NULL while it's being handled
+CREATE TABLE idempotency_key (
+       account_id text NOT NULL REFERENCES account (id) ON DELETE CASCADE,
+       key text NOT NULL,
+       request text NOT NULL,
+       code integer,
+       content_type text,
+       body blob,
+       created timestamp NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now', 'localtime')),
+       PRIMARY KEY (account_id, key)
+);
@@ -0,0 +1,4 @@
+-- the period of the summary mails of the account and the end of the last
+-- period one was sent for
+ALTER TABLE account ADD COLUMN summary text CHECK (summary IN ('day', 'week', 'month'));
+ALTER TABLE account ADD COLUMN summary_sent timestamp;
@@ -0,0 +1,26 @@
+-- at is the time of day as text like 18:30, so it can be compared as text
+CREATE TABLE habit_reminder (
+       id text NOT NULL PRIMARY KEY DEFAULT (lower(hex(randomblob(4)) || '-' || hex(randomblob(2)) || '-4' ||
+           substr(hex(randomblob(2)), 2) || '-' || substr('89ab', 1 + abs(random()) % 4, 1) ||
+           substr(hex(randomblob(2)), 2) || '-' || hex(randomblob(6)))),
+       habit_id text NOT NULL REFERENCES habit (id),
+       at text NOT NULL,
+       only_behind boolean NOT NULL DEFAULT false,
+       channel text NOT NULL CHECK (channel IN ('email', 'webhook')),
+       webhook_url text NOT NULL DEFAULT '',
+       created timestamp NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now', 'localtime'))
+);
+
+-- one delivery per reminder and day, which keeps reminders from being sent
+-- twice
+CREATE TABLE reminder_delivery (
+       id text NOT NULL PRIMARY KEY DEFAULT (lower(hex(randomblob(4)) || '-' || hex(randomblob(2)) || '-4' ||
+           substr(hex(randomblob(2)), 2) || '-' || substr('89ab', 1 + abs(random()) % 4, 1) ||
+           substr(hex(randomblob(2)), 2) || '-' || hex(randomblob(6)))),
+       reminder_id text NOT NULL REFERENCES habit_reminder (id) ON DELETE CASCADE,
+       day date NOT NULL,
+       status text NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'sent', 'skipped', 'failed')),
+       error text NOT NULL DEFAULT '',
+       created timestamp NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now', 'localtime')),
+       UNIQUE (reminder_id, day)
+);
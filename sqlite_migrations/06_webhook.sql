@@ -0,0 +1,33 @@
+-- events are comma separated like tags
+CREATE TABLE webhook (
+       id text NOT NULL PRIMARY KEY DEFAULT (lower(hex(randomblob(4)) || '-' || hex(randomblob(2)) || '-4' ||
+           substr(hex(randomblob(2)), 2) || '-' || substr('89ab', 1 + abs(random()) % 4, 1) ||
+           substr(hex(randomblob(2)), 2) || '-' || hex(randomblob(6)))),
+       account_id text NOT NULL REFERENCES account (id),
+       url text NOT NULL,
+       secret text NOT NULL,
+       events text NOT NULL,
+       created timestamp NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now', 'localtime'))
+);
+
+-- the queue of events to post to the webhooks, kept as their log
+CREATE TABLE webhook_delivery (
+       id text NOT NULL PRIMARY KEY DEFAULT (lower(hex(randomblob(4)) || '-' || hex(randomblob(2)) || '-4' ||
+           substr(hex(randomblob(2)), 2) || '-' || substr('89ab', 1 + abs(random()) % 4, 1) ||
+           substr(hex(randomblob(2)), 2) || '-' || hex(randomblob(6)))),
+       webhook_id text NOT NULL REFERENCES webhook (id) ON DELETE CASCADE,
+       event text NOT NULL,
+       -- events with a key are queued once per webhook
+       event_key text,
+       payload text NOT NULL,
+       status text NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'sent', 'failed')),
+       attempts integer NOT NULL DEFAULT 0,
+       next_attempt timestamp NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now', 'localtime')),
+       response_status integer NOT NULL DEFAULT 0,
+       error text NOT NULL DEFAULT '',
+       created timestamp NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now', 'localtime')),
+       delivered timestamp,
+       UNIQUE (webhook_id, event_key)
+);
+
+CREATE INDEX webhook_delivery_pending ON webhook_delivery (next_attempt) WHERE status = 'pending';
@@ -0,0 +1,18 @@
+-- secret tokens for logging progress on a habit through /hooks/{token}
+CREATE TABLE habit_token (
+       id text NOT NULL PRIMARY KEY DEFAULT (lower(hex(randomblob(4)) || '-' || hex(randomblob(2)) || '-4' ||
+           substr(hex(randomblob(2)), 2) || '-' || substr('89ab', 1 + abs(random()) % 4, 1) ||
+           substr(hex(randomblob(2)), 2) || '-' || hex(randomblob(6)))),
+       habit_id text NOT NULL REFERENCES habit (id),
+       token text NOT NULL UNIQUE,
+       created timestamp NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now', 'localtime')),
+       revoked timestamp
+);
+
+-- request IDs seen through a token, so retried requests are logged once
+CREATE TABLE habit_token_request (
+       token_id text NOT NULL REFERENCES habit_token (id) ON DELETE CASCADE,
+       request_id text NOT NULL,
+       created timestamp NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now', 'localtime')),
+       PRIMARY KEY (token_id, request_id)
+);
@@ -0,0 +1,4 @@
+-- secret token of the account's calendar feed, /calendar/{token}.ics,
+-- unique through the index as SQLite can't add a UNIQUE column
+ALTER TABLE account ADD COLUMN calendar_token text;
+CREATE UNIQUE INDEX account_calendar_token ON account (calendar_token);
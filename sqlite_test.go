@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSQLiteStoreLocalTime(t *testing.T) {
+	if _, ok := storage.(sqliteStore); !ok {
+		t.Skip("no SQLite test database")
+	}
+	local := time.Local
+	defer func() { time.Local = local }()
+	time.Local = time.FixedZone("UTC-11", -11*60*60)
+	account, _ := CreateAccount(emailForTests, passwordForTests)
+	defer truncateDatabase()
+
+	h := newHabit("Run", 1, PeriodDay, date(2017, time.February, 27))
+	h.Schedule = ScheduleInterval
+	h.EveryDays = 1
+	h.PerOccurrence = 1
+	id, _ := createHabit(h, account.Id)
+
+	// late in the evening, the next day in UTC
+	created := time.Date(2017, time.March, 1, 23, 30, 0, 0, time.Local)
+	if err := storage.CreateProgressEntries(account.Id, []progressEntry{{HabitId: *id, Delta: 1, Created: created}}); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, _ := storage.ProgressEntries(*id, account.Id)
+	if len(entries) != 1 || !entries[0].Created.Equal(created) {
+		t.Errorf("Expected the entry logged at %v, got %+v", created, entries)
+	}
+	daily, _ := storage.DailyProgress(account.Id)
+	if daily[*id]["2017-03-01"] != 1 {
+		t.Errorf("Expected the progress on 2017-03-01, got %v", daily[*id])
+	}
+}
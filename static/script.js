@@ -22,12 +22,12 @@ events.subscribe = function (topic, listener) {
     events.listeners[topic].push(listener);
 };
 
-function ajax(method, path, successCallback, errorCallback) {
+function ajax(method, path, body, successCallback, errorCallback) {
     var req = new XMLHttpRequest();
 
     req.onreadystatechange = function() {
         if (req.readyState == XMLHttpRequest.DONE) {
-            if (req.status == 200) {
+            if (req.status >= 200 && req.status < 300) {
                 successCallback(req.responseText);
             } else {
                 errorCallback(req.status);
@@ -36,7 +36,10 @@ function ajax(method, path, successCallback, errorCallback) {
     }
 
     req.open(method, path, true);
-    req.send();
+    if (body) {
+        req.setRequestHeader("Content-Type", "application/x-www-form-urlencoded");
+    }
+    req.send(body);
 }
 
 // end of library code (TODO separate)
@@ -45,38 +48,63 @@ events.subscribe('progressUpdated', function (uuid, progress) {
     // update percentage
     var e = document.getElementById("done-" + uuid);
     e.style.width = progress.PctDone + "%";
+    e.className = progress.Over ? "over" : "";
 });
 
 events.subscribe('progressUpdated', function (uuid, progress) {
     // update "done / todo"
     var e = document.getElementById("pct-done-" + uuid);
-    e.title = progress.Done + " / " + progress.Todo;
+    e.title = progress.DoneText + " / " + progress.TodoText;
 });
 
-events.subscribe('progressUpdated', function () {
-    // update weekly done
-    var eDone = document.getElementById("this-week-done"),
-        eTotal = document.getElementById("this-week-total"),
-        weekDone = parseInt(eDone.innerHTML, 10),
-        weekTotal = parseInt(eTotal.innerHTML, 10);
+// addToTotal adds delta to a "done / total" pair and its progress bar
+function addToTotal(eDone, eTotal, eBar, delta) {
+    var done = parseFloat(eDone.innerHTML) + delta,
+        total = parseFloat(eTotal.innerHTML);
+
+    done = Math.round(done * 100) / 100;
+    eDone.innerHTML = done;
+    eBar.style.width = (done / total * 100) + "%";
+}
+
+events.subscribe('progressUpdated', function (uuid, progress) {
+    // paused habits don't count towards the totals
+    if (progress.Paused) {
+        return;
+    }
 
-    weekDone += 1;
+    // update weekly and category done, runs before the habit's own done is
+    // updated below as scheduled habits don't necessarily go up by one and
+    // limits count by whether they were exceeded
+    var eHabitDone = document.getElementById("points-done-" + uuid),
+        delta = progress.Score - parseFloat(eHabitDone.getAttribute("data-score"));
 
-    eDone.innerHTML = weekDone;
+    addToTotal(document.getElementById("this-week-done"),
+               document.getElementById("this-week-total"),
+               document.getElementById("done-week"),
+               delta);
 
-    // update weekly percentage
-    var e = document.getElementById("done-week");
-    e.style.width = (weekDone / weekTotal * 100) + "%";
+    var category = eHabitDone.parentNode;
+    while (category && category.tagName != "TBODY") {
+        category = category.parentNode;
+    }
+    if (category && category.querySelector(".category-done")) {
+        addToTotal(category.querySelector(".category-done"),
+                   category.querySelector(".category-total"),
+                   category.querySelector(".category-bar"),
+                   delta);
+    }
 });
 
 events.subscribe('progressUpdated', function (uuid, progress) {
     // update habit done
     var e = document.getElementById("points-done-" + uuid);
-    e.innerHTML = progress.Done;
+    e.innerHTML = progress.DoneText;
+    e.setAttribute("data-score", progress.Score);
 });
 
 function updateActivityProgress(uuid) {
-    ajax("POST", "/goals/" + uuid, function (body) {
+    ajax("POST", "/goals/" + uuid, null, function (body) {
         var progress = document.getElementById("done-" + uuid);
         progress.style.width = body + "%";
     }, function (statusCode, body) {
@@ -86,12 +114,158 @@ function updateActivityProgress(uuid) {
     return false;
 }
 
-function updateHabitProgress(uuid) {
-    ajax("POST", "/habits/" + uuid, function (response) {
-        events.publish('progressUpdated', [uuid, JSON.parse(response)]);
+// updateHabitProgress logs progress on a habit, body is a form encoded
+// amount, note and tags or null to add one.
+function updateHabitProgress(uuid, body) {
+    ajax("POST", "/habits/" + uuid, body, function (response) {
+        var progress = JSON.parse(response);
+        if (progress.queued) {
+            // offline, the service worker syncs it later
+            markQueued(uuid);
+        } else {
+            events.publish('progressUpdated', [uuid, progress]);
+        }
     }, function (statusCode, body) {
         console.log("fail", statusCode, body);
     });
 
     return false;
 }
+
+// markQueued counts the progress queued offline next to the habit's done
+function markQueued(uuid) {
+    var e = document.getElementById("points-done-" + uuid);
+    if (e) {
+        e.setAttribute("data-queued", parseInt(e.getAttribute("data-queued") || "0", 10) + 1);
+    }
+}
+
+function logHabitAmount(uuid, form) {
+    var fields = ["amount", "note", "tags"],
+        params = [];
+
+    for (var i = 0; i < fields.length; i++) {
+        params.push(fields[i] + "=" + encodeURIComponent(form[fields[i]].value));
+    }
+    updateHabitProgress(uuid, params.join("&"));
+    form.reset();
+
+    return false;
+}
+
+// rowWithId returns the table row with a data-id containing e, if any
+function rowWithId(e) {
+    while (e && !(e.tagName == "TR" && e.getAttribute("data-id"))) {
+        e = e.parentNode;
+    }
+    return e;
+}
+
+// sortable lets the rows of the table be reordered by dragging them within
+// their table body. The IDs of the rows of every table reordered through
+// the same path are then posted to it in their new order.
+function sortable(table, path) {
+    var dragged = null;
+
+    table.addEventListener("dragstart", function (e) {
+        dragged = rowWithId(e.target);
+        if (dragged) {
+            e.dataTransfer.effectAllowed = "move";
+            e.dataTransfer.setData("text/plain", dragged.getAttribute("data-id"));
+        }
+    });
+
+    table.addEventListener("dragover", function (e) {
+        var row = rowWithId(e.target);
+        if (!dragged || !row || row === dragged || row.parentNode !== dragged.parentNode) {
+            return;
+        }
+        e.preventDefault();
+
+        var rect = row.getBoundingClientRect(),
+            after = e.clientY > rect.top + rect.height / 2;
+        row.parentNode.insertBefore(dragged, after ? row.nextSibling : row);
+    });
+
+    table.addEventListener("drop", function (e) {
+        e.preventDefault();
+    });
+
+    table.addEventListener("dragend", function () {
+        if (!dragged) {
+            return;
+        }
+        dragged = null;
+
+        var rows = document.querySelectorAll("table[data-reorder='" + path + "'] tr[data-id]"),
+            params = [];
+        for (var i = 0; i < rows.length; i++) {
+            params.push("id=" + encodeURIComponent(rows[i].getAttribute("data-id")));
+        }
+        ajax("POST", path, params.join("&"), function () {}, function (statusCode, body) {
+            console.log("fail", statusCode, body);
+        });
+    });
+}
+
+document.addEventListener("DOMContentLoaded", function () {
+    var tables = document.querySelectorAll("table[data-reorder]");
+    for (var i = 0; i < tables.length; i++) {
+        sortable(tables[i], tables[i].getAttribute("data-reorder"));
+    }
+});
+
+// listen for progress logged in other tabs and on other devices, applying
+// a change twice is harmless as totals go by the score shown for the habit
+document.addEventListener("DOMContentLoaded", function () {
+    if (!window.EventSource || !document.querySelector("[id^='done-']")) {
+        return;
+    }
+    var source = new EventSource("/events");
+
+    source.addEventListener("habit", function (e) {
+        var progress = JSON.parse(e.data);
+        if (document.getElementById("points-done-" + progress.Id)) {
+            events.publish('progressUpdated', [progress.Id, progress]);
+        }
+    });
+
+    source.addEventListener("goal", function (e) {
+        var goal = JSON.parse(e.data),
+            bar = document.getElementById("done-" + goal.Id);
+        if (bar) {
+            bar.style.width = goal.PctDone + "%";
+            bar.parentNode.parentNode.title = goal.PointsDone + " / " + goal.PointsTotal;
+        }
+    });
+
+    source.addEventListener("resync", function () {
+        window.location.reload();
+    });
+});
+
+// progress logged offline is queued by the service worker, which sends it
+// when the connection is back and tells the page how the habits are then
+if ("serviceWorker" in navigator) {
+    navigator.serviceWorker.register("/sw.js");
+
+    navigator.serviceWorker.addEventListener("message", function (e) {
+        if (e.data.type != "synced") {
+            return;
+        }
+        for (var i = 0; i < e.data.habits.length; i++) {
+            var progress = e.data.habits[i],
+                done = document.getElementById("points-done-" + progress.Id);
+            if (done) {
+                done.removeAttribute("data-queued");
+                events.publish('progressUpdated', [progress.Id, progress]);
+            }
+        }
+    });
+
+    window.addEventListener("online", function () {
+        if (navigator.serviceWorker.controller) {
+            navigator.serviceWorker.controller.postMessage("flush");
+        }
+    });
+}
@@ -0,0 +1,214 @@
+// The service worker keeps HabitCat usable without a connection: pages and
+// static files come from the cache when the network is down, and progress
+// logged meanwhile is queued and sent to /sync once it's back.
+
+var CACHE = "habitcat-v1",
+    STATIC_FILES = ["/static/script.js", "/static/style.css"],
+    QUEUE_DB = "habitcat-queue",
+    MAX_SYNC_EVENTS = 100;
+
+self.addEventListener("install", function (e) {
+    e.waitUntil(caches.open(CACHE).then(function (cache) {
+        return cache.addAll(STATIC_FILES);
+    }).then(function () {
+        return self.skipWaiting();
+    }));
+});
+
+self.addEventListener("activate", function (e) {
+    e.waitUntil(caches.keys().then(function (names) {
+        return Promise.all(names.filter(function (name) {
+            return name != CACHE;
+        }).map(function (name) {
+            return caches.delete(name);
+        }));
+    }).then(function () {
+        return self.clients.claim();
+    }));
+});
+
+self.addEventListener("fetch", function (e) {
+    var url = new URL(e.request.url);
+    if (url.origin != self.location.origin) {
+        return;
+    }
+
+    if (e.request.method == "POST" && /^\/habits\/[0-9a-f-]{36}$/.test(url.pathname)) {
+        e.respondWith(logProgress(e.request, url.pathname.substring("/habits/".length)));
+    } else if (e.request.method == "GET" && (e.request.mode == "navigate" || url.pathname.indexOf("/static/") == 0)) {
+        e.respondWith(networkFirst(e.request));
+    }
+});
+
+self.addEventListener("sync", function (e) {
+    if (e.tag == "progress") {
+        e.waitUntil(flush());
+    }
+});
+
+self.addEventListener("message", function (e) {
+    if (e.data == "flush") {
+        e.waitUntil(flush());
+    }
+});
+
+// networkFirst serves the response of the network and keeps a copy of it,
+// or the copy when offline. Redirects, e.g. to the login page, aren't kept.
+function networkFirst(request) {
+    return fetch(request).then(function (response) {
+        if (response.ok && !response.redirected) {
+            var copy = response.clone();
+            caches.open(CACHE).then(function (cache) {
+                cache.put(request, copy);
+            });
+        }
+        return response;
+    }, function (err) {
+        return caches.match(request).then(function (cached) {
+            if (!cached) {
+                throw err;
+            }
+            return cached;
+        });
+    });
+}
+
+// logProgress posts the progress, queuing it if the network is down. The
+// page then gets {"queued": true} instead of the habit. The post and the
+// queued event share an ID, sent as the Idempotency-Key, so progress whose
+// post reached the server before the connection dropped isn't logged twice.
+function logProgress(request, habitId) {
+    var id = request.headers.get("Idempotency-Key") || newId();
+
+    return request.text().then(function (text) {
+        var headers = new Headers(request.headers);
+        headers.set("Idempotency-Key", id);
+        return fetch(request.url, {
+            method: "POST",
+            credentials: "same-origin",
+            headers: headers,
+            body: text
+        }).then(function (response) {
+            // a connection is back, send what was queued before
+            flush().catch(function () {});
+            return response;
+        }, function () {
+            var form = new URLSearchParams(text),
+                event = {
+                    id: id,
+                    habit_id: habitId,
+                    note: form.get("note") || "",
+                    tags: form.get("tags") ? [form.get("tags")] : [],
+                    created: new Date().toISOString()
+                };
+            if (form.get("amount")) {
+                event.amount = parseFloat(form.get("amount"));
+            }
+            return queue("readwrite", function (store) {
+                store.add(event);
+            }).then(function () {
+                if (self.registration.sync) {
+                    self.registration.sync.register("progress").catch(function () {});
+                }
+                return new Response(JSON.stringify({queued: true}), {
+                    status: 202,
+                    headers: {"Content-Type": "application/json"}
+                });
+            });
+        });
+    });
+}
+
+// flush sends the queued progress to /sync and drops what the server
+// handled. The pages are sent the habits as they are after it.
+var flushing = null;
+
+function flush() {
+    if (!flushing) {
+        flushing = sendQueued().then(function () {
+            flushing = null;
+        }, function (err) {
+            flushing = null;
+            throw err;
+        });
+    }
+    return flushing;
+}
+
+function sendQueued() {
+    return queue("readonly", function (store, result) {
+        store.getAll(null, MAX_SYNC_EVENTS).onsuccess = function (e) {
+            result.events = e.target.result;
+        };
+    }).then(function (result) {
+        if (result.events.length == 0) {
+            return;
+        }
+        return fetch("/sync", {
+            method: "POST",
+            credentials: "same-origin",
+            headers: {"Content-Type": "application/json", "Accept": "application/json"},
+            body: JSON.stringify({events: result.events})
+        }).then(function (response) {
+            if (!response.ok || response.redirected) {
+                // logged out or the server failed, try again later
+                throw new Error("sync failed: " + response.status);
+            }
+            return response.json();
+        }).then(function (synced) {
+            var seqs = {};
+            result.events.forEach(function (event) {
+                seqs[event.id] = event.seq;
+            });
+            return queue("readwrite", function (store) {
+                synced.events.forEach(function (event) {
+                    store.delete(seqs[event.id]);
+                });
+            }).then(function () {
+                return self.clients.matchAll();
+            }).then(function (clients) {
+                clients.forEach(function (client) {
+                    client.postMessage({type: "synced", habits: synced.habits});
+                });
+                if (result.events.length == MAX_SYNC_EVENTS) {
+                    return sendQueued();
+                }
+            });
+        });
+    });
+}
+
+// queue runs fn in a transaction of the queued events, oldest first. The
+// returned promise resolves with the result fn filled in once it's done.
+function queue(mode, fn) {
+    return new Promise(function (resolve, reject) {
+        var req = indexedDB.open(QUEUE_DB, 1);
+        req.onupgradeneeded = function () {
+            req.result.createObjectStore("events", {keyPath: "seq", autoIncrement: true});
+        };
+        req.onerror = function () {
+            reject(req.error);
+        };
+        req.onsuccess = function () {
+            var db = req.result,
+                tx = db.transaction("events", mode),
+                result = {};
+            fn(tx.objectStore("events"), result);
+            tx.oncomplete = function () {
+                db.close();
+                resolve(result);
+            };
+            tx.onerror = tx.onabort = function () {
+                db.close();
+                reject(tx.error);
+            };
+        };
+    });
+}
+
+function newId() {
+    if (self.crypto && self.crypto.randomUUID) {
+        return self.crypto.randomUUID();
+    }
+    return Date.now().toString(36) + "-" + Math.random().toString(36).substring(2);
+}
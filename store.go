@@ -0,0 +1,177 @@
+package main
+
+import "time"
+
+// store keeps the accounts and their settings, their habits with the
+// progress logged on them, their reminders and tokens, their goals and
+// their webhooks. The app runs on postgresStore or, for a single user, on
+// sqliteStore, memoryStore lets the handlers be tested without a database.
+//
+// Methods taking an account ID only see that account's data and return
+// errHabitNotFound, errPauseNotFound, errGoalNotFound, errTokenNotFound,
+// errReminderNotFound or errWebhookNotFound when the habit, pause, goal,
+// token, reminder or webhook doesn't exist or belongs to another account.
+type store interface {
+	// CreateAccount returns the ID of the new account.
+	CreateAccount(email string, hashedPassword []byte) (string, error)
+	Account(email string) (*Account, error)
+
+	// Habits returns the active habits of the account in the order the
+	// user gave them, with the progress of their current period.
+	Habits(accountId string) ([]habit, error)
+	Habit(id, accountId string) (*habit, error)
+	// AllHabits returns every habit of the account, the retired ones too,
+	// in the order the user gave them.
+	AllHabits(accountId string) ([]habit, error)
+	// CreateHabit creates the habit retired when its Retired is set.
+	CreateHabit(h *habit, accountId string) (string, error)
+	// HabitHistories returns the history of the active habits of the
+	// account keyed by habit ID, oldest period first.
+	HabitHistories(accountId string) (map[string][]periodProgress, error)
+	HabitHistory(id, accountId string) ([]periodProgress, error)
+	// DailyProgress returns the progress per day of the scheduled habits of
+	// the account, keyed by habit ID and then by date.
+	DailyProgress(accountId string) (map[string]map[string]float64, error)
+
+	// HabitPauses returns the pauses of the habits of the account keyed by
+	// habit ID, earliest first.
+	HabitPauses(accountId string) (map[string][]habitPause, error)
+	CreateHabitPause(habitId, accountId string, p *habitPause) (string, error)
+	// DeleteHabitPause returns the ID of the habit of the deleted pause.
+	DeleteHabitPause(id, accountId string) (string, error)
+
+	CreateProgressEntry(habitId string, entry *progressEntry) error
+	// CreateProgressEntries logs the entries on their habits at the time
+	// they were created, all of them or none. It returns errHabitNotFound
+	// if one of the habits isn't an active habit of the account.
+	CreateProgressEntries(accountId string, entries []progressEntry) error
+	// SyncProgressEntry logs the entry on its habit at the time it was
+	// created unless an entry with the same client ID was logged on the
+	// habit before. It reports whether the entry was logged.
+	SyncProgressEntry(accountId, clientId string, entry *progressEntry) (bool, error)
+	// ProgressEntries returns the latest progress entries of the habit,
+	// newest first.
+	ProgressEntries(habitId, accountId string) ([]progressEntry, error)
+	// SearchProgressEntries returns the latest progress entries of the
+	// account whose note contains q, case insensitively, and that are tagged
+	// with tag. Empty q or tag match every entry.
+	SearchProgressEntries(accountId, q, tag string) ([]progressEntry, error)
+	// EachProgressEntry calls fn with every progress entry of the habits of
+	// the account logged on the day from or later, the retired ones too,
+	// oldest first, reading them as it goes rather than loading them all.
+	// The zero from is for all of them. It stops at the first error fn
+	// returns.
+	EachProgressEntry(accountId string, from time.Time, fn func(e progressEntry) error) error
+
+	// Goals returns the goals of the account in the order the user gave
+	// them.
+	Goals(accountId string) ([]goal, error)
+	CreateGoal(g *goal, accountId string) (string, error)
+	// AddGoalPoint marks one more point of the goal done and returns the
+	// updated goal.
+	AddGoalPoint(id, accountId string) (*goal, error)
+	// GoalSummaries returns the goals of the account that got points from
+	// start until end, with how many they got.
+	GoalSummaries(accountId string, start, end time.Time) ([]goalSummary, error)
+
+	// ReorderHabits and ReorderGoals put the listed habits or goals of the
+	// account in the given order, see reorderIds. They return
+	// errHabitNotFound or errGoalNotFound if one of them isn't the
+	// account's.
+	ReorderHabits(accountId string, ids []string) error
+	ReorderGoals(accountId string, ids []string) error
+
+	Settings(accountId string) (*settings, error)
+	// UpdateSettings saves the settings, with the summaries counted as sent
+	// up to summarySent. It's zero when the account doesn't want any.
+	UpdateSettings(accountId string, s *settings, summarySent time.Time) error
+	// ClaimSummaries marks the accounts due for a summary of the period as
+	// sent up to end and returns them.
+	ClaimSummaries(p Period, end time.Time) ([]Account, error)
+
+	// CalendarToken returns the token of the account's calendar feed,
+	// empty if it has none yet.
+	CalendarToken(accountId string) (string, error)
+	SetCalendarToken(accountId, token string) error
+	// CalendarAccount returns the ID of the account of the feed token, or
+	// errCalendarNotFound.
+	CalendarAccount(token string) (string, error)
+
+	// CreateHabitToken saves the token of the habit, setting its ID and
+	// when it was created.
+	CreateHabitToken(habitId, accountId string, t *habitToken) error
+	// RevokeHabitToken returns the ID of the habit of the revoked token.
+	RevokeHabitToken(id, accountId string) (string, error)
+	// HabitTokens returns the tokens of the habit, revoked ones included.
+	HabitTokens(habitId, accountId string) ([]habitToken, error)
+	// HabitByToken returns the IDs of a token that isn't revoked, its
+	// active habit and its account, or errTokenNotFound.
+	HabitByToken(token string) (tokenId, habitId, accountId string, err error)
+	// CreateHookProgressEntry logs the entry on its habit once per request
+	// ID of the token, recording the ID along with the entry. It reports
+	// false if the request was logged before, and returns
+	// errRequestInProgress while another request with the ID is logged.
+	CreateHookProgressEntry(tokenId, requestId string, entry *progressEntry) (bool, error)
+
+	// CreateReminder returns the ID of the new reminder of the habit.
+	CreateReminder(habitId, accountId string, r *reminder) (string, error)
+	// DeleteReminder returns the ID of the habit of the deleted reminder,
+	// or errReminderNotFound.
+	DeleteReminder(id, accountId string) (string, error)
+	// Reminders returns the reminders of the habit, earliest first.
+	Reminders(habitId, accountId string) ([]reminder, error)
+	// ReminderDeliveries returns the latest deliveries of the reminders of
+	// the habit, newest first.
+	ReminderDeliveries(habitId, accountId string) ([]reminderDelivery, error)
+	// DueReminders returns the reminders of active habits due at the time
+	// of day at and not delivered on day, grouped by account. Deliveries
+	// still pending since before stale count as not delivered.
+	DueReminders(at string, day, stale time.Time) ([]dueReminder, error)
+	// ClaimReminderDelivery records a pending delivery of the reminder on
+	// day, or takes over one pending since before stale. It reports false
+	// if there already is one.
+	ClaimReminderDelivery(reminderId string, day, stale time.Time) (bool, error)
+	FinishReminderDelivery(reminderId string, day time.Time, status, msg string) error
+
+	// CreateWebhook saves the webhook, setting its ID.
+	CreateWebhook(accountId string, hook *webhook) error
+	// DeleteWebhook returns errWebhookNotFound if the webhook isn't the
+	// account's.
+	DeleteWebhook(id, accountId string) error
+	// Webhooks returns the webhooks of the account, oldest first, without
+	// their secrets.
+	Webhooks(accountId string) ([]webhook, error)
+	// WebhookAccounts returns the accounts with webhooks subscribed to the
+	// event.
+	WebhookAccounts(event string) ([]string, error)
+	// QueueWebhookEvent queues a delivery of the payload to every webhook
+	// of the account subscribed to the event. Events with a key are queued
+	// once per key and webhook.
+	QueueWebhookEvent(accountId, event, key, payload string) error
+	// WebhookDeliveries returns the latest deliveries to the webhooks of
+	// the account, newest first.
+	WebhookDeliveries(accountId string) ([]webhookDelivery, error)
+	// ClaimWebhookDeliveries returns the pending deliveries due at now and
+	// keeps them from other servers until until.
+	ClaimWebhookDeliveries(now, until time.Time) ([]pendingDelivery, error)
+	// FinishWebhookDelivery saves the status, attempts, next attempt,
+	// response status, error and delivery time of the delivery.
+	FinishWebhookDelivery(d webhookDelivery) error
+
+	// ClaimIdempotencyKey records the key of a request of the account,
+	// forgetting the account's keys recorded longer than retention ago. It
+	// returns nil if the key is new and what was kept of the first request
+	// with the key otherwise.
+	ClaimIdempotencyKey(accountId, key, request string, retention time.Duration) (*idempotentResponse, error)
+	SaveIdempotentResponse(accountId, key string, resp *idempotentResponse) error
+	// ReleaseIdempotencyKey forgets the key, so that the request can be
+	// retried.
+	ReleaseIdempotencyKey(accountId, key string) error
+
+	// Import creates the habits and goals of the plan and logs its progress
+	// entries, all of it or nothing.
+	Import(accountId string, p *importPlan) error
+}
+
+// storage is the store used by the handlers.
+var storage store
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"text/template"
+	"time"
+)
+
+// summary is the progress of an account during a finished week or month,
+// sent by email to the accounts that opted in.
+type summary struct {
+	Period Period
+	Start  time.Time
+	Habits []habitSummary
+	Goals  []goalSummary
+}
+
+// habitSummary adds up the periods of a habit that ended during the
+// summarized period, e.g. the seven days of a daily habit in a week.
+type habitSummary struct {
+	Description string
+	Period      Period
+	DoneText    string
+	TodoText    string
+	Completed   int
+	Total       int
+	Streak      int
+}
+
+type goalSummary struct {
+	Description string
+	Advanced    int
+	PointsDone  int
+	PointsTotal int
+}
+
+var summaryTemplate = template.Must(template.New("summary").Parse(
+	`Here is how your {{.Period}} {{if eq .Period "month"}}of {{.Start.Format "January 2006"}}{{else}}starting {{.Start.Format "Mon Jan 2"}}{{end}} went.
+{{with .Habits}}
+Habits:
+{{range .}}
+- {{.Description}}: {{.DoneText}} / {{.TodoText}}{{if gt .Total 1}}, {{.Completed}} of {{.Total}} {{.Period}}s done{{end}}{{if .Streak}}, streak of {{.Streak}}{{end}}{{end}}
+{{end}}{{with .Goals}}
+Goals:
+{{range .}}
+- {{.Description}}: +{{.Advanced}}, {{.PointsDone}} / {{.PointsTotal}}{{end}}
+{{end}}
+You get this email because you asked for it in your HabitCat settings.
+`))
+
+// sendSummaries sends the summaries of the week or month that ended before
+// now to the accounts that want them and didn't get them yet. Accounts are
+// marked as done before sending, so a failing mail is logged and not sent
+// twice.
+func sendSummaries(now time.Time) error {
+	for _, p := range validSummaries {
+		end := p.start(now)
+		accounts, err := storage.ClaimSummaries(p, end)
+		if err != nil {
+			return err
+		}
+		for _, a := range accounts {
+			s, err := buildSummary(a.Id, p, p.previous(end))
+			if err != nil {
+				log.Printf("summary for account=%v: %v", a.Id, err)
+				continue
+			}
+			if s == nil {
+				continue
+			}
+			m, err := s.message(a.Email)
+			if err == nil {
+				err = sender.Send(m)
+			}
+			if err != nil {
+				log.Printf("summary for account=%v: %v", a.Id, err)
+			}
+		}
+	}
+	return nil
+}
+
+// buildSummary sums up the period of the account starting at start. It
+// returns nil if there is nothing to tell.
+func buildSummary(accountId string, p Period, start time.Time) (*summary, error) {
+	end := p.next(start)
+	s := &summary{Period: p, Start: start}
+
+	habits, err := getHabits(accountId)
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range habits {
+		if hs, ok := h.summarize(start, end); ok {
+			s.Habits = append(s.Habits, hs)
+		}
+	}
+
+	s.Goals, err = storage.GoalSummaries(accountId, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(s.Habits) == 0 && len(s.Goals) == 0 {
+		return nil, nil
+	}
+	return s, nil
+}
+
+// summarize adds up the periods of the habit that ended between start and
+// end. Paused periods are left out. The streak is the one the habit had at
+// the end, not the one it has now.
+func (h habit) summarize(start, end time.Time) (habitSummary, bool) {
+	var done, todo float64
+	hs := habitSummary{Description: h.Description, Period: h.Period}
+	finished := 0
+	for i, p := range h.history {
+		finish := h.Period.next(p.Start)
+		if finish.After(end) {
+			continue
+		}
+		finished = i + 1
+		if !finish.After(start) || p.skipped() {
+			continue
+		}
+		done += p.Done
+		todo += p.Todo
+		hs.Total++
+		if success, _ := p.outcome(true); success {
+			hs.Completed++
+		}
+	}
+	if hs.Total == 0 {
+		return hs, false
+	}
+	hs.Streak = streakAt(h.history[:finished], true)
+
+	unit := h.Unit
+	if h.scheduled() {
+		unit = ""
+	}
+	hs.DoneText = formatAmount(roundAmount(done), unit)
+	hs.TodoText = formatAmount(roundAmount(todo), unit)
+	return hs, true
+}
+
+func (s summary) message(to string) (message, error) {
+	var buf bytes.Buffer
+	if err := summaryTemplate.Execute(&buf, s); err != nil {
+		return message{}, err
+	}
+	subject := "Your week in HabitCat"
+	if s.Period == PeriodMonth {
+		subject = "Your month in HabitCat"
+	}
+	return message{To: to, Subject: subject, Body: buf.String()}, nil
+}
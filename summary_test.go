@@ -0,0 +1,152 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testMailer keeps the sent messages instead of sending them.
+type testMailer struct {
+	sent []message
+}
+
+func (m *testMailer) Send(msg message) error {
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+func TestPeriodStart(t *testing.T) {
+	tests := []struct {
+		period   Period
+		expected string
+	}{
+		{PeriodDay, "2016-01-13"},
+		{PeriodWeek, "2016-01-11"},
+		{PeriodMonth, "2016-01-01"},
+	}
+	now := time.Date(2016, time.January, 13, 15, 30, 0, 0, time.UTC)
+	for _, test := range tests {
+		start := test.period.start(now)
+		if start.Format(dateFormat) != test.expected {
+			t.Errorf("Expected %v for %v, got %v", test.expected, test.period, start)
+		}
+		if !test.period.next(test.period.previous(start)).Equal(start) {
+			t.Errorf("Expected previous to undo next for %v", test.period)
+		}
+	}
+}
+
+func TestHabitSummarize(t *testing.T) {
+	h := habit{Description: "Read", Period: PeriodDay, Unit: "pages", Streak: 5}
+	for day := 1; day <= 10; day++ {
+		h.history = append(h.history, periodProgress{Start: date(2016, 1, day), Done: float64(day % 3), Todo: 1})
+	}
+	h.history[4].Paused = true
+
+	hs, ok := h.summarize(date(2016, 1, 4), date(2016, 1, 11))
+	if !ok {
+		t.Fatal("Expected a summary")
+	}
+	if hs.Total != 6 || hs.Completed != 4 {
+		t.Errorf("Expected 4 of 6 days, got %v of %v", hs.Completed, hs.Total)
+	}
+	if hs.DoneText != "5 pages" || hs.TodoText != "6 pages" {
+		t.Errorf("Expected 5 pages / 6 pages, got %v / %v", hs.DoneText, hs.TodoText)
+	}
+	if hs.Streak != 1 {
+		t.Errorf("Expected a streak of 1 at the end of the week, got %v", hs.Streak)
+	}
+
+	// the streak is the one of the summarized days, not the current one
+	hs, _ = h.summarize(date(2016, 1, 1), date(2016, 1, 9))
+	if hs.Streak != 2 {
+		t.Errorf("Expected a streak of 2 on Jan 8, got %v", hs.Streak)
+	}
+
+	if _, ok := h.summarize(date(2016, 2, 1), date(2016, 2, 8)); ok {
+		t.Error("Expected no summary without periods")
+	}
+}
+
+func TestSummaryMessage(t *testing.T) {
+	s := summary{
+		Period: PeriodWeek,
+		Start:  date(2016, 1, 4),
+		Habits: []habitSummary{{Description: "Run", Period: PeriodDay, DoneText: "5", TodoText: "7", Completed: 5, Total: 7, Streak: 3}},
+		Goals:  []goalSummary{{Description: "Learn Go", Advanced: 2, PointsDone: 4, PointsTotal: 10}},
+	}
+	m, err := s.message("test@habitcat.net")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Subject != "Your week in HabitCat" {
+		t.Errorf("Expected weekly subject, got %v", m.Subject)
+	}
+	for _, line := range []string{"week starting Mon Jan 4", "- Run: 5 / 7, 5 of 7 days done, streak of 3", "- Learn Go: +2, 4 / 10"} {
+		if !strings.Contains(m.Body, line) {
+			t.Errorf("Expected %q in %v", line, m.Body)
+		}
+	}
+}
+
+func TestOutboxMailer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "outbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	m := outboxMailer{dir: filepath.Join(dir, "mails"), from: "HabitCat <noreply@habitcat.net>"}
+	if err := m.Send(message{To: "test@habitcat.net", Subject: "Hi", Body: "one\ntwo\n"}); err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "mails", "*.eml"))
+	if len(files) != 1 {
+		t.Fatalf("Expected 1 mail, got %v", files)
+	}
+	b, _ := ioutil.ReadFile(files[0])
+	mail := string(b)
+	if !strings.Contains(mail, "To: test@habitcat.net\r\n") || !strings.HasSuffix(mail, "\r\n\r\none\r\ntwo\r\n") {
+		t.Errorf("Unexpected mail %q", mail)
+	}
+}
+
+func TestSendSummaries(t *testing.T) {
+	defer withTestStore()()
+	account, _ := CreateAccount(emailForTests, passwordForTests)
+	defer truncateDatabase()
+
+	mails := &testMailer{}
+	sender = mails
+
+	now := time.Now()
+	lastWeek := now.AddDate(0, 0, -7)
+	id, _ := createHabit(newHabit("Run", 1, PeriodWeek, now.AddDate(0, 0, -21)), account.Id)
+	createHabitProgress(*id, 1, &lastWeek)
+	if err := updateSettings(account.Id, &settings{Summary: PeriodWeek}, lastWeek); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sendSummaries(now); err != nil {
+		t.Fatal(err)
+	}
+	if len(mails.sent) != 1 {
+		t.Fatalf("Expected 1 mail, got %v", len(mails.sent))
+	}
+	if mails.sent[0].To != emailForTests || !strings.Contains(mails.sent[0].Body, "- Run: 1 / 1") {
+		t.Errorf("Unexpected mail %v", mails.sent[0])
+	}
+
+	// the summary of a period is sent only once
+	if err := sendSummaries(now); err != nil {
+		t.Fatal(err)
+	}
+	if len(mails.sent) != 1 {
+		t.Errorf("Expected no more mails, got %v", len(mails.sent))
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/context"
+)
+
+// maxSyncEvents caps the events of a sync request, the offline queue sends
+// longer ones in several requests.
+const maxSyncEvents = 100
+
+// The statuses of synced events. Rejected events can't ever be logged and
+// are dropped from the queue like the others.
+const (
+	syncApplied   = "applied"
+	syncDuplicate = "duplicate"
+	syncRejected  = "rejected"
+)
+
+// syncEvent is progress logged while offline, with the ID the browser gave
+// it and when it was logged there.
+type syncEvent struct {
+	Id      string    `json:"id"`
+	HabitId string    `json:"habit_id"`
+	Amount  *float64  `json:"amount"`
+	Note    string    `json:"note"`
+	Tags    []string  `json:"tags"`
+	Created time.Time `json:"created"`
+}
+
+type syncResult struct {
+	Id     string `json:"id"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// syncHandler logs the progress queued by the browser while it was
+// offline. It takes a JSON body like {"events": [{"id": "...", "habit_id":
+// "...", "amount": 1, "created": "2017-03-01T18:30:00Z"}]} and logs every
+// event once per ID, so a sync cut short can be sent again. It returns the
+// status of every event and the habits they were logged on.
+func syncHandler(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "POST" {
+		return methodNotAllowed()
+	}
+	var body struct {
+		Events []syncEvent `json:"events"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return badRequest(err)
+	}
+	if len(body.Events) > maxSyncEvents {
+		return validationErrors{"events": fmt.Sprintf("Can't be more than %d", maxSyncEvents)}
+	}
+
+	accountId := context.Get(r, "accountId").(string)
+	results, habits, err := syncProgress(accountId, body.Events, time.Now())
+	if err != nil {
+		return err
+	}
+	return renderJSON(w, struct {
+		Events []syncResult `json:"events"`
+		Habits []habit      `json:"habits"`
+	}{results, habits})
+}
+
+// syncProgress logs the events that weren't logged yet, in order. It
+// returns the habits of the events as they are after all of them.
+func syncProgress(accountId string, events []syncEvent, now time.Time) ([]syncResult, []habit, error) {
+	results := make([]syncResult, len(events))
+	current := make(map[string]*habit)
+	var order []string
+
+	for i, e := range events {
+		results[i].Id = e.Id
+		entry, reason := validateSyncEvent(e, now)
+		if reason != "" {
+			results[i].Status, results[i].Error = syncRejected, reason
+			continue
+		}
+
+		before, found := current[e.HabitId]
+		if !found {
+			h, err := getHabit(e.HabitId, accountId)
+			if err == errHabitNotFound {
+				results[i].Status, results[i].Error = syncRejected, "Habit not found"
+				continue
+			} else if err != nil {
+				return nil, nil, err
+			}
+			before = h
+			current[e.HabitId] = h
+			order = append(order, e.HabitId)
+		}
+
+		posted, err := postedProgress(accountId, e)
+		if err != nil {
+			return nil, nil, err
+		}
+		if posted {
+			results[i].Status = syncDuplicate
+			continue
+		}
+
+		logged, err := storage.SyncProgressEntry(accountId, e.Id, entry)
+		if err == errHabitNotFound {
+			results[i].Status, results[i].Error = syncRejected, "Habit not found"
+			continue
+		} else if err != nil {
+			return nil, nil, err
+		}
+		if !logged {
+			results[i].Status = syncDuplicate
+			continue
+		}
+		results[i].Status = syncApplied
+
+		after, err := getHabit(e.HabitId, accountId)
+		if err != nil {
+			return nil, nil, err
+		}
+		emitProgress(accountId, before, after, entry)
+		current[e.HabitId] = after
+	}
+
+	habits := make([]habit, len(order))
+	for i, id := range order {
+		habits[i] = *current[id]
+	}
+	return results, habits, nil
+}
+
+// postedProgress reports whether the event was posted to its habit with
+// its ID as the Idempotency-Key, the service worker queues progress whose
+// post failed and it may have made it anyway. Like a retry of the post,
+// the event is logged again once the key is no longer kept.
+func postedProgress(accountId string, e syncEvent) (bool, error) {
+	request := "POST /habits/" + e.HabitId
+	saved, err := storage.ClaimIdempotencyKey(accountId, e.Id, request, idempotencyRetention)
+	if err != nil {
+		return false, err
+	}
+	if saved == nil {
+		// the key was only looked up, the post it was sent with never
+		// made it
+		return false, storage.ReleaseIdempotencyKey(accountId, e.Id)
+	}
+	return saved.Request == request, nil
+}
+
+// validateSyncEvent returns the entry of the event, or why it can't be
+// logged. Events from clocks running ahead are logged now.
+func validateSyncEvent(e syncEvent, now time.Time) (*progressEntry, string) {
+	if e.Id == "" {
+		return nil, "ID missing"
+	} else if len(e.Id) > maxRequestIdLength {
+		return nil, "ID can't be longer than 100 characters"
+	}
+	if e.HabitId == "" {
+		return nil, "Habit missing"
+	} else if !validUUID(e.HabitId) {
+		return nil, "Habit not found"
+	}
+
+	form := url.Values{"note": {e.Note}, "tags": e.Tags}
+	if e.Amount != nil {
+		form.Set("amount", strconv.FormatFloat(*e.Amount, 'f', -1, 64))
+	}
+	entry, errs := validateProgressForm(form)
+	if errs != nil {
+		return nil, errs.join()
+	}
+
+	entry.HabitId = e.HabitId
+	switch {
+	case e.Created.IsZero() || e.Created.After(now):
+		entry.Created = now
+	case e.Created.Before(earliestStart):
+		return nil, "Logged too long ago"
+	default:
+		entry.Created = e.Created.In(now.Location())
+	}
+	return entry, ""
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/context"
+)
+
+type syncResponse struct {
+	Events []syncResult `json:"events"`
+	Habits []habit      `json:"habits"`
+}
+
+func postSync(t *testing.T, accountId, body string) syncResponse {
+	req, _ := http.NewRequest("POST", "https://localhost/sync", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	context.Set(req, "accountId", accountId)
+	defer context.Clear(req)
+
+	w := httptest.NewRecorder()
+	appHandler(syncHandler).ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %v: %s", w.Code, w.Body)
+	}
+	var resp syncResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func testSyncHandler(t *testing.T) {
+	account, _ := CreateAccount(emailForTests, passwordForTests)
+	other, _ := CreateAccount("other@habitcat.net", passwordForTests)
+	id, _ := createHabit(newHabit("Run", 5, PeriodWeek, time.Now().AddDate(0, 0, -14)), account.Id)
+	otherId, _ := createHabit(newHabit("Other", 1, PeriodWeek, time.Now()), other.Id)
+
+	created := time.Now().Add(-2 * time.Minute).UTC().Format(time.RFC3339)
+	body := `{"events": [
+		{"id": "a", "habit_id": "` + *id + `", "created": "` + created + `"},
+		{"id": "b", "habit_id": "` + *id + `", "amount": 2, "note": "Hills", "created": "` + created + `"},
+		{"id": "c", "habit_id": "` + *otherId + `"},
+		{"id": "d", "habit_id": "` + *id + `", "note": "` + strings.Repeat("a", maxNoteLength+1) + `"},
+		{"id": "e", "habit_id": "run"}
+	]}`
+
+	resp := postSync(t, account.Id, body)
+	statuses := []string{syncApplied, syncApplied, syncRejected, syncRejected, syncRejected}
+	for i, status := range statuses {
+		if resp.Events[i].Status != status {
+			t.Errorf("Expected %v for event %v, got %+v", status, i, resp.Events[i])
+		}
+	}
+	if len(resp.Habits) != 1 || resp.Habits[0].Id != *id || resp.Habits[0].Done != 3 {
+		t.Errorf("Expected the habit with 3 done, got %+v", resp.Habits)
+	}
+	entries, _ := storage.ProgressEntries(*id, account.Id)
+	if len(entries) != 2 || entries[0].Created.After(time.Now().Add(-time.Minute)) {
+		t.Errorf("Expected 2 entries logged when they were queued, got %+v", entries)
+	}
+
+	// sending the events again logs nothing
+	resp = postSync(t, account.Id, body)
+	if resp.Events[0].Status != syncDuplicate || resp.Events[1].Status != syncDuplicate {
+		t.Errorf("Expected duplicates, got %+v", resp.Events)
+	}
+	if len(resp.Habits) != 1 || resp.Habits[0].Done != 3 {
+		t.Errorf("Expected the habit still with 3 done, got %+v", resp.Habits)
+	}
+	if entries, _ := storage.ProgressEntries(*otherId, other.Id); len(entries) != 0 {
+		t.Errorf("Expected nothing logged on the habit of another account, got %+v", entries)
+	}
+}
+
+func TestSyncHandlerMemory(t *testing.T) {
+	_, restore := withMemoryStore()
+	defer restore()
+	testSyncHandler(t)
+}
+
+func TestSyncHandler(t *testing.T) {
+	requireDatabase(t)
+	defer truncateDatabase()
+	testSyncHandler(t)
+}
+
+func testSyncAfterPost(t *testing.T) {
+	account, _ := CreateAccount(emailForTests, passwordForTests)
+	id, _ := createHabit(newHabit("Run", 5, PeriodWeek, time.Now()), account.Id)
+
+	// the post made it, its response didn't and the event got queued
+	if w := postWithKey(account.Id, "/habits/"+*id, "q1", "amount=2", habitUpdateHandler); w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %v", w.Code)
+	}
+	resp := postSync(t, account.Id, `{"events": [{"id": "q1", "habit_id": "`+*id+`", "amount": 2}]}`)
+	if resp.Events[0].Status != syncDuplicate || len(resp.Habits) != 1 || resp.Habits[0].Done != 2 {
+		t.Errorf("Expected a duplicate and the habit with 2 done, got %+v", resp)
+	}
+	if entries, _ := storage.ProgressEntries(*id, account.Id); len(entries) != 1 {
+		t.Errorf("Expected 1 entry, got %+v", entries)
+	}
+}
+
+func TestSyncAfterPostMemory(t *testing.T) {
+	_, restore := withMemoryStore()
+	defer restore()
+	testSyncAfterPost(t)
+}
+
+func TestSyncAfterPost(t *testing.T) {
+	requireDatabase(t)
+	defer truncateDatabase()
+	testSyncAfterPost(t)
+}
+
+func TestSyncAfterExpiredPost(t *testing.T) {
+	s, restore := withMemoryStore()
+	defer restore()
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	account, _ := CreateAccount(emailForTests, passwordForTests)
+	id, _ := createHabit(newHabit("Run", 5, PeriodWeek, now), account.Id)
+
+	// like a retry of the post, the event is logged once the key is gone
+	postWithKey(account.Id, "/habits/"+*id, "q1", "amount=2", habitUpdateHandler)
+	now = now.Add(idempotencyRetention + time.Minute)
+	resp := postSync(t, account.Id, `{"events": [{"id": "q1", "habit_id": "`+*id+`", "amount": 2}]}`)
+	if resp.Events[0].Status != syncApplied {
+		t.Errorf("Expected the event to be applied, got %+v", resp.Events)
+	}
+
+	// looking for the post of an event doesn't keep its ID as a key
+	if w := postWithKey(account.Id, "/habits/"+*id, "q1", "amount=1", habitUpdateHandler); w.Header().Get("Idempotent-Replayed") != "" || w.Code != http.StatusOK {
+		t.Errorf("Expected the post to be handled, got %v", w.Code)
+	}
+}
+
+func TestValidateSyncEvent(t *testing.T) {
+	now := time.Now()
+	one := 1.0
+
+	entry, reason := validateSyncEvent(syncEvent{Id: "a", HabitId: uuidForTests, Amount: &one, Created: now.Add(time.Hour)}, now)
+	if reason != "" || !entry.Created.Equal(now) {
+		t.Errorf("Expected an entry logged now, got %+v %v", entry, reason)
+	}
+
+	tests := []syncEvent{
+		{HabitId: uuidForTests},
+		{Id: strings.Repeat("a", maxRequestIdLength+1), HabitId: uuidForTests},
+		{Id: "a"},
+		{Id: "a", HabitId: "h"},
+		{Id: "a", HabitId: uuidForTests, Note: strings.Repeat("a", maxNoteLength+1)},
+		{Id: "a", HabitId: uuidForTests, Created: date(1999, time.March, 1)},
+	}
+	for _, e := range tests {
+		if _, reason := validateSyncEvent(e, now); reason == "" {
+			t.Errorf("Expected %+v to be rejected", e)
+		}
+	}
+}
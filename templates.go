@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"path/filepath"
+	"sync"
+)
+
+const layoutTemplate = "layout.html"
+
+// templateSet holds every page template parsed together with the shared
+// layout. Templates are parsed once at startup; in reload mode they are
+// re-read from disk before each render so edits show up without a restart.
+type templateSet struct {
+	dir    string
+	reload bool
+
+	mu    sync.RWMutex
+	pages map[string]*template.Template
+}
+
+var templates *templateSet
+
+func loadTemplates(dir string, reload bool) (*templateSet, error) {
+	ts := &templateSet{dir: dir, reload: reload}
+	if err := ts.parse(); err != nil {
+		return nil, err
+	}
+	return ts, nil
+}
+
+func (ts *templateSet) parse() error {
+	layout, err := template.ParseFiles(filepath.Join(ts.dir, layoutTemplate))
+	if err != nil {
+		return err
+	}
+
+	filenames, err := filepath.Glob(filepath.Join(ts.dir, "*.html"))
+	if err != nil {
+		return err
+	}
+
+	pages := make(map[string]*template.Template)
+	for _, filename := range filenames {
+		name := filepath.Base(filename)
+		if name == layoutTemplate {
+			continue
+		}
+		t, err := layout.Clone()
+		if err != nil {
+			return err
+		}
+		if _, err := t.ParseFiles(filename); err != nil {
+			return err
+		}
+		pages[name] = t
+	}
+
+	ts.mu.Lock()
+	ts.pages = pages
+	ts.mu.Unlock()
+
+	return nil
+}
+
+// execute renders the page with the given name into w. Output is buffered so
+// a failing template doesn't leave a half written page behind.
+func (ts *templateSet) execute(w io.Writer, name string, data interface{}) error {
+	if ts.reload {
+		if err := ts.parse(); err != nil {
+			return err
+		}
+	}
+
+	ts.mu.RLock()
+	t, ok := ts.pages[name]
+	ts.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("template %s not found", name)
+	}
+
+	var buf bytes.Buffer
+	if err := t.ExecuteTemplate(&buf, layoutTemplate, data); err != nil {
+		return err
+	}
+	_, err := buf.WriteTo(w)
+	return err
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTemplatesEscapeHabitDescription(t *testing.T) {
+	habits := []habit{
+		habit{Id: "id1", Description: "<script>alert('xss')</script>", Todo: 2, Done: 1, PctDone: 50, Period: PeriodWeek, Start: time.Now()},
+	}
+	data := struct {
+		Habits            []habit
+		Categories        []habitCategory
+		Tag               string
+		ThisWeekDone      int
+		ThisWeekTodo      int
+		CurrentWeekNumber int
+		ThisWeekPctDone   int
+	}{
+		Habits:     habits,
+		Categories: groupHabits(habits),
+	}
+
+	var buf bytes.Buffer
+	if err := templates.execute(&buf, "habits.html", data); err != nil {
+		t.Fatal(err)
+	}
+
+	body := buf.String()
+	if strings.Contains(body, "<script>alert") {
+		t.Errorf("Expected description to be escaped, got %v", body)
+	}
+	if !strings.Contains(body, "&lt;script&gt;alert(&#39;xss&#39;)&lt;/script&gt;") {
+		t.Errorf("Expected escaped description in %v", body)
+	}
+}
+
+func TestTemplatesUseLayout(t *testing.T) {
+	var buf bytes.Buffer
+	if err := templates.execute(&buf, "login.html", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	body := buf.String()
+	if !strings.HasPrefix(body, "<!DOCTYPE html>") {
+		t.Errorf("Expected page to start with the layout, got %v", body)
+	}
+	if !strings.Contains(body, "<title>Habit Cat - Login</title>") {
+		t.Errorf("Expected login title, got %v", body)
+	}
+}
+
+func TestTemplatesNotFound(t *testing.T) {
+	var buf bytes.Buffer
+	if err := templates.execute(&buf, "missing.html", nil); err == nil {
+		t.Error("Expected error, got nil")
+	}
+}
+
+func TestTemplatesReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	layout := `{{template "content" .}}`
+	if err := ioutil.WriteFile(filepath.Join(dir, layoutTemplate), []byte(layout), 0644); err != nil {
+		t.Fatal(err)
+	}
+	page := filepath.Join(dir, "page.html")
+	if err := ioutil.WriteFile(page, []byte(`{{define "content"}}before{{end}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ts, err := loadTemplates(dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(page, []byte(`{{define "content"}}after{{end}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := ts.execute(&buf, "page.html", nil); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "after" {
+		t.Errorf("Expected after, got %v", buf.String())
+	}
+}
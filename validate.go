@@ -0,0 +1,373 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// validationErrors maps form field names to a message explaining what is
+// wrong with the submitted value.
+type validationErrors map[string]string
+
+func (e validationErrors) Error() string {
+	var fields []string
+	for field := range e {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return "invalid " + strings.Join(fields, ", ")
+}
+
+// join lists the fields with their messages, for reporting the errors of
+// one of several items as a single message.
+func (e validationErrors) join() string {
+	var msgs []string
+	for field, msg := range e {
+		msgs = append(msgs, field+": "+msg)
+	}
+	sort.Strings(msgs)
+	return strings.Join(msgs, ", ")
+}
+
+// formData is passed to the templates of forms so they can show the
+// submitted values next to the errors.
+type formData struct {
+	Values url.Values
+	Errors validationErrors
+}
+
+// Has reports whether value is one of the values submitted for field, for
+// checkboxes and multiple selects.
+func (f formData) Has(field, value string) bool {
+	for _, v := range f.Values[field] {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+const dateFormat = "2006-01-02"
+
+const maxUnitLength = 20
+
+const (
+	maxNoteLength = 500
+	maxTagLength  = 30
+	maxTags       = 10
+)
+
+var validPeriods = []Period{PeriodDay, PeriodWeek, PeriodMonth}
+
+// earliestStart keeps typos like 0216 out of the start date, a habit
+// starting centuries ago would make its history unreasonably long.
+var earliestStart = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// validateHabitForm checks a submitted habit. A missing start date means
+// the habit starts today.
+func validateHabitForm(form url.Values, today time.Time) (*habit, validationErrors) {
+	errs := validationErrors{}
+
+	description := strings.TrimSpace(form.Get("description"))
+	if description == "" {
+		errs["description"] = "Description can't be empty"
+	}
+
+	todo, msg := parseAmount(form.Get("todo"))
+	if msg != "" {
+		errs["todo"] = msg
+	}
+
+	unit := strings.TrimSpace(form.Get("unit"))
+	if len(unit) > maxUnitLength {
+		errs["unit"] = fmt.Sprintf("Can't be longer than %d characters", maxUnitLength)
+	}
+
+	category := strings.TrimSpace(form.Get("category"))
+	if len(category) > maxCategoryLength {
+		errs["category"] = fmt.Sprintf("Can't be longer than %d characters", maxCategoryLength)
+	}
+	tags, msg := parseTags(form["tags"])
+	if msg != "" {
+		errs["tags"] = msg
+	}
+
+	period := Period(form.Get("period"))
+	if !period.valid() {
+		errs["period"] = "Period must be a day, a week or a month"
+	}
+
+	start, msg := parseStartDate(form.Get("start"), today)
+	if msg != "" {
+		errs["start"] = msg
+	}
+
+	h := &habit{
+		Description: description,
+		Kind:        Kind(form.Get("kind")),
+		Category:    category,
+		Tags:        tags,
+		Unit:        unit,
+		Todo:        todo,
+		Period:      period,
+		Start:       start,
+		Schedule:    Schedule(form.Get("schedule")),
+	}
+	switch h.Schedule {
+	case "", SchedulePeriod:
+		h.Schedule = SchedulePeriod
+	case ScheduleWeekdays:
+		for _, v := range form["weekdays"] {
+			d, err := strconv.Atoi(v)
+			if err != nil || d < 0 || d > 6 {
+				errs["weekdays"] = "Unknown day of the week"
+				break
+			}
+			h.Weekdays = h.Weekdays.add(time.Weekday(d))
+		}
+		if h.Weekdays == 0 && errs["weekdays"] == "" {
+			errs["weekdays"] = "Pick at least one day"
+		}
+	case ScheduleInterval:
+		h.EveryDays, msg = parsePositiveInt(form.Get("every_days"))
+		if msg != "" {
+			errs["every_days"] = msg
+		}
+	default:
+		errs["schedule"] = "Unknown schedule"
+	}
+	if h.scheduled() {
+		h.PerOccurrence = todo
+	}
+
+	switch h.Kind {
+	case "", KindTarget:
+		h.Kind = KindTarget
+	case KindLimit:
+		if h.scheduled() {
+			errs["kind"] = "Limits apply to the whole period, they can't be scheduled on days"
+		}
+	default:
+		errs["kind"] = "Unknown kind of habit"
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return h, nil
+}
+
+func validateGoalForm(form url.Values) (*goal, validationErrors) {
+	errs := validationErrors{}
+
+	description := strings.TrimSpace(form.Get("description"))
+	if description == "" {
+		errs["description"] = "Description can't be empty"
+	}
+
+	todo, msg := parsePositiveInt(form.Get("todo"))
+	if msg != "" {
+		errs["todo"] = msg
+	}
+
+	var due *time.Time
+	if s := strings.TrimSpace(form.Get("due")); s != "" {
+		d, err := time.Parse(dateFormat, s)
+		if err != nil {
+			errs["due"] = "Must be a date like 2017-03-31"
+		} else if d.Before(earliestStart) {
+			errs["due"] = "Must be in the year 2000 or later"
+		}
+		due = &d
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return &goal{
+		Description: description,
+		PointsTotal: todo,
+		Due:         due,
+	}, nil
+}
+
+// validateProgressForm checks the amount, note and tags of a progress
+// update. Without an amount the habit goes up by one.
+func validateProgressForm(form url.Values) (*progressEntry, validationErrors) {
+	errs := validationErrors{}
+	entry := &progressEntry{Delta: 1}
+
+	if s := form.Get("amount"); s != "" {
+		var msg string
+		if entry.Delta, msg = parseAmount(s); msg != "" {
+			errs["amount"] = msg
+		}
+	}
+
+	entry.Note = strings.TrimSpace(form.Get("note"))
+	if len(entry.Note) > maxNoteLength {
+		errs["note"] = fmt.Sprintf("Can't be longer than %d characters", maxNoteLength)
+	}
+
+	var msg string
+	if entry.Tags, msg = parseTags(form["tags"]); msg != "" {
+		errs["tags"] = msg
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return entry, nil
+}
+
+// parseTags reads tags from comma separated lists, e.g. "chapter 3, #fun".
+// Duplicates are dropped.
+func parseTags(lists []string) ([]string, string) {
+	var tags []string
+	seen := make(map[string]bool)
+	for _, list := range lists {
+		for _, tag := range strings.Split(list, ",") {
+			tag = normalizeTag(tag)
+			if tag == "" || seen[tag] {
+				continue
+			}
+			if len(tag) > maxTagLength {
+				return nil, fmt.Sprintf("Tags can't be longer than %d characters", maxTagLength)
+			}
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	if len(tags) > maxTags {
+		return nil, fmt.Sprintf("Can't have more than %d tags", maxTags)
+	}
+	return tags, ""
+}
+
+// normalizeTag makes tags that only differ in case or a leading # the same.
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(tag), "#"))
+}
+
+func (p Period) valid() bool {
+	for _, v := range validPeriods {
+		if p == v {
+			return true
+		}
+	}
+	return false
+}
+
+// maxAmount is the largest amount numeric(12, 2) columns hold.
+const maxAmount = 9999999999.99
+
+// parseAmount parses a progress amount or target, which can have up to
+// two decimals, e.g. 2.5 hours.
+func parseAmount(s string) (float64, string) {
+	n, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || math.IsNaN(n) || math.IsInf(n, 0) {
+		return 0, "Must be a number like 2 or 2.5"
+	}
+	if n != roundAmount(n) {
+		return 0, "Can't have more than two decimals"
+	}
+	if n <= 0 {
+		return 0, "Must be greater than zero"
+	}
+	if n > maxAmount {
+		return 0, fmt.Sprintf("Can't be more than %.2f", maxAmount)
+	}
+	return n, ""
+}
+
+// parseWebhookURL parses the URL notifications are posted to. Names are
+// checked again when posting, as they can resolve to anything.
+func parseWebhookURL(s string) (string, string) {
+	s = strings.TrimSpace(s)
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return "", "Must be a URL like https://example.com/hook"
+	}
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	ip := net.ParseIP(host)
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") || (ip != nil && !publicIP(ip)) {
+		return "", "Must be a public address"
+	}
+	return s, ""
+}
+
+func parsePositiveInt(s string) (int, string) {
+	n, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil {
+		return 0, "Must be a whole number"
+	}
+	if n <= 0 {
+		return 0, "Must be greater than zero"
+	}
+	return n, ""
+}
+
+// validUUID reports whether s is a UUID like the IDs of habits and goals.
+// Postgres fails on comparing a uuid column with anything else.
+func validUUID(s string) bool {
+	if len(s) != 36 {
+		return false
+	}
+	for i, c := range s {
+		switch i {
+		case 8, 13, 18, 23:
+			if c != '-' {
+				return false
+			}
+		default:
+			if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func parseStartDate(s string, today time.Time) (time.Time, string) {
+	today = time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, time.UTC)
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return today, ""
+	}
+	start, err := time.Parse(dateFormat, s)
+	if err != nil {
+		return time.Time{}, "Must be a date like " + today.Format(dateFormat)
+	}
+	if start.Before(earliestStart) {
+		return time.Time{}, "Must be in the year 2000 or later"
+	}
+	if start.After(today.AddDate(1, 0, 0)) {
+		return time.Time{}, "Can't be more than a year from now"
+	}
+	return start, ""
+}
+
+// renderInvalidForm shows the form again with the submitted values and the
+// validation errors. API callers get the errors as JSON instead.
+func renderInvalidForm(w http.ResponseWriter, r *http.Request, name string, errs validationErrors) error {
+	if wantsJSON(r) {
+		return errs
+	}
+
+	var buf bytes.Buffer
+	if err := templates.execute(&buf, name, formData{r.PostForm, errs}); err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "text/html")
+	w.WriteHeader(http.StatusBadRequest)
+	_, err := buf.WriteTo(w)
+	return err
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+var today = time.Date(2016, time.January, 11, 15, 30, 0, 0, time.UTC)
+
+func TestValidateHabitFormSuccess(t *testing.T) {
+	form := url.Values{
+		"description": {"  Read  "},
+		"todo":        {"2.5"},
+		"unit":        {" hours "},
+		"period":      {"month"},
+		"category":    {" Learning "},
+		"tags":        {"books, #Evening"},
+	}
+	h, errs := validateHabitForm(form, today)
+	if errs != nil {
+		t.Fatalf("Expected nil, got %v", errs)
+	}
+	if h.Description != "Read" {
+		t.Errorf("Expected Read, got %v", h.Description)
+	}
+	if h.Todo != 2.5 {
+		t.Errorf("Expected 2.5, got %v", h.Todo)
+	}
+	if h.Unit != "hours" {
+		t.Errorf("Expected hours, got %v", h.Unit)
+	}
+	if h.Period != PeriodMonth {
+		t.Errorf("Expected %v, got %v", PeriodMonth, h.Period)
+	}
+	if h.Start.Format(dateFormat) != "2016-01-11" {
+		t.Errorf("Expected start to default to today, got %v", h.Start)
+	}
+	if h.Kind != KindTarget {
+		t.Errorf("Expected %v, got %v", KindTarget, h.Kind)
+	}
+	if h.Category != "Learning" {
+		t.Errorf("Expected Learning, got %v", h.Category)
+	}
+	if strings.Join(h.Tags, ",") != "books,evening" {
+		t.Errorf("Expected books,evening, got %v", h.Tags)
+	}
+}
+
+func TestValidateHabitFormErrors(t *testing.T) {
+	tests := []struct {
+		form  url.Values
+		field string
+	}{
+		{url.Values{"description": {" "}, "todo": {"1"}, "period": {"week"}}, "description"},
+		{url.Values{"description": {"d"}, "todo": {"abc"}, "period": {"week"}}, "todo"},
+		{url.Values{"description": {"d"}, "todo": {"0"}, "period": {"week"}}, "todo"},
+		{url.Values{"description": {"d"}, "todo": {"-2"}, "period": {"week"}}, "todo"},
+		{url.Values{"description": {"d"}, "todo": {"2.555"}, "period": {"week"}}, "todo"},
+		{url.Values{"description": {"d"}, "todo": {"1"}, "period": {"week"}, "unit": {"very long unit name indeed"}}, "unit"},
+		{url.Values{"description": {"d"}, "todo": {"1"}, "period": {"fortnight"}}, "period"},
+		{url.Values{"description": {"d"}, "todo": {"1"}, "period": {"week"}, "start": {"11/01/2016"}}, "start"},
+		{url.Values{"description": {"d"}, "todo": {"1"}, "period": {"week"}, "start": {"0216-01-11"}}, "start"},
+		{url.Values{"description": {"d"}, "todo": {"1"}, "period": {"week"}, "start": {"2017-01-12"}}, "start"},
+		{url.Values{"description": {"d"}, "todo": {"1"}, "period": {"week"}, "kind": {"maximum"}}, "kind"},
+		{url.Values{"description": {"d"}, "todo": {"1"}, "period": {"week"}, "category": {"a category name that is far too long"}}, "category"},
+		{url.Values{"description": {"d"}, "todo": {"1"}, "period": {"week"}, "kind": {"limit"}, "schedule": {"interval"}, "every_days": {"2"}}, "kind"},
+	}
+	for _, test := range tests {
+		h, errs := validateHabitForm(test.form, today)
+		if h != nil {
+			t.Errorf("Expected nil, got %v", h)
+		}
+		if len(errs) != 1 || errs[test.field] == "" {
+			t.Errorf("Expected error for %v, got %v", test.field, errs)
+		}
+	}
+}
+
+func TestValidateGoalFormErrors(t *testing.T) {
+	g, errs := validateGoalForm(url.Values{"description": {""}, "todo": {"0"}})
+	if g != nil {
+		t.Errorf("Expected nil, got %v", g)
+	}
+	if errs["description"] == "" || errs["todo"] == "" {
+		t.Errorf("Expected description and todo errors, got %v", errs)
+	}
+	if errs.Error() != "invalid description, todo" {
+		t.Errorf("Expected \"invalid description, todo\", got %v", errs.Error())
+	}
+}
+
+func TestValidateGoalFormDue(t *testing.T) {
+	g, errs := validateGoalForm(url.Values{"description": {"Read"}, "todo": {"12"}, "due": {"2017-12-31"}})
+	if errs != nil {
+		t.Fatal(errs)
+	}
+	if g.Due == nil || !g.Due.Equal(date(2017, time.December, 31)) {
+		t.Errorf("Expected 2017-12-31, got %v", g.Due)
+	}
+
+	for _, due := range []string{"31.12.2017", "1999-12-31"} {
+		_, errs := validateGoalForm(url.Values{"description": {"Read"}, "todo": {"12"}, "due": {due}})
+		if errs["due"] == "" {
+			t.Errorf("Expected an error for %v, got %v", due, errs)
+		}
+	}
+}
+
+func TestParseAmount(t *testing.T) {
+	if n, msg := parseAmount(" 2.5 "); n != 2.5 || msg != "" {
+		t.Errorf("Expected 2.5, got %v (%v)", n, msg)
+	}
+	if n, msg := parseAmount("9999999999.99"); n != maxAmount || msg != "" {
+		t.Errorf("Expected %v, got %v (%v)", maxAmount, n, msg)
+	}
+	for _, s := range []string{"", "0", "-1", "abc", "NaN", "Inf", "1.001", "10000000000", "1e20"} {
+		if _, msg := parseAmount(s); msg == "" {
+			t.Errorf("Expected error for %q", s)
+		}
+	}
+}
+
+func TestValidUUID(t *testing.T) {
+	id, _ := newId()
+	for _, s := range []string{id, uuidForTests, "6BA7B810-9DAD-11D1-80B4-00C04FD430C8"} {
+		if !validUUID(s) {
+			t.Errorf("Expected %q to be valid", s)
+		}
+	}
+	for _, s := range []string{"", "h", "6ba7b810-9dad-11d1-80b4-00c04fd430c", "6ba7b810x9dad-11d1-80b4-00c04fd430c8", "6ba7b810-9dad-11d1-80b4-00c04fd430cg"} {
+		if validUUID(s) {
+			t.Errorf("Expected %q to be invalid", s)
+		}
+	}
+}
+
+func TestValidateProgressForm(t *testing.T) {
+	form := url.Values{
+		"amount": {"2.5"},
+		"note":   {" chapter 3 "},
+		"tags":   {"Fiction, #fiction", "evening"},
+	}
+	entry, errs := validateProgressForm(form)
+	if errs != nil {
+		t.Fatalf("Expected nil, got %v", errs)
+	}
+	if entry.Delta != 2.5 {
+		t.Errorf("Expected 2.5, got %v", entry.Delta)
+	}
+	if entry.Note != "chapter 3" {
+		t.Errorf("Expected chapter 3, got %v", entry.Note)
+	}
+	if strings.Join(entry.Tags, ",") != "fiction,evening" {
+		t.Errorf("Expected fiction,evening, got %v", entry.Tags)
+	}
+
+	entry, errs = validateProgressForm(url.Values{})
+	if errs != nil {
+		t.Fatalf("Expected nil, got %v", errs)
+	}
+	if entry.Delta != 1 || entry.Tags != nil {
+		t.Errorf("Expected one without tags, got %v %v", entry.Delta, entry.Tags)
+	}
+}
+
+func TestValidateProgressFormErrors(t *testing.T) {
+	tests := []struct {
+		form  url.Values
+		field string
+	}{
+		{url.Values{"amount": {"lots"}}, "amount"},
+		{url.Values{"note": {strings.Repeat("a", maxNoteLength+1)}}, "note"},
+		{url.Values{"tags": {strings.Repeat("a", maxTagLength+1)}}, "tags"},
+		{url.Values{"tags": {"a,b,c,d,e,f,g,h,i,j,k"}}, "tags"},
+	}
+	for _, test := range tests {
+		entry, errs := validateProgressForm(test.form)
+		if entry != nil {
+			t.Errorf("Expected nil, got %v", entry)
+		}
+		if len(errs) != 1 || errs[test.field] == "" {
+			t.Errorf("Expected error for %v, got %v", test.field, errs)
+		}
+	}
+}
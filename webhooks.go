@@ -0,0 +1,288 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/context"
+)
+
+// webhook posts the events it's subscribed to to URL, signed with Secret.
+// The secret is only shown once, when the webhook is created.
+type webhook struct {
+	Id     string
+	URL    string
+	Secret string `json:"-"`
+	Events []string
+}
+
+// webhookDelivery is an event queued for a webhook and what happened when
+// posting it.
+type webhookDelivery struct {
+	Id             string
+	URL            string
+	Event          string
+	Status         string
+	Attempts       int
+	NextAttempt    time.Time
+	ResponseStatus int
+	Error          string
+	Created        time.Time
+	Delivered      *time.Time
+}
+
+var errWebhookNotFound = errors.New("webhook not found")
+
+const (
+	// maxWebhookAttempts is how many times a delivery is tried before it's
+	// given up.
+	maxWebhookAttempts = 8
+	// webhookLease is how long a delivery being posted is kept from other
+	// servers.
+	webhookLease = 5 * time.Minute
+	// maxClaimedDeliveries is how many deliveries a server posts at a time.
+	maxClaimedDeliveries = 50
+	// maxWebhookDeliveries is how many of the latest deliveries the log
+	// shows.
+	maxWebhookDeliveries = 100
+)
+
+var webhookClient = newWebhookClient()
+
+func webhooksHandler(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "GET" {
+		return methodNotAllowed()
+	}
+
+	accountId := context.Get(r, "accountId")
+	hooks, err := storage.Webhooks(accountId.(string))
+	if err != nil {
+		return err
+	}
+	data := struct {
+		Webhooks []webhook
+	}{
+		hooks,
+	}
+	return renderResponse(w, r, data, "webhooks.html")
+}
+
+func webhookNewHandler(w http.ResponseWriter, r *http.Request) error {
+	if r.Method == "GET" {
+		defaults := url.Values{"events": webhookEvents}
+		return renderTemplate(w, "webhooks_new.html", formData{Values: defaults})
+	} else if r.Method != "POST" {
+		return methodNotAllowed()
+	}
+
+	if err := r.ParseForm(); err != nil {
+		return badRequest(err)
+	}
+	hook, errs := validateWebhookForm(r.PostForm)
+	if errs != nil {
+		return renderInvalidForm(w, r, "webhooks_new.html", errs)
+	}
+
+	accountId := context.Get(r, "accountId")
+	if err := createWebhook(accountId.(string), hook); err != nil {
+		return err
+	}
+
+	// the secret isn't shown anymore after this
+	data := struct {
+		Webhook *webhook
+		Secret  string
+	}{
+		hook,
+		hook.Secret,
+	}
+	return renderResponse(w, r, data, "webhooks_created.html")
+}
+
+func webhookDeleteHandler(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "POST" {
+		return methodNotAllowed()
+	}
+	uuid := r.URL.Path[len("/webhooks/delete/"):]
+	if len(uuid) == 0 {
+		return badRequest(errors.New("webhook id missing"))
+	}
+
+	accountId := context.Get(r, "accountId")
+	if err := storage.DeleteWebhook(uuid, accountId.(string)); err == errWebhookNotFound {
+		return notFound(err)
+	} else if err != nil {
+		return err
+	}
+
+	http.Redirect(w, r, "/webhooks", http.StatusFound)
+	return nil
+}
+
+// webhookLogHandler lists the latest deliveries to the webhooks of the
+// account.
+func webhookLogHandler(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "GET" {
+		return methodNotAllowed()
+	}
+
+	accountId := context.Get(r, "accountId")
+	deliveries, err := storage.WebhookDeliveries(accountId.(string))
+	if err != nil {
+		return err
+	}
+	data := struct {
+		Deliveries []webhookDelivery
+	}{
+		deliveries,
+	}
+	return renderResponse(w, r, data, "webhooks_log.html")
+}
+
+func validateWebhookForm(form url.Values) (*webhook, validationErrors) {
+	errs := validationErrors{}
+
+	hook := &webhook{}
+	var msg string
+	if hook.URL, msg = parseWebhookURL(form.Get("url")); msg != "" {
+		errs["url"] = msg
+	}
+
+	for _, e := range form["events"] {
+		known := false
+		for _, name := range webhookEvents {
+			known = known || e == name
+		}
+		if !known {
+			errs["events"] = "Unknown event " + e
+			break
+		}
+		hook.Events = append(hook.Events, e)
+	}
+	if len(hook.Events) == 0 && errs["events"] == "" {
+		errs["events"] = "Pick at least one event"
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return hook, nil
+}
+
+// createWebhook saves the webhook with a new random secret, which is set on
+// the webhook for showing it this once.
+func createWebhook(accountId string, hook *webhook) error {
+	secret := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, secret); err != nil {
+		return err
+	}
+	hook.Secret = hex.EncodeToString(secret)
+
+	return storage.CreateWebhook(accountId, hook)
+}
+
+// pendingDelivery is a delivery claimed for posting.
+type pendingDelivery struct {
+	id       string
+	url      string
+	secret   string
+	event    string
+	payload  string
+	attempts int
+}
+
+// deliverWebhooks posts the deliveries that are due. Failed ones are tried
+// again later, backing off exponentially, until maxWebhookAttempts.
+func deliverWebhooks(now time.Time) error {
+	pending, err := storage.ClaimWebhookDeliveries(now, now.Add(webhookLease))
+	if err != nil {
+		return err
+	}
+
+	for _, d := range pending {
+		status, err := postWebhook(d)
+		if err := finishWebhookDelivery(d, status, err, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// postWebhook posts the payload of the delivery, signed with the secret of
+// its webhook in the X-HabitCat-Signature header, and returns the status
+// code of the response.
+func postWebhook(d pendingDelivery) (int, error) {
+	req, err := http.NewRequest("POST", d.url, strings.NewReader(d.payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-HabitCat-Event", d.event)
+	req.Header.Set("X-HabitCat-Delivery", d.id)
+	req.Header.Set("X-HabitCat-Signature", "sha256="+signPayload(d.secret, []byte(d.payload)))
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 1<<16))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("webhook responded %v", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// signPayload returns the hex encoded HMAC-SHA256 of the payload, which
+// receivers compute with the secret of the webhook to check that the
+// payload comes from us.
+func signPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookBackoff is how long to wait before the next attempt after the
+// given number of failed attempts: a minute, then doubling up to a day.
+func webhookBackoff(attempts int) time.Duration {
+	backoff := time.Minute
+	for i := 1; i < attempts && backoff < 24*time.Hour; i++ {
+		backoff *= 2
+	}
+	if backoff > 24*time.Hour {
+		backoff = 24 * time.Hour
+	}
+	return backoff
+}
+
+func finishWebhookDelivery(d pendingDelivery, responseStatus int, postErr error, now time.Time) error {
+	result := webhookDelivery{
+		Id:             d.id,
+		Status:         "sent",
+		Attempts:       d.attempts + 1,
+		NextAttempt:    now,
+		ResponseStatus: responseStatus,
+		Delivered:      &now,
+	}
+	if postErr != nil {
+		log.Printf("webhook delivery %v: %v", d.id, postErr)
+		result.Status, result.Error, result.Delivered = "pending", postErr.Error(), nil
+		result.NextAttempt = now.Add(webhookBackoff(result.Attempts))
+		if result.Attempts >= maxWebhookAttempts {
+			result.Status = "failed"
+		}
+	}
+	return storage.FinishWebhookDelivery(result)
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/context"
+)
+
+func TestSignPayload(t *testing.T) {
+	// echo -n '{"event":"test"}' | openssl dgst -sha256 -hmac secret
+	expected := "8419ab361b37d61b696d008ef7549a18325132dae5da84c7424e8e1c590d0498"
+	if s := signPayload("secret", []byte(`{"event":"test"}`)); s != expected {
+		t.Errorf("Expected %v, got %v", expected, s)
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		expected time.Duration
+	}{
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{4, 8 * time.Minute},
+		{20, 24 * time.Hour},
+	}
+	for _, test := range tests {
+		if backoff := webhookBackoff(test.attempts); backoff != test.expected {
+			t.Errorf("Expected %v after %v attempts, got %v", test.expected, test.attempts, backoff)
+		}
+	}
+}
+
+func TestValidateWebhookForm(t *testing.T) {
+	hook, errs := validateWebhookForm(url.Values{"url": {"https://example.com/hook"}, "events": {"goal.completed"}})
+	if errs != nil {
+		t.Fatalf("Expected nil, got %v", errs)
+	}
+	if hook.URL != "https://example.com/hook" || len(hook.Events) != 1 {
+		t.Errorf("Unexpected webhook %v", hook)
+	}
+
+	tests := []struct {
+		form  url.Values
+		field string
+	}{
+		{url.Values{"url": {"example.com"}, "events": {"goal.completed"}}, "url"},
+		{url.Values{"url": {"http://127.0.0.1:5432/"}, "events": {"goal.completed"}}, "url"},
+		{url.Values{"url": {"http://192.168.1.1/hook"}, "events": {"goal.completed"}}, "url"},
+		{url.Values{"url": {"https://example.com/hook"}}, "events"},
+		{url.Values{"url": {"https://example.com/hook"}, "events": {"habit.deleted"}}, "events"},
+	}
+	for _, test := range tests {
+		hook, errs := validateWebhookForm(test.form)
+		if hook != nil {
+			t.Errorf("Expected nil, got %v", hook)
+		}
+		if len(errs) != 1 || errs[test.field] == "" {
+			t.Errorf("Expected error for %v, got %v", test.field, errs)
+		}
+	}
+}
+
+func TestWebhookSecretNotInJSON(t *testing.T) {
+	b, err := json.Marshal(webhook{Id: "id1", URL: "https://example.com/hook", Secret: "s3cret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "s3cret") {
+		t.Errorf("Expected no secret in %s", b)
+	}
+}
+
+// withLoopbackWebhooks lets webhooks be posted to the test servers on
+// 127.0.0.1.
+func withLoopbackWebhooks() func() {
+	client := webhookClient
+	webhookClient = http.DefaultClient
+	return func() { webhookClient = client }
+}
+
+func TestBrokenStreak(t *testing.T) {
+	tests := []struct {
+		done   []float64
+		limit  bool
+		streak int
+		broken bool
+	}{
+		{[]float64{1, 1, 0, 0}, false, 2, true},
+		{[]float64{1, 1, 1, 0}, false, 0, false},
+		{[]float64{0, 0, 0}, false, 0, false},
+		{[]float64{1, 0, 1, 1}, false, 0, false},
+		{[]float64{0, 0, 3}, true, 2, true},
+		{[]float64{3, 0, 0}, true, 0, false},
+	}
+	for _, test := range tests {
+		var history []periodProgress
+		for i, done := range test.done {
+			history = append(history, periodProgress{Start: date(2016, time.January, 1+i), Done: done, Todo: 1, Limit: test.limit})
+		}
+		_, streak, broken := brokenStreak(history)
+		if streak != test.streak || broken != test.broken {
+			t.Errorf("Expected %v (%v) for %v, got %v (%v)", test.streak, test.broken, test.done, streak, broken)
+		}
+	}
+}
+
+func TestDeliverWebhooks(t *testing.T) {
+	defer withTestStore()()
+	account, _ := CreateAccount(emailForTests, passwordForTests)
+	defer truncateDatabase()
+	defer withLoopbackWebhooks()()
+
+	var got event
+	var signature string
+	hook := &webhook{Events: []string{eventPeriodCompleted}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		signature = r.Header.Get("X-HabitCat-Signature")
+		json.Unmarshal(body, &got)
+		if signature != "sha256="+signPayload(hook.Secret, body) {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+
+	hook.URL = server.URL
+	if err := createWebhook(account.Id, hook); err != nil {
+		t.Fatal(err)
+	}
+
+	id, _ := createHabit(newHabit("Run", 2, PeriodWeek, time.Now()), account.Id)
+	updateHabitProgress(*id, account.Id, &progressEntry{Delta: 1})
+	updateHabitProgress(*id, account.Id, &progressEntry{Delta: 1})
+	updateHabitProgress(*id, account.Id, &progressEntry{Delta: 1})
+
+	if err := deliverWebhooks(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if got.Event != eventPeriodCompleted {
+		t.Errorf("Expected %v, got %v", eventPeriodCompleted, got.Event)
+	}
+
+	deliveries, err := storage.WebhookDeliveries(account.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 || deliveries[0].Status != "sent" {
+		t.Errorf("Expected one sent delivery, got %v", deliveries)
+	}
+}
+
+func TestDeliverWebhooksRetries(t *testing.T) {
+	defer withTestStore()()
+	account, _ := CreateAccount(emailForTests, passwordForTests)
+	defer truncateDatabase()
+	defer withLoopbackWebhooks()()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	createWebhook(account.Id, &webhook{URL: server.URL, Events: []string{eventGoalCompleted}})
+	id, _ := createGoal(&goal{Description: "Goal", PointsTotal: 1}, account.Id)
+	updateGoalPoints(*id, account.Id)
+
+	now := time.Now()
+	if err := deliverWebhooks(now); err != nil {
+		t.Fatal(err)
+	}
+	deliveries, err := storage.WebhookDeliveries(account.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 {
+		t.Fatalf("Expected 1 delivery, got %v", len(deliveries))
+	}
+	d := deliveries[0]
+	if d.Status != "pending" || d.Attempts != 1 || d.ResponseStatus != http.StatusServiceUnavailable {
+		t.Errorf("Expected a pending delivery after a 503, got %v", d)
+	}
+	if d.NextAttempt.Sub(now) < time.Minute-time.Second {
+		t.Errorf("Expected the next attempt in a minute, got %v", d.NextAttempt)
+	}
+}
+
+func TestWebhookNewHandlerShowsSecretOnce(t *testing.T) {
+	defer withTestStore()()
+	account, _ := CreateAccount(emailForTests, passwordForTests)
+	defer truncateDatabase()
+
+	body := "url=https://example.com/hook&events=goal.completed"
+	req, _ := http.NewRequest("POST", "https://localhost/webhooks/new", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	context.Set(req, "accountId", account.Id)
+	defer context.Clear(req)
+
+	w := httptest.NewRecorder()
+	appHandler(webhookNewHandler).ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %v", w.Code)
+	}
+	// the secret is only read again to sign deliveries
+	var secret string
+	storage.QueueWebhookEvent(account.Id, eventGoalCompleted, "", "{}")
+	if pending, _ := storage.ClaimWebhookDeliveries(time.Now(), time.Now()); len(pending) == 1 {
+		secret = pending[0].secret
+	}
+	if secret == "" || !strings.Contains(w.Body.String(), secret) {
+		t.Errorf("Expected the secret %q in %v", secret, w.Body)
+	}
+
+	req, _ = http.NewRequest("GET", "https://localhost/webhooks", nil)
+	context.Set(req, "accountId", account.Id)
+	defer context.Clear(req)
+	w = httptest.NewRecorder()
+	appHandler(webhooksHandler).ServeHTTP(w, req)
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), secret) {
+		t.Errorf("Expected the list without the secret, got %v %v", w.Code, w.Body)
+	}
+}